@@ -0,0 +1,71 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AzureIdentityPolicySpec limits what the AzureIdentityTerminators in the selected namespaces may request.
+// Every policy that selects a namespace applies to it.
+type AzureIdentityPolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to. An empty selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// AllowedRoles are the names or IDs of the role definitions that may be assigned, compared
+	// case-insensitively with the role as it is written in the AzureIdentityTerminator. Empty allows any role.
+	// +optional
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+	// AllowedScopePrefixes are the prefixes of the scopes roles may be assigned over, such as
+	// /subscriptions/<subscription-id>/resourceGroups/team-a. A scope is allowed when either the scope
+	// as it is written or its full ID is one of them or lies beneath one, compared case-insensitively by whole
+	// path segments. Empty allows any scope.
+	// +optional
+	AllowedScopePrefixes []string `json:"allowedScopePrefixes,omitempty"`
+	// MaxClientSecretDuration is the longest clientSecretDuration that may be requested, such as 720h
+	// +optional
+	MaxClientSecretDuration string `json:"maxClientSecretDuration,omitempty"`
+	// MaxIdentitiesPerNamespace is how many AzureIdentityTerminators each selected namespace may have.
+	// The oldest ones are allowed when a namespace has more.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxIdentitiesPerNamespace *int32 `json:"maxIdentitiesPerNamespace,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName="azidpolicy"
+// +kubebuilder:printcolumn:name="MaxClientSecretDuration",type="string",JSONPath=".spec.maxClientSecretDuration",description="The longest ClientSecret life time allowed"
+// +kubebuilder:printcolumn:name="MaxIdentities",type="integer",JSONPath=".spec.maxIdentitiesPerNamespace",description="The most AzureIdentityTerminators allowed in each namespace"
+// AzureIdentityPolicy is the Schema for the azureidentitypolicies API
+type AzureIdentityPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AzureIdentityPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// AzureIdentityPolicyList contains a list of AzureIdentityPolicy
+type AzureIdentityPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AzureIdentityPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AzureIdentityPolicy{}, &AzureIdentityPolicyList{})
+}
@@ -17,41 +17,315 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // AzureIdentityTerminatorSpec defines the desired state of AzureIdentityTerminator
 type AzureIdentityTerminatorSpec struct {
-	AppRegistration   AppRegistration  `json:"appRegistration,omitempty"`
-	AzureIdentityName string           `json:"azureIdentityName"`
-	NodeResourceGroup string           `json:"nodeResourceGroup"`
-	PodSelector       string           `json:"podSelector"`
-	ServicePrincipal  ServicePrincipal `json:"servicePrincipal,omitempty"`
+	AppRegistration AppRegistration `json:"appRegistration,omitempty"`
+	// AzureIdentityName is the name of the AzureIdentity and AzureIdentityBinding in podIdentity mode
+	// +optional
+	AzureIdentityName string `json:"azureIdentityName,omitempty"`
+	// DeletionPolicy is what happens to the objects in Azure when the AzureIdentityTerminator is deleted.
+	// With Delete they are deleted, with Retain they are left as they are and with Disable the Service
+	// Principal can no longer sign in and its credentials are removed, but the Application is kept so
+	// that it can be restored. The objects in the cluster are deleted whatever the policy.
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// DriftPolicy is what the controller does when it finds the Application or Service Principal
+	// deleted outside the cluster. With report the AzureIdentityTerminator is marked Degraded and
+	// with recreate they are provisioned again.
+	// +kubebuilder:default=report
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// IdentityType selects the Azure identity bound to pods in podIdentity mode. A ServicePrincipal
+	// authenticates with a ClientSecret that is rotated before it expires. A UserAssignedMSI is a
+	// user-assigned managed identity that has no secret at all.
+	// +kubebuilder:default=ServicePrincipal
+	// +optional
+	IdentityType IdentityType `json:"identityType,omitempty"`
+	// Mode selects how pods authenticate as the Application. In podIdentity mode a ClientSecret is
+	// bound to pods through aad-pod-identity. In workloadIdentity mode pods exchange their
+	// ServiceAccount token through a federated identity credential and no ClientSecret is issued.
+	// +kubebuilder:default=podIdentity
+	// +optional
+	Mode              Mode   `json:"mode,omitempty"`
+	NodeResourceGroup string `json:"nodeResourceGroup"`
+	// PodSelector is the aadpodidbinding label value of the pods bound in podIdentity mode
+	// +optional
+	PodSelector string `json:"podSelector,omitempty"`
+	// RoleAssignments are the roles the Service Principal is assigned. Defaults to the Reader role
+	// over the nodeResourceGroup, which pods need to be bound to the Service Principal in podIdentity mode.
+	// +optional
+	RoleAssignments []RoleAssignmentSpec `json:"roleAssignments,omitempty"`
+	// SecretName is the name of the Secret holding the ClientSecret in podIdentity mode. Defaults to the
+	// name of the AzureIdentityTerminator.
+	// +optional
+	SecretName       string           `json:"secretName,omitempty"`
+	ServicePrincipal ServicePrincipal `json:"servicePrincipal,omitempty"`
+	// WorkloadIdentity configures the federated identity credential in workloadIdentity mode
+	// +optional
+	WorkloadIdentity *WorkloadIdentity `json:"workloadIdentity,omitempty"`
 }
 
 // AzureIdentityTerminatorStatus defines the observed state of AzureIdentityTerminator
 type AzureIdentityTerminatorStatus struct {
-	AppRegistration      AppRegistration  `json:"appRegistration,omitempty"`
-	AzureIdentityBinding string           `json:"azureIdentityBinding,omitempty"`
-	RoleAssignment       RoleAssignment   `json:"roleAssignment,omitempty"`
-	ServicePrincipal     ServicePrincipal `json:"servicePrincipal,omitempty"`
+	AppRegistration AppRegistration `json:"appRegistration,omitempty"`
+	// AzureIdentity is the name of the AzureIdentity created in podIdentity mode
+	AzureIdentity string `json:"azureIdentity,omitempty"`
+	// AzureIdentityBinding is the name of the AzureIdentityBinding created in podIdentity mode
+	AzureIdentityBinding string `json:"azureIdentityBinding,omitempty"`
+	// Conditions are the latest observations of each provisioning step of the AzureIdentityTerminator
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// FederatedIdentityCredential is the credential trusted by the Application in workloadIdentity mode
+	FederatedIdentityCredential FederatedIdentityCredential `json:"federatedIdentityCredential,omitempty"`
+	// LastAzureAuditTime is when the objects in Azure were last checked against the status
+	// +optional
+	LastAzureAuditTime *metav1.Time `json:"lastAzureAuditTime,omitempty"`
+	// ManagedIdentity is the user-assigned managed identity created when the identityType is UserAssignedMSI
+	ManagedIdentity ManagedIdentity `json:"managedIdentity,omitempty"`
+	// ObservedGeneration is the most recent generation of the spec that was fully reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Phase summarises the conditions of the AzureIdentityTerminator
+	Phase Phase `json:"phase,omitempty"`
+	// RoleAssignment is the Reader role assignment of terminators created before roleAssignments.
+	// It is moved into roleAssignments by the next reconcile.
+	RoleAssignment RoleAssignment `json:"roleAssignment,omitempty"`
+	// RoleAssignments are the roles the Service Principal has been assigned
+	// +optional
+	RoleAssignments []RoleAssignment `json:"roleAssignments,omitempty"`
+	// Secret is the name of the Secret holding the ClientSecret in podIdentity mode
+	Secret string `json:"secret,omitempty"`
+	// ServiceAccount is the ServiceAccount annotated with the ClientID in workloadIdentity mode
+	ServiceAccount   string           `json:"serviceAccount,omitempty"`
+	ServicePrincipal ServicePrincipal `json:"servicePrincipal,omitempty"`
+}
+
+// Mode is how pods authenticate as the Application of an AzureIdentityTerminator
+// +kubebuilder:validation:Enum=podIdentity;workloadIdentity
+type Mode string
+
+const (
+	ModePodIdentity      Mode = "podIdentity"
+	ModeWorkloadIdentity Mode = "workloadIdentity"
+)
+
+// IdentityType is the kind of Azure identity pods are bound to in podIdentity mode
+// +kubebuilder:validation:Enum=ServicePrincipal;UserAssignedMSI
+type IdentityType string
+
+const (
+	IdentityTypeServicePrincipal IdentityType = "ServicePrincipal"
+	IdentityTypeUserAssignedMSI  IdentityType = "UserAssignedMSI"
+)
+
+// DriftPolicy is how the controller handles objects deleted from Azure outside the cluster
+// +kubebuilder:validation:Enum=report;recreate
+type DriftPolicy string
+
+const (
+	DriftPolicyReport   DriftPolicy = "report"
+	DriftPolicyRecreate DriftPolicy = "recreate"
+)
+
+// DeletionPolicy is what happens to the objects in Azure when an AzureIdentityTerminator is deleted
+// +kubebuilder:validation:Enum=Delete;Retain;Disable
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete  DeletionPolicy = "Delete"
+	DeletionPolicyRetain  DeletionPolicy = "Retain"
+	DeletionPolicyDisable DeletionPolicy = "Disable"
+)
+
+// DeletionProtectionAnnotation blocks the deletion of an AzureIdentityTerminator while it is set to "true"
+const DeletionProtectionAnnotation = "azidterminator.io/deletion-protection"
+
+// DeletionProtected reports whether the DeletionProtectionAnnotation blocks the deletion of the AzureIdentityTerminator
+func (t *AzureIdentityTerminator) DeletionProtected() bool {
+	return t.Annotations[DeletionProtectionAnnotation] == "true"
 }
 
+// Phase is a high level summary of where the AzureIdentityTerminator is in its life cycle
+// +kubebuilder:validation:Enum=Pending;Provisioning;Ready;Degraded;Failed;Deleting
+type Phase string
+
+const (
+	PhasePending      Phase = "Pending"
+	PhaseProvisioning Phase = "Provisioning"
+	PhaseReady        Phase = "Ready"
+	PhaseDegraded     Phase = "Degraded"
+	PhaseFailed       Phase = "Failed"
+	PhaseDeleting     Phase = "Deleting"
+)
+
+// Condition types reported in the status of an AzureIdentityTerminator
+const (
+	// ConditionPolicyCompliant indicates the spec complies with every AzureIdentityPolicy selecting its namespace
+	ConditionPolicyCompliant = "PolicyCompliant"
+	// ConditionAppRegistered indicates the Azure AD Application has been registered
+	ConditionAppRegistered = "AppRegistered"
+	// ConditionManagedIdentityReady indicates the user-assigned managed identity has been created
+	ConditionManagedIdentityReady = "ManagedIdentityReady"
+	// ConditionServicePrincipalReady indicates the Service Principal has been created
+	ConditionServicePrincipalReady = "ServicePrincipalReady"
+	// ConditionRoleAssigned indicates the Service Principal has been assigned its role
+	ConditionRoleAssigned = "RoleAssigned"
+	// ConditionSecretSynced indicates the Secret holds the current ClientSecret
+	ConditionSecretSynced = "SecretSynced"
+	// ConditionFederatedCredentialReady indicates the federated identity credential has been created
+	ConditionFederatedCredentialReady = "FederatedCredentialReady"
+	// ConditionIdentityBound indicates the AzureIdentity and AzureIdentityBinding exist, or
+	// in workloadIdentity mode that the ServiceAccount has been annotated with the ClientID
+	ConditionIdentityBound = "IdentityBound"
+	// ConditionReady indicates every provisioning step has completed
+	ConditionReady = "Ready"
+	// ConditionSecretExpiringSoon indicates the ClientSecret is inside its rotation window
+	ConditionSecretExpiringSoon = "SecretExpiringSoon"
+	// ConditionDegraded indicates the Application or Service Principal was deleted from Azure
+	// outside the cluster and the driftPolicy does not allow recreating it
+	ConditionDegraded = "Degraded"
+	// ConditionDeleted indicates whether the objects of an AzureIdentityTerminator being deleted are gone.
+	// It is False with the failed step while a deletion is stuck.
+	ConditionDeleted = "Deleted"
+)
+
 type AppRegistration struct {
+	ClientID    *string `json:"clientID,omitempty"`
 	DisplayName string  `json:"displayName,omitempty"`
 	ObjectID    *string `json:"objectID,omitempty"`
+	TenantID    *string `json:"tenantID,omitempty"`
+}
+
+// DefaultRole is assigned over the nodeResourceGroup when the spec sets no role assignments
+const DefaultRole = "Reader"
+
+// EffectiveRoleAssignments returns the role assignments of the spec with each scope defaulted to the
+// nodeResourceGroup, or the DefaultRole over the nodeResourceGroup when the spec sets none
+func (s *AzureIdentityTerminatorSpec) EffectiveRoleAssignments() []RoleAssignmentSpec {
+	if len(s.RoleAssignments) == 0 {
+		return []RoleAssignmentSpec{{Role: DefaultRole, Scope: s.NodeResourceGroup}}
+	}
+
+	effective := make([]RoleAssignmentSpec, 0, len(s.RoleAssignments))
+	for _, ra := range s.RoleAssignments {
+		if ra.Scope == "" {
+			ra.Scope = s.NodeResourceGroup
+		}
+		effective = append(effective, ra)
+	}
+	return effective
+}
+
+// RoleAssignmentSpec grants the Service Principal a role over a scope
+type RoleAssignmentSpec struct {
+	// Role is the name of a role definition, such as Reader, or its ID
+	// +kubebuilder:validation:MinLength=1
+	Role string `json:"role"`
+	// Scope is the name of a resource group in the subscription of the operator, or the full ID of a
+	// resource or subscription such as /subscriptions/<subscription-id>. Defaults to the nodeResourceGroup.
+	// +optional
+	Scope string `json:"scope,omitempty"`
 }
 
 type RoleAssignment struct {
 	Name     *string `json:"name,omitempty"`
 	ObjectID *string `json:"objectID,omitempty"`
+	// Role is the role definition name or ID from the spec
+	Role string `json:"role,omitempty"`
+	// Scope is the scope from the spec, or the nodeResourceGroup when the spec leaves it out
+	Scope string `json:"scope,omitempty"`
 }
 
 type ServicePrincipal struct {
 	ClientSecretDuration   string       `json:"clientSecretDuration,omitempty"`
 	ClientSecretExpiration *metav1.Time `json:"clientSecretExpiration,omitempty"`
+	ClientSecretKeyID      *string      `json:"clientSecretKeyID,omitempty"`
+	LastRotationTime       *metav1.Time `json:"lastRotationTime,omitempty"`
 	ObjectID               *string      `json:"objectID,omitempty"`
-	Tags                   []string     `json:"tags,omitempty"`
+	// PreviousClientSecretKeyID is the credential replaced by the last rotation. It is
+	// removed from the Service Principal once the rotation grace period has passed.
+	PreviousClientSecretKeyID *string `json:"previousClientSecretKeyID,omitempty"`
+	// RotateBefore is how long before the ClientSecret expires that a new one is issued
+	RotateBefore string `json:"rotateBefore,omitempty"`
+	// RotationGracePeriod is how long the previous ClientSecret stays valid after a rotation
+	RotationGracePeriod string   `json:"rotationGracePeriod,omitempty"`
+	Tags                []string `json:"tags,omitempty"`
+}
+
+const (
+	// DefaultRotateBefore is used when servicePrincipal.rotateBefore is not set
+	DefaultRotateBefore = 72 * time.Hour
+	// DefaultRotationGracePeriod is used when servicePrincipal.rotationGracePeriod is not set
+	DefaultRotationGracePeriod = 24 * time.Hour
+)
+
+// RotationWindow returns how long before expiry the ClientSecret is rotated and how long the
+// previous ClientSecret is kept afterwards. The window never exceeds half of the secret's
+// lifetime so that a short-lived secret is not rotated on every reconcile, and the grace period
+// is kept shorter than the time between rotations so the previous ClientSecret is always removed
+// before the next rotation replaces it.
+func (sp *ServicePrincipal) RotationWindow() (rotateBefore time.Duration, gracePeriod time.Duration) {
+	rotateBefore = DefaultRotateBefore
+	if d, err := time.ParseDuration(sp.RotateBefore); err == nil && d > 0 {
+		rotateBefore = d
+	}
+
+	// A lifetime that does not parse is zero and leaves the window as configured
+	lifetime, _ := time.ParseDuration(sp.ClientSecretDuration)
+	if lifetime > 0 && rotateBefore > lifetime/2 {
+		rotateBefore = lifetime / 2
+	}
+
+	gracePeriod = DefaultRotationGracePeriod
+	if d, err := time.ParseDuration(sp.RotationGracePeriod); err == nil && d >= 0 {
+		gracePeriod = d
+	}
+
+	if lifetime > 0 && gracePeriod >= lifetime-rotateBefore {
+		gracePeriod = (lifetime - rotateBefore) / 2
+	}
+
+	return rotateBefore, gracePeriod
+}
+
+type ManagedIdentity struct {
+	// ClientID is the client ID of the managed identity, used by the AzureIdentity
+	ClientID *string `json:"clientID,omitempty"`
+	// Name is the name of the managed identity in the resource group the operator is configured with
+	Name string `json:"name,omitempty"`
+	// PrincipalID is the object ID of the service principal of the managed identity, which is assigned its roles
+	PrincipalID *string `json:"principalID,omitempty"`
+	// ResourceID is the Azure Resource Manager ID of the managed identity
+	ResourceID *string `json:"resourceID,omitempty"`
+}
+
+type WorkloadIdentity struct {
+	// Audiences are the audiences of the ServiceAccount token. Defaults to api://AzureADTokenExchange.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+	// Issuer is the OIDC issuer URL of the cluster. Defaults to the issuer the operator is configured with.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+	// ServiceAccountName is the ServiceAccount in the namespace of the AzureIdentityTerminator that
+	// is trusted by the federated identity credential. It is created if it does not exist.
+	ServiceAccountName string `json:"serviceAccountName"`
+}
+
+type FederatedIdentityCredential struct {
+	// Audiences are the audiences of the tokens the credential trusts
+	Audiences []string `json:"audiences,omitempty"`
+	// Issuer is the OIDC issuer of the tokens the credential trusts
+	Issuer   string  `json:"issuer,omitempty"`
+	Name     *string `json:"name,omitempty"`
+	ObjectID *string `json:"objectID,omitempty"`
+	Subject  string  `json:"subject,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -60,7 +334,10 @@ type ServicePrincipal struct {
 // +kubebuilder:printcolumn:name="AADApplication",type="string",JSONPath=".spec.appRegistration.displayName",description="The name of the Azure AD Application registered"
 // +kubebuilder:printcolumn:name="ClientSecretDuration",type="string",JSONPath=".spec.servicePrincipal.clientSecretDuration",description="The life time of the ClientSecret"
 // +kubebuilder:printcolumn:name="ClientSecretExp",type="string",JSONPath=".status.servicePrincipal.clientSecretExpiration",description="The time the ClientSecret will expire"
+// +kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.mode",description="How pods authenticate as the Azure AD Application"
+// +kubebuilder:printcolumn:name="IdentityType",type="string",JSONPath=".spec.identityType",description="The kind of Azure identity bound to pods",priority=1
 // +kubebuilder:printcolumn:name="PodSelector",type="string",JSONPath=".spec.podSelector",description="The selector that will bind pods to the AzureIdentityBinding"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="The life cycle phase of the AzureIdentityTerminator"
 // AzureIdentityTerminator is the Schema for the azureidentityterminators API
 type AzureIdentityTerminator struct {
 	metav1.TypeMeta   `json:",inline"`
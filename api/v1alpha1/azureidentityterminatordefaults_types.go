@@ -0,0 +1,61 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AzureIdentityTerminatorDefaultsSpec defines the values filled into AzureIdentityTerminators created in its namespace
+type AzureIdentityTerminatorDefaultsSpec struct {
+	// ClientSecretDuration is the default spec.servicePrincipal.clientSecretDuration
+	// +optional
+	ClientSecretDuration string `json:"clientSecretDuration,omitempty"`
+	// DisplayNamePrefix replaces the cluster name as the prefix of a defaulted spec.appRegistration.displayName
+	// +optional
+	DisplayNamePrefix string `json:"displayNamePrefix,omitempty"`
+	// NodeResourceGroup is the default resource group the Service Principal is assigned its role over
+	// +optional
+	NodeResourceGroup string `json:"nodeResourceGroup,omitempty"`
+	// Tags are the default spec.servicePrincipal.tags
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName="azidtdefaults"
+// +kubebuilder:printcolumn:name="ClientSecretDuration",type="string",JSONPath=".spec.clientSecretDuration",description="The default life time of the ClientSecret"
+// +kubebuilder:printcolumn:name="NodeResourceGroup",type="string",JSONPath=".spec.nodeResourceGroup",description="The default resource group of the role assignment"
+// AzureIdentityTerminatorDefaults is the Schema for the azureidentityterminatordefaults API
+type AzureIdentityTerminatorDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AzureIdentityTerminatorDefaultsSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// AzureIdentityTerminatorDefaultsList contains a list of AzureIdentityTerminatorDefaults
+type AzureIdentityTerminatorDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AzureIdentityTerminatorDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AzureIdentityTerminatorDefaults{}, &AzureIdentityTerminatorDefaultsList{})
+}
@@ -21,17 +21,28 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRegistration) DeepCopyInto(out *AppRegistration) {
 	*out = *in
+	if in.ClientID != nil {
+		in, out := &in.ClientID, &out.ClientID
+		*out = new(string)
+		**out = **in
+	}
 	if in.ObjectID != nil {
 		in, out := &in.ObjectID, &out.ObjectID
 		*out = new(string)
 		**out = **in
 	}
+	if in.TenantID != nil {
+		in, out := &in.TenantID, &out.TenantID
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRegistration.
@@ -44,6 +55,99 @@ func (in *AppRegistration) DeepCopy() *AppRegistration {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityPolicy) DeepCopyInto(out *AzureIdentityPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityPolicy.
+func (in *AzureIdentityPolicy) DeepCopy() *AzureIdentityPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureIdentityPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityPolicyList) DeepCopyInto(out *AzureIdentityPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AzureIdentityPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityPolicyList.
+func (in *AzureIdentityPolicyList) DeepCopy() *AzureIdentityPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureIdentityPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityPolicySpec) DeepCopyInto(out *AzureIdentityPolicySpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedRoles != nil {
+		in, out := &in.AllowedRoles, &out.AllowedRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedScopePrefixes != nil {
+		in, out := &in.AllowedScopePrefixes, &out.AllowedScopePrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxIdentitiesPerNamespace != nil {
+		in, out := &in.MaxIdentitiesPerNamespace, &out.MaxIdentitiesPerNamespace
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityPolicySpec.
+func (in *AzureIdentityPolicySpec) DeepCopy() *AzureIdentityPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureIdentityTerminator) DeepCopyInto(out *AzureIdentityTerminator) {
 	*out = *in
@@ -71,6 +175,84 @@ func (in *AzureIdentityTerminator) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityTerminatorDefaults) DeepCopyInto(out *AzureIdentityTerminatorDefaults) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityTerminatorDefaults.
+func (in *AzureIdentityTerminatorDefaults) DeepCopy() *AzureIdentityTerminatorDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityTerminatorDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureIdentityTerminatorDefaults) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityTerminatorDefaultsList) DeepCopyInto(out *AzureIdentityTerminatorDefaultsList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AzureIdentityTerminatorDefaults, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityTerminatorDefaultsList.
+func (in *AzureIdentityTerminatorDefaultsList) DeepCopy() *AzureIdentityTerminatorDefaultsList {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityTerminatorDefaultsList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureIdentityTerminatorDefaultsList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureIdentityTerminatorDefaultsSpec) DeepCopyInto(out *AzureIdentityTerminatorDefaultsSpec) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityTerminatorDefaultsSpec.
+func (in *AzureIdentityTerminatorDefaultsSpec) DeepCopy() *AzureIdentityTerminatorDefaultsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureIdentityTerminatorDefaultsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureIdentityTerminatorList) DeepCopyInto(out *AzureIdentityTerminatorList) {
 	*out = *in
@@ -107,7 +289,17 @@ func (in *AzureIdentityTerminatorList) DeepCopyObject() runtime.Object {
 func (in *AzureIdentityTerminatorSpec) DeepCopyInto(out *AzureIdentityTerminatorSpec) {
 	*out = *in
 	in.AppRegistration.DeepCopyInto(&out.AppRegistration)
+	if in.RoleAssignments != nil {
+		in, out := &in.RoleAssignments, &out.RoleAssignments
+		*out = make([]RoleAssignmentSpec, len(*in))
+		copy(*out, *in)
+	}
 	in.ServicePrincipal.DeepCopyInto(&out.ServicePrincipal)
+	if in.WorkloadIdentity != nil {
+		in, out := &in.WorkloadIdentity, &out.WorkloadIdentity
+		*out = new(WorkloadIdentity)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureIdentityTerminatorSpec.
@@ -124,7 +316,27 @@ func (in *AzureIdentityTerminatorSpec) DeepCopy() *AzureIdentityTerminatorSpec {
 func (in *AzureIdentityTerminatorStatus) DeepCopyInto(out *AzureIdentityTerminatorStatus) {
 	*out = *in
 	in.AppRegistration.DeepCopyInto(&out.AppRegistration)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.FederatedIdentityCredential.DeepCopyInto(&out.FederatedIdentityCredential)
+	if in.LastAzureAuditTime != nil {
+		in, out := &in.LastAzureAuditTime, &out.LastAzureAuditTime
+		*out = (*in).DeepCopy()
+	}
+	in.ManagedIdentity.DeepCopyInto(&out.ManagedIdentity)
 	in.RoleAssignment.DeepCopyInto(&out.RoleAssignment)
+	if in.RoleAssignments != nil {
+		in, out := &in.RoleAssignments, &out.RoleAssignments
+		*out = make([]RoleAssignment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	in.ServicePrincipal.DeepCopyInto(&out.ServicePrincipal)
 }
 
@@ -138,6 +350,66 @@ func (in *AzureIdentityTerminatorStatus) DeepCopy() *AzureIdentityTerminatorStat
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederatedIdentityCredential) DeepCopyInto(out *FederatedIdentityCredential) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Name != nil {
+		in, out := &in.Name, &out.Name
+		*out = new(string)
+		**out = **in
+	}
+	if in.ObjectID != nil {
+		in, out := &in.ObjectID, &out.ObjectID
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederatedIdentityCredential.
+func (in *FederatedIdentityCredential) DeepCopy() *FederatedIdentityCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(FederatedIdentityCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedIdentity) DeepCopyInto(out *ManagedIdentity) {
+	*out = *in
+	if in.ClientID != nil {
+		in, out := &in.ClientID, &out.ClientID
+		*out = new(string)
+		**out = **in
+	}
+	if in.PrincipalID != nil {
+		in, out := &in.PrincipalID, &out.PrincipalID
+		*out = new(string)
+		**out = **in
+	}
+	if in.ResourceID != nil {
+		in, out := &in.ResourceID, &out.ResourceID
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedIdentity.
+func (in *ManagedIdentity) DeepCopy() *ManagedIdentity {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedIdentity)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RoleAssignment) DeepCopyInto(out *RoleAssignment) {
 	*out = *in
@@ -163,6 +435,21 @@ func (in *RoleAssignment) DeepCopy() *RoleAssignment {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoleAssignmentSpec) DeepCopyInto(out *RoleAssignmentSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleAssignmentSpec.
+func (in *RoleAssignmentSpec) DeepCopy() *RoleAssignmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RoleAssignmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServicePrincipal) DeepCopyInto(out *ServicePrincipal) {
 	*out = *in
@@ -170,11 +457,25 @@ func (in *ServicePrincipal) DeepCopyInto(out *ServicePrincipal) {
 		in, out := &in.ClientSecretExpiration, &out.ClientSecretExpiration
 		*out = (*in).DeepCopy()
 	}
+	if in.ClientSecretKeyID != nil {
+		in, out := &in.ClientSecretKeyID, &out.ClientSecretKeyID
+		*out = new(string)
+		**out = **in
+	}
+	if in.LastRotationTime != nil {
+		in, out := &in.LastRotationTime, &out.LastRotationTime
+		*out = (*in).DeepCopy()
+	}
 	if in.ObjectID != nil {
 		in, out := &in.ObjectID, &out.ObjectID
 		*out = new(string)
 		**out = **in
 	}
+	if in.PreviousClientSecretKeyID != nil {
+		in, out := &in.PreviousClientSecretKeyID, &out.PreviousClientSecretKeyID
+		*out = new(string)
+		**out = **in
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make([]string, len(*in))
@@ -191,3 +492,23 @@ func (in *ServicePrincipal) DeepCopy() *ServicePrincipal {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadIdentity) DeepCopyInto(out *WorkloadIdentity) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadIdentity.
+func (in *WorkloadIdentity) DeepCopy() *WorkloadIdentity {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadIdentity)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+// ReasonDeletedInAzure is used when an object recorded in the status no longer exists in Azure
+const ReasonDeletedInAzure = "DeletedInAzure"
+
+// auditDue reports whether the objects in Azure should be checked against the status of the AzureIdentityTerminator.
+// A Degraded AzureIdentityTerminator is audited straight away once its driftPolicy allows recreating what was deleted.
+func (r *AzureIdentityTerminatorReconciler) auditDue(t *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	if r.AzureResyncInterval <= 0 {
+		return false
+	}
+	if t.Spec.DriftPolicy == terminatorv1alpha1.DriftPolicyRecreate && meta.IsStatusConditionTrue(t.Status.Conditions, terminatorv1alpha1.ConditionDegraded) {
+		return true
+	}
+	return r.untilNextAudit(t) <= 0
+}
+
+// untilNextAudit returns how long until the objects in Azure are next checked, or zero when auditing is disabled
+func (r *AzureIdentityTerminatorReconciler) untilNextAudit(t *terminatorv1alpha1.AzureIdentityTerminator) time.Duration {
+	if r.AzureResyncInterval <= 0 || t.Status.LastAzureAuditTime == nil {
+		return 0
+	}
+	return time.Until(t.Status.LastAzureAuditTime.Add(r.AzureResyncInterval))
+}
+
+// AuditAzure looks up each object recorded in the status by its ID to find changes made in Azure
+// outside the cluster. A missing role assignment or ClientSecret is always replaced, and so is a federated identity
+// credential that was deleted or made to trust other tokens. A missing
+// Application, Service Principal or managed identity marks the AzureIdentityTerminator Degraded, unless the
+// driftPolicy is recreate, in which case they are forgotten so that Provision creates them again.
+// A non-zero result means the reconcile should end with it.
+func (r *AzureIdentityTerminatorReconciler) AuditAzure(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) (ctrl.Result, error) {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	aadApp := r.AppForTerminator(t)
+
+	if aadApp.ObjectID != "" {
+		found, err := r.Azure.GetApplication(ctx, aadApp)
+		if err != nil {
+			return r.failAudit(ctx, t, "Failed to look up Azure AD Application "+aadApp.ObjectID, err)
+		}
+		if !found {
+			return r.driftDetected(ctx, t, "Azure AD Application "+aadApp.ObjectID, r.forgetApplication)
+		}
+	}
+
+	if mi := aadApp.ManagedIdentity; mi.ResourceID != "" {
+		found, err := r.Azure.GetManagedIdentity(ctx, aadApp)
+		if err != nil {
+			return r.failAudit(ctx, t, "Failed to look up managed identity "+mi.ResourceID, err)
+		}
+		if !found {
+			return r.driftDetected(ctx, t, "Managed identity "+mi.Name, r.forgetManagedIdentity)
+		}
+	}
+
+	if aadApp.ServicePrincipal.ObjectID != "" {
+		found, err := r.Azure.GetServicePrincipal(ctx, aadApp)
+		if err != nil {
+			return r.failAudit(ctx, t, "Failed to look up Service Principal "+aadApp.ServicePrincipal.ObjectID, err)
+		}
+		if !found {
+			return r.driftDetected(ctx, t, "Service Principal "+aadApp.ServicePrincipal.ObjectID, r.forgetServicePrincipal)
+		}
+
+		if keyID := t.Status.ServicePrincipal.ClientSecretKeyID; keyID != nil && !containsString(aadApp.ServicePrincipal.PasswordKeyIDs, *keyID) {
+			log.Info("ClientSecret was removed from Service Principal", "keyID", *keyID)
+			if err := r.discardClientSecret(ctx, t); err != nil {
+				return r.failAudit(ctx, t, "Failed to discard the removed ClientSecret "+*keyID, err)
+			}
+
+			// The next reconcile finds the Secret empty and replaces the ClientSecret
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	for i := range aadApp.RoleAssignments {
+		ra := &aadApp.RoleAssignments[i]
+		if ra.ObjectID == "" {
+			continue
+		}
+
+		found, err := r.Azure.GetRoleAssignment(ctx, aadApp, ra)
+		if err != nil {
+			return r.failAudit(ctx, t, "Failed to look up role assignment "+ra.ObjectID, err)
+		}
+		if found {
+			continue
+		}
+
+		log.Info("Recreating deleted role assignment", "roleAssignment.Name", ra.Name)
+		if err := r.Azure.CreateRoleAssignment(ctx, aadApp, ra); err != nil {
+			log.Error(err, "Failed to recreate role assignment", "roleAssignment.Name", ra.Name)
+			return resultForError(r.failRoleAssignment(ctx, t, aadApp, ra, err))
+		}
+
+		if assigned := assignedRole(t, ra.Role, ra.Scope); assigned != nil {
+			assigned.ObjectID = to.StringPtr(ra.ObjectID)
+		}
+		r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Recreated the "+ra.Role+" role assignment over "+ra.Scope+" that was deleted from Azure")
+	}
+
+	if fic := aadApp.FederatedIdentityCredential; fic.ObjectID != "" {
+		found := fic
+		exists, err := r.Azure.GetFederatedIdentityCredential(ctx, aadApp, &found)
+		if err != nil {
+			return r.failAudit(ctx, t, "Failed to look up federated identity credential "+fic.Name, err)
+		}
+
+		// Terminators provisioned before the status recorded the issuer are updated by Provision regardless
+		recorded := recordedFederatedIdentityCredential(t)
+		if !exists || (recorded.Issuer != "" && !found.Trusts(recorded)) {
+			log.Info("Federated identity credential was deleted or changed in Azure", "federatedIdentityCredential.Name", fic.Name)
+			r.recordEvent(t, corev1.EventTypeWarning, EventDriftDetected, "Federated identity credential "+fic.Name+" was deleted or changed in Azure and is being restored")
+
+			// Provision creates the credential again, or updates it once the status no longer records what it trusts
+			if !exists {
+				t.Status.FederatedIdentityCredential = terminatorv1alpha1.FederatedIdentityCredential{}
+			} else {
+				t.Status.FederatedIdentityCredential.Issuer = ""
+				t.Status.FederatedIdentityCredential.Audiences = nil
+			}
+			meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionFederatedCredentialReady)
+		}
+	}
+
+	log.Info("Azure resources are in sync with the status")
+	t.Status.LastAzureAuditTime = &v1.Time{Time: time.Now()}
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionDegraded)
+	return ctrl.Result{}, r.updateStatus(ctx, t)
+}
+
+// failAudit records a Warning event for an audit that could not complete and returns the error to retry it
+func (r *AzureIdentityTerminatorReconciler) failAudit(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, message string, err error) (ctrl.Result, error) {
+	r.Log.Error(err, message, "AzureIdentityTerminator.Name", t.Name)
+	r.recordEvent(t, corev1.EventTypeWarning, EventAuditFailed, message+": "+err.Error())
+	return ctrl.Result{}, err
+}
+
+// driftDetected handles an Application or Service Principal deleted outside the cluster according to the
+// driftPolicy, either marking the AzureIdentityTerminator Degraded or calling forget to provision it again
+func (r *AzureIdentityTerminatorReconciler) driftDetected(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, object string, forget func(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error) (ctrl.Result, error) {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	t.Status.LastAzureAuditTime = &v1.Time{Time: time.Now()}
+
+	if t.Spec.DriftPolicy != terminatorv1alpha1.DriftPolicyRecreate {
+		log.Info("Object was deleted from Azure", "object", object)
+		setCondition(t, terminatorv1alpha1.ConditionDegraded, v1.ConditionTrue, ReasonDeletedInAzure, object+" was deleted from Azure")
+		r.recordEvent(t, corev1.EventTypeWarning, EventDriftDetected, object+" was deleted from Azure, set spec.driftPolicy to recreate to provision it again")
+		return ctrl.Result{RequeueAfter: r.AzureResyncInterval}, r.updateStatus(ctx, t)
+	}
+
+	log.Info("Recreating object deleted from Azure", "object", object)
+	if err := forget(ctx, t); err != nil {
+		return r.failAudit(ctx, t, "Failed to forget "+object, err)
+	}
+
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionDegraded)
+	r.recordEvent(t, corev1.EventTypeWarning, EventDriftDetected, object+" was deleted from Azure and is being provisioned again")
+	if err := r.updateStatus(ctx, t); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// forgetApplication clears the Application and everything that depends on it from the status
+func (r *AzureIdentityTerminatorReconciler) forgetApplication(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	if err := r.forgetServicePrincipal(ctx, t); err != nil {
+		return err
+	}
+
+	t.Status.AppRegistration.ObjectID = nil
+	t.Status.AppRegistration.ClientID = nil
+	t.Status.AppRegistration.TenantID = nil
+	t.Status.FederatedIdentityCredential.ObjectID = nil
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionAppRegistered)
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionFederatedCredentialReady)
+	return nil
+}
+
+// forgetServicePrincipal clears the Service Principal, its role assignments and its ClientSecret from the
+// status. The role assignments of a deleted Service Principal can no longer be used, so they are deleted.
+func (r *AzureIdentityTerminatorReconciler) forgetServicePrincipal(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	if err := r.deleteRoleAssignments(ctx, t, r.AppForTerminator(t)); err != nil {
+		return err
+	}
+
+	if err := r.discardClientSecret(ctx, t); err != nil {
+		return err
+	}
+
+	t.Status.ServicePrincipal.ObjectID = nil
+	t.Status.ServicePrincipal.ClientSecretKeyID = nil
+	t.Status.ServicePrincipal.ClientSecretExpiration = nil
+	t.Status.ServicePrincipal.PreviousClientSecretKeyID = nil
+	t.Status.ServicePrincipal.LastRotationTime = nil
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionServicePrincipalReady)
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned)
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionSecretSynced)
+	return nil
+}
+
+// discardClientSecret empties the Secret of a ClientSecret that no longer exists in Azure,
+// so that provisioning adds a new one in its place
+func (r *AzureIdentityTerminatorReconciler) discardClientSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	if isWorkloadIdentity(t) {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: secretName(t), Namespace: t.Namespace}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if _, ok := secret.Data[clientSecretKey]; !ok || controlledByOther(t, secret) {
+		return nil
+	}
+
+	delete(secret.Data, clientSecretKey)
+	delete(secret.Annotations, clientSecretKeyIDAnnotation)
+	delete(secret.Annotations, clientSecretExpirationAnnotation)
+	return r.Update(ctx, secret)
+}
@@ -18,18 +18,23 @@ package controllers
 
 import (
 	"context"
+	"reflect"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
 	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
@@ -41,19 +46,34 @@ type AzureIdentityTerminatorReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Azure manages the Azure AD and Azure Resource Manager objects of each AzureIdentityTerminator
+	Azure azuread.IdentityProvider
+	// SubscriptionID resolves the resource group scopes that AzureIdentityPolicies allow by their full ID
+	SubscriptionID string
+	// Recorder records the progress of each AzureIdentityTerminator as events on it
+	Recorder record.EventRecorder
+	// OIDCIssuerURL is the OIDC issuer of the cluster trusted by federated identity credentials in workloadIdentity mode
+	OIDCIssuerURL string
+	// AzureResyncInterval is how often the objects in Azure are checked for changes made outside the cluster. Zero disables the check.
+	AzureResyncInterval time.Duration
+	// ManagedIdentityResourceGroup is the resource group user-assigned managed identities are created in
+	ManagedIdentityResourceGroup string
+	// ManagedIdentityLocation is the Azure region user-assigned managed identities are created in
+	ManagedIdentityLocation string
 }
 
 // +kubebuilder:rbac:groups=azidterminator.io,resources=azureidentityterminators,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=azidterminator.io,resources=azureidentityterminators/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=azidterminator.io,resources=azureidentityterminators/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=aadpodidentity.k8s.io,resources=azureidentities;azureidentitybindings,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *AzureIdentityTerminatorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("AzureIdentityTerminator", req.NamespacedName)
-	ctx = context.Background()
 
 	// Fetch the AzureIdentityTerminator instance
 	terminator := &terminatorv1alpha1.AzureIdentityTerminator{}
@@ -64,6 +84,7 @@ func (r *AzureIdentityTerminatorReconciler) Reconcile(ctx context.Context, req c
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			log.Info("AzureIdentityTerminator resource not found. Ignoring since object must be deleted")
+			terminatorMetrics.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -71,6 +92,9 @@ func (r *AzureIdentityTerminatorReconciler) Reconcile(ctx context.Context, req c
 		return ctrl.Result{}, err
 	}
 
+	migrateRoleAssignment(terminator)
+	migrateResourceNames(terminator)
+
 	// Examine DeletionTimestamp to determine if object is under deletion
 	const finalizer string = "finalizer.azure-identity-terminator.io"
 	if terminator.ObjectMeta.DeletionTimestamp.IsZero() {
@@ -88,7 +112,19 @@ func (r *AzureIdentityTerminatorReconciler) Reconcile(ctx context.Context, req c
 		// The object is being deleted
 		log.Info("Deleting the object and its associated resources", "AzureIdentityTerminator.Name", terminator.Name)
 		if containsString(terminator.ObjectMeta.Finalizers, finalizer) {
-			if err := r.DeleteResources(terminator); err != nil {
+			// Keep the finalizer, and with it every object, until the deletion protection is lifted
+			if terminator.DeletionProtected() {
+				return ctrl.Result{}, r.blockDeletion(ctx, terminator)
+			}
+
+			if terminator.Status.Phase != terminatorv1alpha1.PhaseDeleting {
+				if err := r.updateStatus(ctx, terminator); err != nil {
+					return ctrl.Result{}, err
+				}
+				r.recordEvent(terminator, corev1.EventTypeNormal, EventDeleting, "Deleting the Azure and Kubernetes resources of the AzureIdentityTerminator")
+			}
+
+			if err := r.DeleteResources(ctx, terminator); err != nil {
 				return ctrl.Result{}, err
 			}
 
@@ -98,85 +134,52 @@ func (r *AzureIdentityTerminatorReconciler) Reconcile(ctx context.Context, req c
 			}
 
 			log.Info("Successfully deleted all resources", "AzureIdentityTerminator.Name", terminator.Name)
+			terminatorMetrics.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 	}
 
-	aadID := &aadpodv1.AzureIdentity{}
-	err = r.Get(ctx, types.NamespacedName{Name: terminator.Name, Namespace: terminator.Namespace}, aadID)
-	if err != nil && errors.IsNotFound(err) {
-
-		// Create the Azure AD Application that the AzureIdentity will leverage
-		log.Info("Creating a new Azure AD App Registration", "appRegistration.displayName.", terminator.Spec.AppRegistration.DisplayName)
-		aadAppRegistration, err := r.CreateApp(terminator)
-		if err != nil {
-			r.Log.Error(err, "Failed to create azuread application")
-			return ctrl.Result{}, err
-		}
-
-		log.Info("Successfully created Azure AD Application registration and Service Principal", "appRegistration.ObjectID", aadAppRegistration.ObjectID)
-
-		secret := &corev1.Secret{}
-		err = r.Get(ctx, types.NamespacedName{Name: terminator.Name, Namespace: terminator.Namespace}, secret)
-		if err != nil && errors.IsNotFound(err) {
-
-			// Create Secret that will contain the ClientSecret for the AzureIdentity
-			log.Info("Creating secret for AzureIdentityBinding", "clientID", aadAppRegistration.ClientID)
-			sec := r.SecretManfiest(terminator, aadAppRegistration)
-			if err = r.Create(ctx, sec); err != nil {
-				log.Error(err, "Failed to create new Secret", "Secret.Name", sec.Name)
-				return ctrl.Result{}, err
-			}
-
-			log.Info("Successfully created Secret", "Secret.Name", sec.Name)
-
-			// Create AzureIdentity
-			log.Info("Creating AzureIdentity", "AzureIdentity.Name", terminator.Name)
-			azID := r.AzureIdentityManifest(terminator, aadAppRegistration)
-			if err = r.Create(ctx, azID); err != nil {
-				log.Error(err, "Failed to create AzureIdentity", "AzureIdentity.Name", azID.Name)
-				return ctrl.Result{}, err
-			}
-
-			log.Info("Successfully created AzureIdentity", "AzureIdentity.Name", terminator.Name)
-
-			// Create AzureIdentityBinding
-			log.Info("Creating AzureIdentityBinding", "AzureIdentityBinding.Name", terminator.Name)
-			azIDBinding := r.AzureIdentityBindingManifest(terminator, azID)
-			if err = r.Create(ctx, azIDBinding); err != nil {
-				log.Error(err, "Failed to create AzureIdentityBinding", "AzureIdentityBinding.Name", azIDBinding.Name)
-				return ctrl.Result{}, err
-			}
+	// Stop before creating anything the AzureIdentityPolicies of the namespace do not allow
+	if compliant, err := r.checkPolicies(ctx, terminator); err != nil || !compliant {
+		return ctrl.Result{}, err
+	}
 
-			log.Info("Sucessfully created AzureIdentityBinding", "AzureIdentityBinding.Name", terminator.Name)
-		}
+	// Provision whatever the status does not yet record, resuming after the last completed step
+	if err := r.Provision(ctx, terminator); err != nil {
+		return resultForError(err)
+	}
 
-		// Update status of the AzureIdentityTerminator object
-		terminator.Status.AzureIdentityBinding = terminator.Spec.AzureIdentityName
-		terminator.Status.AppRegistration.ObjectID = &aadAppRegistration.ObjectID
-		terminator.Status.RoleAssignment.Name = &aadAppRegistration.RoleAssignment.Name
-		terminator.Status.RoleAssignment.ObjectID = &aadAppRegistration.RoleAssignment.ObjectID
-		terminator.Status.ServicePrincipal.ClientSecretExpiration = (*v1.Time)(&aadAppRegistration.ServicePrincipal.ClientSecretExpiration)
-		terminator.Status.ServicePrincipal.ObjectID = &aadAppRegistration.ServicePrincipal.ObjectID
-
-		log.Info("Updating status of AzureIdentityTerminator", "AzureIdentityTerminator.Name", terminator.Name)
-		if err = r.Status().Update(ctx, terminator); err != nil {
-			log.Error(err, "Failed to update status of AzureIdentityTerminator", "AzureIdentityTerminator.Name", terminator.Name)
-			return ctrl.Result{}, err
+	// Check that nothing recorded in the status was deleted from Azure since the last audit
+	if r.auditDue(terminator) {
+		if result, err := r.AuditAzure(ctx, terminator); err != nil || !result.IsZero() {
+			return result, err
 		}
+	}
 
-		log.Info("Successfully updated status of AzureIdentityTerminator", "AzureIdentityTerminator.Name", terminator.Name)
+	// A Degraded terminator has nothing left in Azure to rotate
+	if meta.IsStatusConditionTrue(terminator.Status.Conditions, terminatorv1alpha1.ConditionDegraded) {
+		return ctrl.Result{RequeueAfter: r.untilNextAudit(terminator)}, nil
+	}
 
-		// AzureIdentity, AzureIdentityBinding, Azure AD App, Secret, and AzureIdentityTerminator created successfully - return and requeue
-		log.Info("Successfully created AzureIdentityTerminator", "AzureIdentityTerminator.Name", terminator.Name)
-		return ctrl.Result{Requeue: true}, nil
+	// Rotate the ClientSecret before it expires and requeue for the next rotation or audit, whichever comes first
+	result, err := r.RotateClientSecret(ctx, terminator)
+	if next := r.untilNextAudit(terminator); err == nil && next > 0 && (result.RequeueAfter == 0 || next < result.RequeueAfter) {
+		result.RequeueAfter = next
+	}
+	return result, err
+}
 
-	} else if err != nil {
-		log.Error(err, "Failed to get AzureIdentity")
-		return ctrl.Result{}, err
+// blockDeletion records that the deletion of the AzureIdentityTerminator waits for its deletion protection to be
+// lifted. Removing the annotation updates the object, which reconciles it again.
+func (r *AzureIdentityTerminatorReconciler) blockDeletion(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	if deleted := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionDeleted); deleted != nil && deleted.Reason == ReasonDeletionProtected {
+		return nil
 	}
 
-	return ctrl.Result{}, nil
+	r.Log.Info("Deletion is blocked by the deletion protection annotation", "AzureIdentityTerminator.Name", t.Name)
+	r.recordEvent(t, corev1.EventTypeWarning, EventDeletionProtected, "Deletion is blocked until the "+terminatorv1alpha1.DeletionProtectionAnnotation+" annotation is removed")
+	setCondition(t, terminatorv1alpha1.ConditionDeleted, v1.ConditionFalse, ReasonDeletionProtected, "Deletion is blocked until the "+terminatorv1alpha1.DeletionProtectionAnnotation+" annotation is removed")
+	return r.updateStatus(ctx, t)
 }
 
 // Helper functions to check and remove string from a slice of strings.
@@ -199,119 +202,170 @@ func removeString(slice []string, s string) (result []string) {
 	return
 }
 
-// CreateApp creates the Azure AD Application, SPN, and returns the necessary information
-func (r *AzureIdentityTerminatorReconciler) CreateApp(t *terminatorv1alpha1.AzureIdentityTerminator) (*azuread.App, error) {
-	aadApp := &azuread.App{
-		DisplayName: t.Spec.AppRegistration.DisplayName,
-		ServicePrincipal: azuread.ServicePrincipal{
-			Duration: t.Spec.ServicePrincipal.ClientSecretDuration,
-			Tags:     t.Spec.ServicePrincipal.Tags,
-		},
-		RoleAssignment: azuread.RoleAssignment{
-			NodeResourceGroup: t.Spec.NodeResourceGroup,
-		},
+// deleteStep is a single step in deleting the objects of an AzureIdentityTerminator. Each step treats an object that
+// is already gone as deleted and clears it from the status, so a deletion that fails part way resumes after the last
+// completed step.
+type deleteStep func(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error
+
+// deleteSteps returns the steps that delete the objects of the AzureIdentityTerminator in its mode. The objects in
+// the cluster are always deleted, the deletionPolicy decides what happens to the objects in Azure.
+func (r *AzureIdentityTerminatorReconciler) deleteSteps(t *terminatorv1alpha1.AzureIdentityTerminator) []deleteStep {
+	steps := []deleteStep{r.deletePodIdentityResources}
+	if isWorkloadIdentity(t) {
+		steps = []deleteStep{r.deleteServiceAccount}
 	}
 
-	_, err := aadApp.CreateAzureADApp()
-	if err != nil {
-		return nil, err
+	switch t.Spec.DeletionPolicy {
+	case terminatorv1alpha1.DeletionPolicyRetain:
+		return steps
+	case terminatorv1alpha1.DeletionPolicyDisable:
+		return append(steps, r.disableServicePrincipal)
 	}
 
-	_, err = aadApp.CreateServicePrincipal()
-	if err != nil {
-		return nil, err
+	// The role assignments outlive the principal they were assigned to, so they are deleted first
+	steps = append(steps, r.deleteRoleAssignments)
+	if isManagedIdentity(t) {
+		return append(steps, r.deleteManagedIdentity)
 	}
-
-	return aadApp, err
+	return append(steps, r.deleteApplication)
 }
 
-// DeleteResources deletes all the resources created by the AzureIdentityTerminator
-func (r *AzureIdentityTerminatorReconciler) DeleteResources(t *terminatorv1alpha1.AzureIdentityTerminator) error {
-	ctx := context.Background()
-	aadApp := &azuread.App{
-		ObjectID: *t.Status.AppRegistration.ObjectID,
-		RoleAssignment: azuread.RoleAssignment{
-			ObjectID: *t.Status.RoleAssignment.ObjectID,
-		},
+// DeleteResources deletes all the resources created by the AzureIdentityTerminator, recording the progress in its status
+func (r *AzureIdentityTerminatorReconciler) DeleteResources(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	aadApp := r.AppForTerminator(t)
+	for _, step := range r.deleteSteps(t) {
+		if err := step(ctx, t, aadApp); err != nil {
+			// A failed status update is logged by updateStatus, the step error is what gets retried
+			setConditionFailed(t, terminatorv1alpha1.ConditionDeleted, ReasonDeleteFailed, err)
+			r.updateStatus(ctx, t)
+			return err
+		}
+
+		if err := r.updateStatus(ctx, t); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Delete AzureIdentity
-	err := r.Delete(ctx, &aadpodv1.AzureIdentity{
-		TypeMeta: v1.TypeMeta{
-			Kind:       "AzureIdentity",
-			APIVersion: "aadpodidentity.k8s.io",
-		},
-		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
-			Namespace: t.Namespace,
-		},
-	})
+// deleteApplication deletes the Azure AD Application and with it the Service Principal. An Application registered
+// by a reconcile that failed to record it in the status is found by its owner tag, so it is not left behind.
+func (r *AzureIdentityTerminatorReconciler) deleteApplication(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if aadApp.ObjectID == "" {
+		found, err := r.Azure.FindApplication(ctx, aadApp)
+		if err != nil {
+			r.Log.Error(err, "Failed to look up Azure AD Application", "AzureIdentityTerminator.Name", t.Name)
+			return err
+		}
+		if !found {
+			return nil
+		}
+	}
 
-	if err != nil {
-		r.Log.Error(err, "Failed to delete AzureIdentity", "AzureIdentity.Name", t.Name)
+	err := r.Azure.DeleteApplication(ctx, aadApp)
+	if err != nil && !azuread.IsNotFound(err) {
+		r.Log.Error(err, "Failed to delete Azure AD Application", "appRegistration.ObjectID", aadApp.ObjectID)
+		r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to delete Azure AD Application "+aadApp.ClientID+": "+err.Error())
 		return err
 	}
 
-	r.Log.Info("Successfully deleted AzureIdentity", "AzureIdentity.Name", t.Name)
+	r.Log.Info("Successfully deleted Azure AD Application", "appRegistration.ObjectID", aadApp.ObjectID)
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Deleted Azure AD Application "+aadApp.ClientID+" and its Service Principal")
+	t.Status.AppRegistration.ObjectID = nil
+	t.Status.ServicePrincipal.ObjectID = nil
+	t.Status.ServicePrincipal.ClientSecretKeyID = nil
+	t.Status.ServicePrincipal.PreviousClientSecretKeyID = nil
+	return nil
+}
 
-	// Delete AzureIdentityBinding
-	err = r.Delete(ctx, &aadpodv1.AzureIdentityBinding{
-		TypeMeta: v1.TypeMeta{
-			Kind:       "AzureIdentityBinding",
-			APIVersion: "aadpodidentity.k8s.io",
-		},
-		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
-			Namespace: t.Namespace,
-		},
-	})
+// disableServicePrincipal stops the Service Principal from signing in and removes its credentials, keeping the
+// Application and its role assignments so that it can be restored. An Application that is already gone counts as disabled.
+func (r *AzureIdentityTerminatorReconciler) disableServicePrincipal(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if aadApp.ServicePrincipal.ObjectID == "" {
+		return nil
+	}
 
-	if err != nil {
-		r.Log.Error(err, "Failed to delete AzureIdentityBinding", "AzureIdentityBinding.Name", t.Name)
+	err := r.Azure.DisableServicePrincipal(ctx, aadApp)
+	if err != nil && !azuread.IsNotFound(err) {
+		r.Log.Error(err, "Failed to disable Service Principal", "servicePrincipal.ObjectID", aadApp.ServicePrincipal.ObjectID)
+		r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to disable Service Principal "+aadApp.ServicePrincipal.ObjectID+": "+err.Error())
 		return err
 	}
 
-	r.Log.Info("Successfully deleted AzureIdentityBinding", "AzureIdentityBinding.Name", t.Name)
+	r.Log.Info("Successfully disabled Service Principal", "servicePrincipal.ObjectID", aadApp.ServicePrincipal.ObjectID)
+	r.recordEvent(t, corev1.EventTypeNormal, EventServicePrincipalDisabled, "Disabled Service Principal "+aadApp.ServicePrincipal.ObjectID+" and removed its credentials, Azure AD Application "+aadApp.ClientID+" is kept")
+	t.Status.ServicePrincipal.ClientSecretKeyID = nil
+	t.Status.ServicePrincipal.PreviousClientSecretKeyID = nil
+	t.Status.FederatedIdentityCredential = terminatorv1alpha1.FederatedIdentityCredential{}
+	return nil
+}
 
-	// Delete Secret created by AzureIdentityTerminator
-	err = r.Delete(ctx, &corev1.Secret{
-		TypeMeta: v1.TypeMeta{
-			Kind:       "Secret",
-			APIVersion: "v1",
-		},
-		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
-			Namespace: t.Namespace,
-		},
-	})
+// deletePodIdentityResources deletes the AzureIdentity, AzureIdentityBinding and Secret used in podIdentity mode,
+// under the names recorded in the status as well as the names the spec asks for. A user-assigned managed identity
+// needs no Secret. Objects that are already gone count as deleted.
+func (r *AzureIdentityTerminatorReconciler) deletePodIdentityResources(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	var objects []client.Object
+	for _, name := range podIdentityObjectNames(t.Status.AzureIdentityBinding, azureIdentityName(t)) {
+		objects = append(objects, &aadpodv1.AzureIdentityBinding{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: t.Namespace}})
+	}
+	for _, name := range podIdentityObjectNames(t.Status.AzureIdentity, azureIdentityName(t)) {
+		objects = append(objects, &aadpodv1.AzureIdentity{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: t.Namespace}})
+	}
+	if !isManagedIdentity(t) {
+		for _, name := range podIdentityObjectNames(t.Status.Secret, secretName(t)) {
+			objects = append(objects, &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: t.Namespace}})
+		}
+	}
 
-	if err != nil {
-		r.Log.Error(err, "Failed to delete Secret", "Secret.Name", t.Name)
-		return err
+	for _, obj := range objects {
+		if err := r.deleteObject(ctx, t, obj); err != nil {
+			return err
+		}
 	}
 
-	r.Log.Info("Successfully deleted Secret", "Secret.Name", t.Name)
+	t.Status.AzureIdentity = ""
+	t.Status.AzureIdentityBinding = ""
+	t.Status.Secret = ""
+	return nil
+}
 
-	// Delete Azure AD App
-	_, err = aadApp.DeleteAzureApp()
+// deleteObject deletes an object created for the AzureIdentityTerminator. An object that is already gone counts as
+// deleted, and one controlled by another owner, such as another AzureIdentityTerminator given the same name, is kept.
+func (r *AzureIdentityTerminatorReconciler) deleteObject(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, obj client.Object) error {
+	kind := reflect.TypeOf(obj).Elem().Name()
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if err == nil && controlledByOther(t, obj) {
+		r.Log.Info("Keeping "+kind+" controlled by another owner", kind+".Name", obj.GetName())
+		return nil
+	}
+	if err == nil {
+		// The precondition stops the deletion should the object be replaced after it was read
+		uid := obj.GetUID()
+		err = r.Delete(ctx, obj, client.Preconditions{UID: &uid})
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
-		r.Log.Error(err, "Failed to delete RoleAssignment", t.Status.RoleAssignment.ObjectID)
+		r.Log.Error(err, "Failed to delete "+kind, kind+".Name", obj.GetName())
+		r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to delete "+kind+" "+obj.GetName()+": "+err.Error())
 		return err
 	}
 
-	r.Log.Info("Successfully deleted RoleAssignment", "AzureIdentityTerminator.RoleAssignment.ObjectID", t.Status.RoleAssignment.ObjectID)
-	return err
+	r.Log.Info("Successfully deleted "+kind, kind+".Name", obj.GetName())
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Deleted "+kind+" "+obj.GetName())
+	return nil
 }
 
 // AzureIdentityManifest creates the AzureIdentity manifest
-func (r *AzureIdentityTerminatorReconciler) AzureIdentityManifest(t *terminatorv1alpha1.AzureIdentityTerminator, app *azuread.App) *aadpodv1.AzureIdentity {
+func (r *AzureIdentityTerminatorReconciler) AzureIdentityManifest(t *terminatorv1alpha1.AzureIdentityTerminator, app *azuread.App) (*aadpodv1.AzureIdentity, error) {
 	azID := &aadpodv1.AzureIdentity{
 		TypeMeta: v1.TypeMeta{
 			Kind:       "AzureIdentity",
 			APIVersion: "aadpodidentity.k8s.io",
 		},
 		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
+			Name:      azureIdentityName(t),
 			Namespace: t.Namespace,
 		},
 		Spec: aadpodv1.AzureIdentitySpec{
@@ -319,24 +373,37 @@ func (r *AzureIdentityTerminatorReconciler) AzureIdentityManifest(t *terminatorv
 			TenantID: app.TenantID,
 			ClientID: app.ClientID,
 			ClientPassword: corev1.SecretReference{
-				Name:      t.Name,
+				Name:      secretName(t),
 				Namespace: t.Namespace,
 			},
 		},
 	}
 
-	return azID
+	// A user-assigned managed identity is referenced by its resource ID and needs no ClientSecret
+	if isManagedIdentity(t) {
+		azID.Spec = aadpodv1.AzureIdentitySpec{
+			Type:       aadpodv1.UserAssignedMSI,
+			ResourceID: app.ManagedIdentity.ResourceID,
+			ClientID:   app.ManagedIdentity.ClientID,
+		}
+	}
+
+	// Set AzureIdentityTerminator instance as the owner and controller
+	if err := ctrl.SetControllerReference(t, azID, r.Scheme); err != nil {
+		return nil, err
+	}
+	return azID, nil
 }
 
 // AzureIdentityBindingManifest creates teh AzureIdentityBinding manifest
-func (r *AzureIdentityTerminatorReconciler) AzureIdentityBindingManifest(t *terminatorv1alpha1.AzureIdentityTerminator, azID *aadpodv1.AzureIdentity) *aadpodv1.AzureIdentityBinding {
+func (r *AzureIdentityTerminatorReconciler) AzureIdentityBindingManifest(t *terminatorv1alpha1.AzureIdentityTerminator, azID *aadpodv1.AzureIdentity) (*aadpodv1.AzureIdentityBinding, error) {
 	azIDBinding := &aadpodv1.AzureIdentityBinding{
 		TypeMeta: v1.TypeMeta{
 			Kind:       "AzureIdentityBinding",
 			APIVersion: "aadpodidentity.k8s.io",
 		},
 		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
+			Name:      azureIdentityName(t),
 			Namespace: t.Namespace,
 		},
 		Spec: aadpodv1.AzureIdentityBindingSpec{
@@ -345,27 +412,36 @@ func (r *AzureIdentityTerminatorReconciler) AzureIdentityBindingManifest(t *term
 		},
 	}
 
-	return azIDBinding
+	// Set AzureIdentityTerminator instance as the owner and controller
+	if err := ctrl.SetControllerReference(t, azIDBinding, r.Scheme); err != nil {
+		return nil, err
+	}
+	return azIDBinding, nil
 }
 
 // SecretManfiest creates the Secret needed for the AzureIdentity
-func (r *AzureIdentityTerminatorReconciler) SecretManfiest(t *terminatorv1alpha1.AzureIdentityTerminator, app *azuread.App) *corev1.Secret {
+func (r *AzureIdentityTerminatorReconciler) SecretManfiest(t *terminatorv1alpha1.AzureIdentityTerminator, app *azuread.App) (*corev1.Secret, error) {
 	secret := &corev1.Secret{
 		TypeMeta: v1.TypeMeta{
 			Kind:       "Secret",
 			APIVersion: "v1",
 		},
 		ObjectMeta: v1.ObjectMeta{
-			Name:      t.Name,
-			Namespace: t.Namespace,
+			Name:        secretName(t),
+			Namespace:   t.Namespace,
+			Annotations: clientSecretAnnotations(app.ServicePrincipal),
 		},
 		Immutable: to.BoolPtr(false),
 		StringData: map[string]string{
-			"clientSecret": app.ServicePrincipal.ClientSecret,
+			clientSecretKey: app.ServicePrincipal.ClientSecret,
 		},
 	}
 
-	return secret
+	// Set AzureIdentityTerminator instance as the owner and controller
+	if err := ctrl.SetControllerReference(t, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	return secret, nil
 }
 
 // SetupWithManager sets up the reconciler management
@@ -375,5 +451,7 @@ func (r *AzureIdentityTerminatorReconciler) SetupWithManager(mgr ctrl.Manager) e
 		Owns(&aadpodv1.AzureIdentity{}).
 		Owns(&aadpodv1.AzureIdentityBinding{}).
 		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &terminatorv1alpha1.AzureIdentityPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.terminatorsForPolicy)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.terminatorsForNamespace)).
 		Complete(r)
 }
@@ -0,0 +1,949 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/azure/fake"
+)
+
+var _ = Describe("AzureIdentityTerminator controller", func() {
+	const (
+		namespace = "default"
+		timeout   = time.Second * 10
+		interval  = time.Millisecond * 250
+	)
+
+	ctx := context.Background()
+
+	newTerminator := func(name string) *terminatorv1alpha1.AzureIdentityTerminator {
+		return &terminatorv1alpha1.AzureIdentityTerminator{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: terminatorv1alpha1.AzureIdentityTerminatorSpec{
+				AppRegistration: terminatorv1alpha1.AppRegistration{
+					DisplayName: name,
+				},
+				AzureIdentityName: name,
+				NodeResourceGroup: "node-resource-group",
+				PodSelector:       name,
+				ServicePrincipal: terminatorv1alpha1.ServicePrincipal{
+					ClientSecretDuration: "720h",
+					Tags:                 []string{"test"},
+				},
+			},
+		}
+	}
+
+	getTerminator := func(name string) func() (*terminatorv1alpha1.AzureIdentityTerminator, error) {
+		return func() (*terminatorv1alpha1.AzureIdentityTerminator, error) {
+			t := &terminatorv1alpha1.AzureIdentityTerminator{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, t)
+			return t, err
+		}
+	}
+
+	Context("When creating an AzureIdentityTerminator", func() {
+		It("Should provision the Azure objects and the pod identity resources", func() {
+			terminator := newTerminator("create-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{})
+			}, timeout, interval).Should(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() *string {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.ServicePrincipal.ObjectID
+			}, timeout, interval).ShouldNot(BeNil())
+
+			app, ok := fakeAzure.Application(*created.Status.AppRegistration.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(app.DisplayName).To(Equal(terminator.Spec.AppRegistration.DisplayName))
+
+			sp, ok := fakeAzure.ServicePrincipal(*created.Status.ServicePrincipal.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(sp.Passwords).To(HaveKey(*created.Status.ServicePrincipal.ClientSecretKeyID))
+
+			Expect(created.Status.RoleAssignments).To(HaveLen(1))
+			ra, ok := fakeAzure.RoleAssignment(*created.Status.RoleAssignments[0].ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(ra.Role).To(Equal("Reader"))
+			Expect(ra.Scope).To(HaveSuffix("/resourceGroups/" + terminator.Spec.NodeResourceGroup))
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			ready, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(meta.IsStatusConditionTrue(ready.Status.Conditions, terminatorv1alpha1.ConditionReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(ready.Status.Conditions, terminatorv1alpha1.ConditionSecretExpiringSoon)).To(BeTrue())
+			Expect(ready.Status.ObservedGeneration).To(Equal(ready.Generation))
+
+			azID := &aadpodv1.AzureIdentity{}
+			Expect(k8sClient.Get(ctx, key, azID)).To(Succeed())
+			Expect(azID.Spec.ClientID).To(Equal(app.ClientID))
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, key, secret)).To(Succeed())
+			Expect(string(secret.Data[clientSecretKey])).To(Equal(sp.Passwords[*created.Status.ServicePrincipal.ClientSecretKeyID]))
+
+			Expect(testutil.CollectAndCount(terminatorMetrics, "azidterminator_client_secret_expiry_seconds")).To(BeNumerically(">=", 1))
+
+			Eventually(func() []string {
+				events := &corev1.EventList{}
+				Expect(k8sClient.List(ctx, events, client.InNamespace(namespace))).To(Succeed())
+
+				var reasons []string
+				for _, event := range events.Items {
+					if event.InvolvedObject.Name == terminator.Name {
+						reasons = append(reasons, event.Reason)
+					}
+				}
+				return reasons
+			}, timeout, interval).Should(ContainElements(EventApplicationRegistered, EventServicePrincipalCreated, EventRoleAssigned, EventSecretCreated, EventAzureIdentityBindingCreated))
+			Expect(testutil.CollectAndCount(terminatorMetrics, "azidterminator_terminators")).To(BeNumerically(">=", 1))
+		})
+	})
+
+	Context("When the ClientSecret is about to expire", func() {
+		It("Should rotate the ClientSecret and update the Secret in place", func() {
+			terminator := newTerminator("rotate-test")
+			terminator.Spec.ServicePrincipal.ClientSecretDuration = "1h"
+			terminator.Spec.ServicePrincipal.RotateBefore = "2m"
+			terminator.Spec.ServicePrincipal.RotationGracePeriod = "0s"
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() *string {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.ServicePrincipal.ClientSecretKeyID
+			}, timeout, interval).ShouldNot(BeNil())
+			firstKeyID := *created.Status.ServicePrincipal.ClientSecretKeyID
+
+			By("moving the recorded expiration into the rotation window")
+			Eventually(func() error {
+				t, err := getTerminator(terminator.Name)()
+				if err != nil {
+					return err
+				}
+				t.Status.ServicePrincipal.ClientSecretExpiration = &metav1.Time{Time: time.Now().Add(time.Minute)}
+				return k8sClient.Status().Update(ctx, t)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() string {
+				t, _ := getTerminator(terminator.Name)()
+				if t.Status.ServicePrincipal.ClientSecretKeyID == nil {
+					return ""
+				}
+				return *t.Status.ServicePrincipal.ClientSecretKeyID
+			}, timeout, interval).ShouldNot(Equal(firstKeyID))
+
+			rotated, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			newKeyID := *rotated.Status.ServicePrincipal.ClientSecretKeyID
+
+			Eventually(func() map[string]string {
+				sp, _ := fakeAzure.ServicePrincipal(*rotated.Status.ServicePrincipal.ObjectID)
+				return sp.Passwords
+			}, timeout, interval).ShouldNot(HaveKey(firstKeyID))
+
+			sp, _ := fakeAzure.ServicePrincipal(*rotated.Status.ServicePrincipal.ObjectID)
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: terminator.Name, Namespace: namespace}, secret)).To(Succeed())
+			Expect(string(secret.Data[clientSecretKey])).To(Equal(sp.Passwords[newKeyID]))
+		})
+	})
+
+	Context("When the spec is updated", func() {
+		It("Should apply the changes to the existing objects", func() {
+			terminator := newTerminator("update-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			Expect(created.Status.AppRegistration.DisplayName).To(Equal(terminator.Spec.AppRegistration.DisplayName))
+			oldRoleAssignmentID := *created.Status.RoleAssignments[0].ObjectID
+
+			created.Spec.AppRegistration.DisplayName = "update-test-renamed"
+			created.Spec.ServicePrincipal.Tags = []string{"updated"}
+			created.Spec.PodSelector = "update-test-pods"
+			created.Spec.NodeResourceGroup = "other-node-resource-group"
+			Expect(k8sClient.Update(ctx, created)).To(Succeed())
+
+			var updated *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() bool {
+				updated, _ = getTerminator(terminator.Name)()
+				return updated.Generation > created.Generation && updated.Status.ObservedGeneration == updated.Generation &&
+					updated.Status.Phase == terminatorv1alpha1.PhaseReady
+			}, timeout, interval).Should(BeTrue())
+
+			app, ok := fakeAzure.Application(*updated.Status.AppRegistration.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(app.DisplayName).To(Equal("update-test-renamed"))
+			Expect(updated.Status.AppRegistration.ClientID).To(Equal(created.Status.AppRegistration.ClientID))
+
+			sp, ok := fakeAzure.ServicePrincipal(*updated.Status.ServicePrincipal.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(sp.Tags).To(Equal([]string{"updated"}))
+
+			binding := &aadpodv1.AzureIdentityBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: terminator.Name, Namespace: namespace}, binding)).To(Succeed())
+			Expect(binding.Spec.Selector).To(Equal("update-test-pods"))
+
+			Expect(updated.Status.RoleAssignments).To(HaveLen(1))
+			ra, ok := fakeAzure.RoleAssignment(*updated.Status.RoleAssignments[0].ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(ra.Scope).To(HaveSuffix("/resourceGroups/other-node-resource-group"))
+			_, ok = fakeAzure.RoleAssignment(oldRoleAssignmentID)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("When naming the pod identity resources", func() {
+		It("Should name them after azureIdentityName and secretName and move them when renamed", func() {
+			terminator := newTerminator("naming-test")
+			terminator.Spec.AzureIdentityName = "naming-test-identity"
+			terminator.Spec.SecretName = "naming-test-secret"
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			Expect(created.Status.AzureIdentity).To(Equal("naming-test-identity"))
+			Expect(created.Status.AzureIdentityBinding).To(Equal("naming-test-identity"))
+			Expect(created.Status.Secret).To(Equal("naming-test-secret"))
+
+			key := types.NamespacedName{Name: "naming-test-identity", Namespace: namespace}
+			azID := &aadpodv1.AzureIdentity{}
+			Expect(k8sClient.Get(ctx, key, azID)).To(Succeed())
+			Expect(azID.Spec.ClientPassword.Name).To(Equal("naming-test-secret"))
+			Expect(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{})).To(Succeed())
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "naming-test-secret", Namespace: namespace}, secret)).To(Succeed())
+			clientSecret := string(secret.Data[clientSecretKey])
+
+			By("renaming the objects in the spec")
+			passwords := fakeAzure.CallCount(fake.AddPassword)
+			created.Spec.AzureIdentityName = "naming-test-renamed"
+			created.Spec.SecretName = "naming-test-renamed-secret"
+			Expect(k8sClient.Update(ctx, created)).To(Succeed())
+
+			Eventually(func() string {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Secret
+			}, timeout, interval).Should(Equal("naming-test-renamed-secret"))
+
+			renamed := types.NamespacedName{Name: "naming-test-renamed", Namespace: namespace}
+			Expect(k8sClient.Get(ctx, renamed, azID)).To(Succeed())
+			Expect(azID.Spec.ClientPassword.Name).To(Equal("naming-test-renamed-secret"))
+			Expect(k8sClient.Get(ctx, renamed, &aadpodv1.AzureIdentityBinding{})).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "naming-test-renamed-secret", Namespace: namespace}, secret)).To(Succeed())
+			Expect(string(secret.Data[clientSecretKey])).To(Equal(clientSecret))
+			Expect(fakeAzure.CallCount(fake.AddPassword)).To(Equal(passwords))
+
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentity{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "naming-test-secret", Namespace: namespace}, &corev1.Secret{}))).To(BeTrue())
+		})
+	})
+
+	Context("When a provisioning step fails", func() {
+		It("Should report the failed step in the conditions", func() {
+			fakeAzure.SetError(fake.CreateApplication, fmt.Errorf("insufficient privileges"))
+			defer fakeAzure.SetError(fake.CreateApplication, nil)
+
+			terminator := newTerminator("failure-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseFailed))
+
+			failed, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			condition := meta.FindStatusCondition(failed.Status.Conditions, terminatorv1alpha1.ConditionAppRegistered)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring("insufficient privileges"))
+			Expect(meta.IsStatusConditionFalse(failed.Status.Conditions, terminatorv1alpha1.ConditionReady)).To(BeTrue())
+		})
+	})
+
+	Context("When a provisioning step fails after earlier steps succeeded", func() {
+		It("Should resume from the failed step without duplicating Azure objects", func() {
+			fakeAzure.SetError(fake.CreateRoleAssignment, fmt.Errorf("principal not found"))
+			applications := fakeAzure.CallCount(fake.CreateApplication)
+			servicePrincipals := fakeAzure.CallCount(fake.CreateServicePrincipal)
+
+			terminator := newTerminator("resume-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() bool {
+				t, _ := getTerminator(terminator.Name)()
+				return meta.IsStatusConditionFalse(t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned)
+			}, timeout, interval).Should(BeTrue())
+
+			failed, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed.Status.AppRegistration.ObjectID).NotTo(BeNil())
+			Expect(failed.Status.ServicePrincipal.ObjectID).NotTo(BeNil())
+			appObjectID := *failed.Status.AppRegistration.ObjectID
+
+			fakeAzure.SetError(fake.CreateRoleAssignment, nil)
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			ready, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*ready.Status.AppRegistration.ObjectID).To(Equal(appObjectID))
+			Expect(fakeAzure.CallCount(fake.CreateApplication) - applications).To(Equal(1))
+			Expect(fakeAzure.CallCount(fake.CreateServicePrincipal) - servicePrincipals).To(Equal(1))
+		})
+	})
+
+	Context("When the principal has not replicated to Azure Resource Manager yet", func() {
+		It("Should wait for it before assigning its roles", func() {
+			fakeAzure.SetError(fake.CreateRoleAssignment, &azure.RequestError{ServiceError: &azure.ServiceError{Code: "PrincipalNotFound"}})
+			defer fakeAzure.SetError(fake.CreateRoleAssignment, nil)
+
+			terminator := newTerminator("principal-not-found-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() string {
+				t, _ := getTerminator(terminator.Name)()
+				if condition := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned); condition != nil {
+					return condition.Reason
+				}
+				return ""
+			}, timeout, interval).Should(Equal(ReasonPrincipalNotFound))
+
+			waiting, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(waiting.Status.Phase).To(Equal(terminatorv1alpha1.PhaseProvisioning))
+			Expect(meta.FindStatusCondition(waiting.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned).Status).To(Equal(metav1.ConditionUnknown))
+
+			fakeAzure.SetError(fake.CreateRoleAssignment, nil)
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, 2*timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+		})
+	})
+
+	Context("When the controller is not authorized to assign a role", func() {
+		It("Should report the failure without retrying it", func() {
+			fakeAzure.SetError(fake.CreateRoleAssignment, &azure.RequestError{
+				DetailedError: autorest.DetailedError{StatusCode: http.StatusForbidden},
+				ServiceError:  &azure.ServiceError{Code: "AuthorizationFailed", Message: "does not have authorization to perform action"},
+			})
+			defer fakeAzure.SetError(fake.CreateRoleAssignment, nil)
+
+			terminator := newTerminator("authorization-failed-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() string {
+				t, _ := getTerminator(terminator.Name)()
+				if condition := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned); condition != nil {
+					return condition.Reason
+				}
+				return ""
+			}, timeout, interval).Should(Equal(ReasonAuthorizationFailed))
+
+			failed, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failed.Status.Phase).To(Equal(terminatorv1alpha1.PhaseFailed))
+
+			// The status update recording the failure may trigger one more attempt, but no more than that
+			calls := fakeAzure.CallCount(fake.CreateRoleAssignment)
+			Consistently(func() int {
+				return fakeAzure.CallCount(fake.CreateRoleAssignment)
+			}, 2*time.Second, interval).Should(BeNumerically("<=", calls+1))
+		})
+	})
+
+	Context("When creating an AzureIdentityTerminator in workloadIdentity mode", func() {
+		It("Should federate the ServiceAccount instead of issuing a ClientSecret", func() {
+			terminator := newTerminator("workload-identity-test")
+			terminator.Spec.Mode = terminatorv1alpha1.ModeWorkloadIdentity
+			terminator.Spec.WorkloadIdentity = &terminatorv1alpha1.WorkloadIdentity{
+				ServiceAccountName: "workload-identity-sa",
+			}
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			ready, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ready.Status.ServicePrincipal.ClientSecretKeyID).To(BeNil())
+
+			app, ok := fakeAzure.Application(*ready.Status.AppRegistration.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(app.FederatedIdentityCredentials).To(HaveLen(1))
+			for _, fic := range app.FederatedIdentityCredentials {
+				Expect(fic.Issuer).To(Equal(oidcIssuerURL))
+				Expect(fic.Subject).To(Equal("system:serviceaccount:" + namespace + ":workload-identity-sa"))
+			}
+
+			sa := &corev1.ServiceAccount{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "workload-identity-sa", Namespace: namespace}, sa)).To(Succeed())
+			Expect(sa.Annotations).To(HaveKeyWithValue(workloadIdentityClientIDAnnotation, app.ClientID))
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &corev1.Secret{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentity{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{}))).To(BeTrue())
+
+			Expect(ready.Status.FederatedIdentityCredential.Issuer).To(Equal(oidcIssuerURL))
+
+			By("restoring the issuer of a credential changed in Azure")
+			appObjectID := *ready.Status.AppRegistration.ObjectID
+			fakeAzure.Modify(func(f *fake.IdentityProvider) {
+				for name, fic := range f.Applications[appObjectID].FederatedIdentityCredentials {
+					fic.Issuer = "https://old-issuer.example.com"
+					f.Applications[appObjectID].FederatedIdentityCredentials[name] = fic
+				}
+			})
+			Eventually(func() string {
+				app, _ := fakeAzure.Application(appObjectID)
+				for _, fic := range app.FederatedIdentityCredentials {
+					return fic.Issuer
+				}
+				return ""
+			}, timeout, interval).Should(Equal(oidcIssuerURL))
+			Expect(fakeAzure.CallCount(fake.UpdateFederatedIdentityCredential)).To(BeNumerically(">=", 1))
+
+			By("deleting the ServiceAccount it created")
+			ready, err = getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Delete(ctx, ready)).To(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: "workload-identity-sa", Namespace: namespace}, &corev1.ServiceAccount{})
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("When creating an AzureIdentityTerminator with identityType UserAssignedMSI", func() {
+		It("Should bind pods to a user-assigned managed identity instead of a Service Principal", func() {
+			terminator := newTerminator("managed-identity-test")
+			terminator.Spec.IdentityType = terminatorv1alpha1.IdentityTypeUserAssignedMSI
+			terminator.Spec.ServicePrincipal = terminatorv1alpha1.ServicePrincipal{}
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			ready, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ready.Status.AppRegistration.ObjectID).To(BeNil())
+			Expect(ready.Status.ServicePrincipal.ObjectID).To(BeNil())
+			Expect(ready.Status.ManagedIdentity.ResourceID).NotTo(BeNil())
+
+			mi, ok := fakeAzure.ManagedIdentity(*ready.Status.ManagedIdentity.ResourceID)
+			Expect(ok).To(BeTrue())
+			Expect(mi.ResourceGroup).To(Equal(managedIdentityResourceGroup))
+			Expect(mi.Location).To(Equal(managedIdentityLocation))
+			Expect(*ready.Status.ManagedIdentity.ClientID).To(Equal(mi.ClientID))
+
+			ra, ok := fakeAzure.RoleAssignment(*ready.Status.RoleAssignments[0].ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(ra.PrincipalID).To(Equal(mi.PrincipalID))
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			azID := &aadpodv1.AzureIdentity{}
+			Expect(k8sClient.Get(ctx, key, azID)).To(Succeed())
+			Expect(azID.Spec.Type).To(Equal(aadpodv1.UserAssignedMSI))
+			Expect(azID.Spec.ResourceID).To(Equal(mi.ResourceID))
+			Expect(azID.Spec.ClientID).To(Equal(mi.ClientID))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &corev1.Secret{}))).To(BeTrue())
+
+			By("deleting the managed identity with the AzureIdentityTerminator")
+			Expect(k8sClient.Delete(ctx, ready)).To(Succeed())
+			Eventually(func() bool {
+				_, ok := fakeAzure.ManagedIdentity(mi.ResourceID)
+				return ok
+			}, timeout, interval).Should(BeFalse())
+		})
+	})
+
+	Context("When a child object is deleted", func() {
+		It("Should own its children and restore the deleted one", func() {
+			terminator := newTerminator("owner-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			created, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			for _, obj := range []client.Object{&aadpodv1.AzureIdentity{}, &aadpodv1.AzureIdentityBinding{}, &corev1.Secret{}} {
+				Expect(k8sClient.Get(ctx, key, obj)).To(Succeed())
+				owner := metav1.GetControllerOf(obj)
+				Expect(owner).NotTo(BeNil())
+				Expect(owner.UID).To(Equal(created.UID))
+			}
+
+			binding := &aadpodv1.AzureIdentityBinding{}
+			Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, binding)).To(Succeed())
+
+			Eventually(func() bool {
+				restored := &aadpodv1.AzureIdentityBinding{}
+				if err := k8sClient.Get(ctx, key, restored); err != nil {
+					return false
+				}
+				return restored.UID != binding.UID
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	Context("When a child object drifts from the desired state", func() {
+		It("Should replace a deleted Secret and restore an edited AzureIdentityBinding", func() {
+			terminator := newTerminator("drift-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			created, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			appObjectID := *created.Status.AppRegistration.ObjectID
+			spObjectID := *created.Status.ServicePrincipal.ObjectID
+			lostKeyID := *created.Status.ServicePrincipal.ClientSecretKeyID
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, key, secret)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+
+			var repaired *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() string {
+				repaired, _ = getTerminator(terminator.Name)()
+				return *repaired.Status.ServicePrincipal.ClientSecretKeyID
+			}, timeout, interval).ShouldNot(Equal(lostKeyID))
+			Expect(*repaired.Status.AppRegistration.ObjectID).To(Equal(appObjectID))
+
+			Eventually(func() map[string]string {
+				sp, _ := fakeAzure.ServicePrincipal(spObjectID)
+				return sp.Passwords
+			}, timeout, interval).ShouldNot(HaveKey(lostKeyID))
+
+			sp, _ := fakeAzure.ServicePrincipal(spObjectID)
+			Eventually(func() string {
+				restored := &corev1.Secret{}
+				k8sClient.Get(ctx, key, restored)
+				return string(restored.Data[clientSecretKey])
+			}, timeout, interval).Should(Equal(sp.Passwords[*repaired.Status.ServicePrincipal.ClientSecretKeyID]))
+
+			binding := &aadpodv1.AzureIdentityBinding{}
+			Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+			binding.Spec.Selector = "edited"
+			Expect(k8sClient.Update(ctx, binding)).To(Succeed())
+
+			Eventually(func() string {
+				restored := &aadpodv1.AzureIdentityBinding{}
+				k8sClient.Get(ctx, key, restored)
+				return restored.Spec.Selector
+			}, timeout, interval).Should(Equal(terminator.Spec.PodSelector))
+		})
+	})
+
+	Context("When an Azure object is deleted outside the cluster", func() {
+		It("Should recreate the role assignment and report or recreate the Application", func() {
+			terminator := newTerminator("audit-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				t, _ := getTerminator(terminator.Name)()
+				return t.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+
+			created, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			appObjectID := *created.Status.AppRegistration.ObjectID
+			roleAssignmentID := *created.Status.RoleAssignments[0].ObjectID
+
+			fakeAzure.Modify(func(f *fake.IdentityProvider) {
+				delete(f.RoleAssignments, roleAssignmentID)
+			})
+			Eventually(func() bool {
+				_, ok := fakeAzure.RoleAssignment(roleAssignmentID)
+				return ok
+			}, timeout, interval).Should(BeTrue())
+
+			fakeAzure.Modify(func(f *fake.IdentityProvider) {
+				delete(f.Applications, appObjectID)
+				for id, sp := range f.ServicePrincipals {
+					if sp.ApplicationObjectID == appObjectID {
+						delete(f.ServicePrincipals, id)
+					}
+				}
+			})
+
+			var degraded *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				degraded, _ = getTerminator(terminator.Name)()
+				return degraded.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseDegraded))
+			Expect(meta.IsStatusConditionTrue(degraded.Status.Conditions, terminatorv1alpha1.ConditionDegraded)).To(BeTrue())
+			Expect(*degraded.Status.AppRegistration.ObjectID).To(Equal(appObjectID))
+
+			degraded.Spec.DriftPolicy = terminatorv1alpha1.DriftPolicyRecreate
+			Expect(k8sClient.Update(ctx, degraded)).To(Succeed())
+
+			var recreated *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() bool {
+				recreated, _ = getTerminator(terminator.Name)()
+				return recreated.Status.Phase == terminatorv1alpha1.PhaseReady && recreated.Status.AppRegistration.ObjectID != nil &&
+					*recreated.Status.AppRegistration.ObjectID != appObjectID
+			}, timeout, interval).Should(BeTrue())
+
+			_, ok := fakeAzure.Application(*recreated.Status.AppRegistration.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(meta.FindStatusCondition(recreated.Status.Conditions, terminatorv1alpha1.ConditionDegraded)).To(BeNil())
+
+			Eventually(func() string {
+				azID := &aadpodv1.AzureIdentity{}
+				k8sClient.Get(ctx, types.NamespacedName{Name: terminator.Name, Namespace: namespace}, azID)
+				return azID.Spec.ClientID
+			}, timeout, interval).Should(Equal(*recreated.Status.AppRegistration.ClientID))
+		})
+	})
+
+	Context("When setting role assignments", func() {
+		It("Should assign each role over its scope and remove the ones taken out of the spec", func() {
+			const vaultID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/vaults/providers/Microsoft.KeyVault/vaults/my-vault"
+			terminator := newTerminator("role-assignments-test")
+			terminator.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{
+				{Role: "Reader"},
+				{Role: "Key Vault Secrets User", Scope: vaultID},
+			}
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() int {
+				created, _ = getTerminator(terminator.Name)()
+				return len(created.Status.RoleAssignments)
+			}, timeout, interval).Should(Equal(2))
+
+			vault := created.Status.RoleAssignments[1]
+			Expect(vault.Role).To(Equal("Key Vault Secrets User"))
+			Expect(vault.Scope).To(Equal(vaultID))
+			ra, ok := fakeAzure.RoleAssignment(*vault.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(ra.Scope).To(Equal(vaultID))
+			Expect(ra.PrincipalID).To(Equal(*created.Status.ServicePrincipal.ObjectID))
+			Expect(created.Status.RoleAssignments[0].Scope).To(Equal(terminator.Spec.NodeResourceGroup))
+
+			Eventually(func() error {
+				t, err := getTerminator(terminator.Name)()
+				if err != nil {
+					return err
+				}
+				t.Spec.RoleAssignments = t.Spec.RoleAssignments[:1]
+				return k8sClient.Update(ctx, t)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() bool {
+				_, ok := fakeAzure.RoleAssignment(*vault.ObjectID)
+				return ok
+			}, timeout, interval).Should(BeFalse())
+
+			var updated *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() int {
+				updated, _ = getTerminator(terminator.Name)()
+				return len(updated.Status.RoleAssignments)
+			}, timeout, interval).Should(Equal(1))
+			Expect(updated.Status.RoleAssignments[0].Role).To(Equal("Reader"))
+		})
+	})
+
+	Context("When an AzureIdentityPolicy selects the namespace", func() {
+		It("Should report the violations and provision once the policy allows the spec", func() {
+			policyNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "policy-test",
+				Labels: map[string]string{"azure-identity-policy": "readers"},
+			}}
+			Expect(k8sClient.Create(ctx, policyNamespace)).To(Succeed())
+
+			policy := &terminatorv1alpha1.AzureIdentityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "readers"},
+				Spec: terminatorv1alpha1.AzureIdentityPolicySpec{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: policyNamespace.Labels},
+					AllowedRoles:      []string{"Reader"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+			defer k8sClient.Delete(ctx, policy)
+
+			terminator := newTerminator("policy-test")
+			terminator.Namespace = policyNamespace.Name
+			terminator.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{{Role: "Owner"}}
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: policyNamespace.Name}
+			created := &terminatorv1alpha1.AzureIdentityTerminator{}
+			var condition *metav1.Condition
+			Eventually(func() bool {
+				k8sClient.Get(ctx, key, created)
+				condition = meta.FindStatusCondition(created.Status.Conditions, terminatorv1alpha1.ConditionPolicyCompliant)
+				return condition != nil && condition.Status == metav1.ConditionFalse
+			}, timeout, interval).Should(BeTrue())
+
+			Expect(condition.Reason).To(Equal(ReasonPolicyViolation))
+			Expect(condition.Message).To(ContainSubstring(`role "Owner" is not allowed by AzureIdentityPolicy readers`))
+			Expect(created.Status.Phase).To(Equal(terminatorv1alpha1.PhaseFailed))
+			Expect(created.Status.AppRegistration.ObjectID).To(BeNil())
+
+			Eventually(func() error {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: policy.Name}, policy); err != nil {
+					return err
+				}
+				policy.Spec.AllowedRoles = append(policy.Spec.AllowedRoles, "Owner")
+				return k8sClient.Update(ctx, policy)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() terminatorv1alpha1.Phase {
+				k8sClient.Get(ctx, key, created)
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			Expect(meta.IsStatusConditionTrue(created.Status.Conditions, terminatorv1alpha1.ConditionPolicyCompliant)).To(BeTrue())
+		})
+	})
+
+	Context("When deleting an AzureIdentityTerminator", func() {
+		It("Should delete the Azure objects and the pod identity resources", func() {
+			terminator := newTerminator("delete-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() *string {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.AppRegistration.ObjectID
+			}, timeout, interval).ShouldNot(BeNil())
+			appObjectID := *created.Status.AppRegistration.ObjectID
+
+			Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+			Eventually(func() bool {
+				_, err := getTerminator(terminator.Name)()
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			_, ok := fakeAzure.Application(appObjectID)
+			Expect(ok).To(BeFalse())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentity{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{}))).To(BeTrue())
+		})
+
+		It("Should resume a failed deletion and treat objects that are already gone as deleted", func() {
+			terminator := newTerminator("resume-delete-test")
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			appObjectID := *created.Status.AppRegistration.ObjectID
+			roleAssignmentID := *created.Status.RoleAssignments[0].ObjectID
+
+			fakeAzure.SetError(fake.DeleteApplication, fmt.Errorf("service unavailable"))
+			defer fakeAzure.SetError(fake.DeleteApplication, nil)
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Expect(k8sClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+			Eventually(func() bool {
+				t, _ := getTerminator(terminator.Name)()
+				return meta.IsStatusConditionFalse(t.Status.Conditions, terminatorv1alpha1.ConditionDeleted)
+			}, timeout, interval).Should(BeTrue())
+
+			stuck, err := getTerminator(terminator.Name)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stuck.Status.RoleAssignments).To(BeEmpty())
+			_, ok := fakeAzure.RoleAssignment(roleAssignmentID)
+			Expect(ok).To(BeFalse())
+			Expect(meta.FindStatusCondition(stuck.Status.Conditions, terminatorv1alpha1.ConditionDeleted).Message).To(ContainSubstring("service unavailable"))
+
+			By("finding the Application already deleted from Azure")
+			fakeAzure.Modify(func(f *fake.IdentityProvider) {
+				delete(f.Applications, appObjectID)
+			})
+			fakeAzure.SetError(fake.DeleteApplication, nil)
+
+			Eventually(func() bool {
+				_, err := getTerminator(terminator.Name)()
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should keep the Azure objects with deletionPolicy Retain", func() {
+			terminator := newTerminator("retain-delete-test")
+			terminator.Spec.DeletionPolicy = terminatorv1alpha1.DeletionPolicyRetain
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			appObjectID := *created.Status.AppRegistration.ObjectID
+			spObjectID := *created.Status.ServicePrincipal.ObjectID
+			roleAssignmentID := *created.Status.RoleAssignments[0].ObjectID
+
+			Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+			Eventually(func() bool {
+				_, err := getTerminator(terminator.Name)()
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			_, ok := fakeAzure.Application(appObjectID)
+			Expect(ok).To(BeTrue())
+			sp, ok := fakeAzure.ServicePrincipal(spObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(sp.Disabled).To(BeFalse())
+			_, ok = fakeAzure.RoleAssignment(roleAssignmentID)
+			Expect(ok).To(BeTrue())
+
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentity{}))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentityBinding{}))).To(BeTrue())
+		})
+
+		It("Should disable the Service Principal and keep the Application with deletionPolicy Disable", func() {
+			terminator := newTerminator("disable-delete-test")
+			terminator.Spec.DeletionPolicy = terminatorv1alpha1.DeletionPolicyDisable
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			appObjectID := *created.Status.AppRegistration.ObjectID
+			spObjectID := *created.Status.ServicePrincipal.ObjectID
+			roleAssignmentID := *created.Status.RoleAssignments[0].ObjectID
+
+			Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+			Eventually(func() bool {
+				_, err := getTerminator(terminator.Name)()
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			_, ok := fakeAzure.Application(appObjectID)
+			Expect(ok).To(BeTrue())
+			sp, ok := fakeAzure.ServicePrincipal(spObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(sp.Disabled).To(BeTrue())
+			Expect(sp.Passwords).To(BeEmpty())
+			_, ok = fakeAzure.RoleAssignment(roleAssignmentID)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("Should block the deletion while the deletion protection annotation is set", func() {
+			terminator := newTerminator("protected-delete-test")
+			terminator.Annotations = map[string]string{terminatorv1alpha1.DeletionProtectionAnnotation: "true"}
+			Expect(k8sClient.Create(ctx, terminator)).To(Succeed())
+
+			var created *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() terminatorv1alpha1.Phase {
+				created, _ = getTerminator(terminator.Name)()
+				return created.Status.Phase
+			}, timeout, interval).Should(Equal(terminatorv1alpha1.PhaseReady))
+			appObjectID := *created.Status.AppRegistration.ObjectID
+
+			Expect(k8sClient.Delete(ctx, created)).To(Succeed())
+
+			var blocked *terminatorv1alpha1.AzureIdentityTerminator
+			Eventually(func() string {
+				blocked, _ = getTerminator(terminator.Name)()
+				deleted := meta.FindStatusCondition(blocked.Status.Conditions, terminatorv1alpha1.ConditionDeleted)
+				if deleted == nil {
+					return ""
+				}
+				return deleted.Reason
+			}, timeout, interval).Should(Equal(ReasonDeletionProtected))
+
+			_, ok := fakeAzure.Application(appObjectID)
+			Expect(ok).To(BeTrue())
+			key := types.NamespacedName{Name: terminator.Name, Namespace: namespace}
+			Expect(k8sClient.Get(ctx, key, &aadpodv1.AzureIdentity{})).To(Succeed())
+
+			By("lifting the deletion protection")
+			delete(blocked.Annotations, terminatorv1alpha1.DeletionProtectionAnnotation)
+			Expect(k8sClient.Update(ctx, blocked)).To(Succeed())
+
+			Eventually(func() bool {
+				_, err := getTerminator(terminator.Name)()
+				return errors.IsNotFound(err)
+			}, timeout, interval).Should(BeTrue())
+
+			_, ok = fakeAzure.Application(appObjectID)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,179 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+// Reasons used for the conditions of an AzureIdentityTerminator
+const (
+	ReasonCreated          = "Created"
+	ReasonCreateFailed     = "CreateFailed"
+	ReasonDeleteFailed     = "DeleteFailed"
+	ReasonProvisioning     = "Provisioning"
+	ReasonProvisioned      = "Provisioned"
+	ReasonUpdated          = "Updated"
+	ReasonStepFailed       = "StepFailed"
+	ReasonRotationDue      = "RotationDue"
+	ReasonRotationFailed   = "RotationFailed"
+	ReasonSecretRotated    = "SecretRotated"
+	ReasonSecretValid      = "SecretValid"
+	ReasonDeleting         = "Deleting"
+	ReasonSecretSyncFailed = "SecretSyncFailed"
+	// ReasonPrincipalNotFound is used while a new principal has not replicated to Azure Resource Manager
+	ReasonPrincipalNotFound = "PrincipalNotFound"
+	// ReasonDeletionProtected is used while the deletion protection annotation blocks a deletion
+	ReasonDeletionProtected = "DeletionProtected"
+	// ReasonAuthorizationFailed is used when the controller is not allowed to perform a step, which is not retried
+	ReasonAuthorizationFailed = "AuthorizationFailed"
+)
+
+// provisioningConditions are the conditions that must all be true for an AzureIdentityTerminator in podIdentity mode to be Ready
+var provisioningConditions = []string{
+	terminatorv1alpha1.ConditionPolicyCompliant,
+	terminatorv1alpha1.ConditionAppRegistered,
+	terminatorv1alpha1.ConditionServicePrincipalReady,
+	terminatorv1alpha1.ConditionRoleAssigned,
+	terminatorv1alpha1.ConditionSecretSynced,
+	terminatorv1alpha1.ConditionIdentityBound,
+}
+
+// workloadIdentityConditions are the provisioning conditions of an AzureIdentityTerminator in workloadIdentity mode
+var workloadIdentityConditions = []string{
+	terminatorv1alpha1.ConditionPolicyCompliant,
+	terminatorv1alpha1.ConditionAppRegistered,
+	terminatorv1alpha1.ConditionServicePrincipalReady,
+	terminatorv1alpha1.ConditionRoleAssigned,
+	terminatorv1alpha1.ConditionFederatedCredentialReady,
+	terminatorv1alpha1.ConditionIdentityBound,
+}
+
+// managedIdentityConditions are the provisioning conditions of an AzureIdentityTerminator bound to a user-assigned managed identity
+var managedIdentityConditions = []string{
+	terminatorv1alpha1.ConditionPolicyCompliant,
+	terminatorv1alpha1.ConditionManagedIdentityReady,
+	terminatorv1alpha1.ConditionRoleAssigned,
+	terminatorv1alpha1.ConditionIdentityBound,
+}
+
+// provisioningConditionsFor returns the provisioning conditions for the mode of the AzureIdentityTerminator
+func provisioningConditionsFor(t *terminatorv1alpha1.AzureIdentityTerminator) []string {
+	if isWorkloadIdentity(t) {
+		return workloadIdentityConditions
+	}
+	if isManagedIdentity(t) {
+		return managedIdentityConditions
+	}
+	return provisioningConditions
+}
+
+// setCondition records a condition against the current generation of the AzureIdentityTerminator
+func setCondition(t *terminatorv1alpha1.AzureIdentityTerminator, conditionType string, status v1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&t.Status.Conditions, v1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: t.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setConditionFailed records a failed step using the error as the condition message
+func setConditionFailed(t *terminatorv1alpha1.AzureIdentityTerminator, conditionType string, reason string, err error) {
+	setCondition(t, conditionType, v1.ConditionFalse, reason, err.Error())
+}
+
+// updatePhase derives the Ready condition, the phase and the observed generation from the provisioning conditions
+func updatePhase(t *terminatorv1alpha1.AzureIdentityTerminator) {
+	if !t.DeletionTimestamp.IsZero() {
+		setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionFalse, ReasonDeleting, "The AzureIdentityTerminator is being deleted")
+		t.Status.Phase = terminatorv1alpha1.PhaseDeleting
+		return
+	}
+
+	if degraded := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionDegraded); degraded != nil && degraded.Status == v1.ConditionTrue {
+		setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionFalse, degraded.Reason, degraded.Message)
+		t.Status.Phase = terminatorv1alpha1.PhaseDegraded
+		return
+	}
+
+	conditions := provisioningConditionsFor(t)
+	observed := false
+	for _, conditionType := range conditions {
+		condition := meta.FindStatusCondition(t.Status.Conditions, conditionType)
+		if condition == nil {
+			continue
+		}
+
+		observed = true
+		if condition.Status == v1.ConditionFalse {
+			setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionFalse, ReasonStepFailed, conditionType+": "+condition.Message)
+			t.Status.Phase = terminatorv1alpha1.PhaseFailed
+			return
+		}
+	}
+
+	for _, conditionType := range conditions {
+		if !meta.IsStatusConditionTrue(t.Status.Conditions, conditionType) {
+			setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionFalse, ReasonProvisioning, "Waiting for "+conditionType)
+			t.Status.Phase = terminatorv1alpha1.PhaseProvisioning
+			if !observed {
+				t.Status.Phase = terminatorv1alpha1.PhasePending
+			}
+			return
+		}
+	}
+
+	// Provision records the generation once every step has run against it
+	if t.Status.ObservedGeneration != t.Generation {
+		setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionFalse, ReasonProvisioning, fmt.Sprintf("Applying generation %d of the spec", t.Generation))
+		t.Status.Phase = terminatorv1alpha1.PhaseProvisioning
+		return
+	}
+
+	setCondition(t, terminatorv1alpha1.ConditionReady, v1.ConditionTrue, ReasonProvisioned, "All Azure and Kubernetes resources have been provisioned")
+	t.Status.Phase = terminatorv1alpha1.PhaseReady
+}
+
+// failStep records a failed provisioning step in the status and returns the error that caused it
+func (r *AzureIdentityTerminatorReconciler) failStep(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, conditionType string, reason string, err error) error {
+	setConditionFailed(t, conditionType, reason, err)
+	r.recordStepFailed(t, conditionType, reason, err)
+
+	// A failed status update is logged by updateStatus, the step error is what gets retried
+	r.updateStatus(ctx, t)
+	return err
+}
+
+// updateStatus refreshes the phase of the AzureIdentityTerminator and writes its status
+func (r *AzureIdentityTerminatorReconciler) updateStatus(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	updatePhase(t)
+	if err := r.Status().Update(ctx, t); err != nil {
+		r.Log.Error(err, "Failed to update status of AzureIdentityTerminator", "AzureIdentityTerminator.Name", t.Name)
+		return err
+	}
+
+	terminatorMetrics.observe(t)
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+// Reasons of the events recorded on an AzureIdentityTerminator
+const (
+	EventApplicationRegistered       = "ApplicationRegistered"
+	EventApplicationUpdated          = "ApplicationUpdated"
+	EventServicePrincipalCreated     = "ServicePrincipalCreated"
+	EventServicePrincipalUpdated     = "ServicePrincipalUpdated"
+	EventAzureIdentityBindingUpdated = "AzureIdentityBindingUpdated"
+	EventRoleAssigned                = "RoleAssigned"
+	EventSecretCreated               = "SecretCreated"
+	EventAzureIdentityCreated        = "AzureIdentityCreated"
+	EventAzureIdentityBindingCreated = "AzureIdentityBindingCreated"
+	EventFederatedCredentialCreated  = "FederatedCredentialCreated"
+	EventFederatedCredentialUpdated  = "FederatedCredentialUpdated"
+	EventManagedIdentityCreated      = "ManagedIdentityCreated"
+	EventServiceAccountAnnotated     = "ServiceAccountAnnotated"
+	EventClientSecretRotated         = "ClientSecretRotated"
+	EventPreviousSecretRemoved       = "PreviousClientSecretRemoved"
+	EventRotationFailed              = "RotationFailed"
+	EventResourceRepaired            = "ResourceRepaired"
+	EventDriftDetected               = "DriftDetected"
+	EventAuditFailed                 = "AuditFailed"
+	EventPolicyViolation             = "PolicyViolation"
+	EventDeleting                    = "Deleting"
+	EventResourceDeleted             = "ResourceDeleted"
+	EventDeleteFailed                = "DeleteFailed"
+	EventServicePrincipalDisabled    = "ServicePrincipalDisabled"
+	EventDeletionProtected           = "DeletionProtected"
+)
+
+// stepDescriptions describe the provisioning step behind each condition in the events of failed steps
+var stepDescriptions = map[string]string{
+	terminatorv1alpha1.ConditionAppRegistered:            "register the Azure AD Application",
+	terminatorv1alpha1.ConditionServicePrincipalReady:    "create the Service Principal",
+	terminatorv1alpha1.ConditionRoleAssigned:             "create the role assignment",
+	terminatorv1alpha1.ConditionSecretSynced:             "sync the ClientSecret to its Secret",
+	terminatorv1alpha1.ConditionIdentityBound:            "bind the identity to pods",
+	terminatorv1alpha1.ConditionFederatedCredentialReady: "create the federated identity credential",
+	terminatorv1alpha1.ConditionManagedIdentityReady:     "create the user-assigned managed identity",
+}
+
+// secretPatterns match credentials that may be echoed back in Azure or Kubernetes error messages
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// The secretText of a Graph passwordCredential
+	{regexp.MustCompile(`("secretText"\s*:\s*")[^"]*"`), `${1}[REDACTED]"`},
+	// Form, query and JSON parameters carrying a secret
+	{regexp.MustCompile(`(?i)((?:client_secret|clientSecret|client_assertion|password)["']?\s*[=:]\s*["']?)[^"'&\s,}]+`), `${1}[REDACTED]`},
+	// Access tokens in Authorization headers
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), `${1}[REDACTED]`},
+	// Azure AD client secrets, which embed a Q~ marker after their first characters
+	{regexp.MustCompile(`[A-Za-z0-9_\-.]{3}\dQ~[A-Za-z0-9_~.\-]{31,34}`), `[REDACTED]`},
+}
+
+// redactSecrets removes anything resembling a credential from an event message
+func redactSecrets(message string) string {
+	for _, s := range secretPatterns {
+		message = s.pattern.ReplaceAllString(message, s.replacement)
+	}
+	return message
+}
+
+// recordEvent records an event on the AzureIdentityTerminator with any credentials redacted from its message
+func (r *AzureIdentityTerminatorReconciler) recordEvent(t *terminatorv1alpha1.AzureIdentityTerminator, eventType string, reason string, message string) {
+	r.Recorder.Event(t, eventType, reason, redactSecrets(message))
+}
+
+// recordStepFailed records a Warning event for a failed provisioning step, including the error returned by Azure
+func (r *AzureIdentityTerminatorReconciler) recordStepFailed(t *terminatorv1alpha1.AzureIdentityTerminator, conditionType string, reason string, err error) {
+	step, ok := stepDescriptions[conditionType]
+	if !ok {
+		step = "reconcile " + conditionType
+	}
+	r.recordEvent(t, corev1.EventTypeWarning, reason, "Failed to "+step+": "+err.Error())
+}
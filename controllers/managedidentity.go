@@ -0,0 +1,146 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// Tags recorded on each user-assigned managed identity so it can be traced back to its AzureIdentityTerminator
+const (
+	managedIdentityOwnerTag     = "azidterminator-owner"
+	managedIdentityNamespaceTag = "azidterminator-namespace"
+	managedIdentityNameTag      = "azidterminator-name"
+)
+
+// isManagedIdentity reports whether pods are bound to a user-assigned managed identity instead of a Service Principal
+func isManagedIdentity(t *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	return !isWorkloadIdentity(t) && t.Spec.IdentityType == terminatorv1alpha1.IdentityTypeUserAssignedMSI
+}
+
+// managedIdentityName returns the name of the user-assigned managed identity, derived from the UID of the
+// AzureIdentityTerminator so that it is unique within the resource group and creating it again adopts it
+func managedIdentityName(t *terminatorv1alpha1.AzureIdentityTerminator) string {
+	if t.Status.ManagedIdentity.Name != "" {
+		return t.Status.ManagedIdentity.Name
+	}
+	return "azidterminator-" + string(t.UID)
+}
+
+// managedIdentity describes the user-assigned managed identity of the AzureIdentityTerminator. Once created it
+// is looked up in the resource group recorded in its ID, even if the operator has since been configured with another.
+func (r *AzureIdentityTerminatorReconciler) managedIdentity(t *terminatorv1alpha1.AzureIdentityTerminator) azuread.ManagedIdentity {
+	mi := azuread.ManagedIdentity{
+		Location:      r.ManagedIdentityLocation,
+		Name:          managedIdentityName(t),
+		ResourceGroup: r.ManagedIdentityResourceGroup,
+		Tags: map[string]string{
+			managedIdentityOwnerTag:     string(t.UID),
+			managedIdentityNamespaceTag: t.Namespace,
+			managedIdentityNameTag:      t.Name,
+		},
+	}
+
+	status := t.Status.ManagedIdentity
+	if status.ClientID != nil {
+		mi.ClientID = *status.ClientID
+	}
+	if status.PrincipalID != nil {
+		mi.PrincipalID = *status.PrincipalID
+	}
+	if status.ResourceID != nil {
+		mi.ResourceID = *status.ResourceID
+		if resource, err := azure.ParseResourceID(mi.ResourceID); err == nil {
+			mi.ResourceGroup = resource.ResourceGroup
+		}
+	}
+
+	return mi
+}
+
+// ensureManagedIdentity creates the user-assigned managed identity unless the status already records it
+func (r *AzureIdentityTerminatorReconciler) ensureManagedIdentity(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if t.Status.ManagedIdentity.ResourceID != nil {
+		return nil
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	mi := &aadApp.ManagedIdentity
+	if mi.ResourceGroup == "" || mi.Location == "" {
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionManagedIdentityReady, ReasonCreateFailed, fmt.Errorf("no resource group and location are configured for the operator to create managed identities in"))
+	}
+
+	log.Info("Creating user-assigned managed identity", "managedIdentity.Name", mi.Name, "managedIdentity.ResourceGroup", mi.ResourceGroup)
+	if err := r.Azure.CreateManagedIdentity(ctx, aadApp); err != nil {
+		log.Error(err, "Failed to create user-assigned managed identity", "managedIdentity.Name", mi.Name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionManagedIdentityReady, ReasonCreateFailed, err)
+	}
+
+	log.Info("Successfully created user-assigned managed identity", "managedIdentity.ResourceID", mi.ResourceID)
+	t.Status.ManagedIdentity.Name = mi.Name
+	t.Status.ManagedIdentity.ClientID = to.StringPtr(mi.ClientID)
+	t.Status.ManagedIdentity.PrincipalID = to.StringPtr(mi.PrincipalID)
+	t.Status.ManagedIdentity.ResourceID = to.StringPtr(mi.ResourceID)
+	setCondition(t, terminatorv1alpha1.ConditionManagedIdentityReady, v1.ConditionTrue, ReasonCreated, "Managed identity "+mi.Name+" has been created with ClientID "+mi.ClientID)
+	r.recordEvent(t, corev1.EventTypeNormal, EventManagedIdentityCreated, "Created user-assigned managed identity "+mi.Name+" in resource group "+mi.ResourceGroup+" with ClientID "+mi.ClientID)
+	return r.updateStatus(ctx, t)
+}
+
+// forgetManagedIdentity clears the managed identity and its role assignments from the status so that they are provisioned again
+func (r *AzureIdentityTerminatorReconciler) forgetManagedIdentity(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	if err := r.deleteRoleAssignments(ctx, t, r.AppForTerminator(t)); err != nil {
+		return err
+	}
+
+	t.Status.ManagedIdentity.ClientID = nil
+	t.Status.ManagedIdentity.PrincipalID = nil
+	t.Status.ManagedIdentity.ResourceID = nil
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionManagedIdentityReady)
+	meta.RemoveStatusCondition(&t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned)
+	return nil
+}
+
+// deleteManagedIdentity deletes the user-assigned managed identity. One that is already gone counts as deleted.
+func (r *AzureIdentityTerminatorReconciler) deleteManagedIdentity(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	// A managed identity created by a reconcile that failed to record it is found by its name in the configured resource group
+	if t.Status.ManagedIdentity.ResourceID == nil && aadApp.ManagedIdentity.ResourceGroup == "" {
+		return nil
+	}
+
+	mi := aadApp.ManagedIdentity
+	if err := r.Azure.DeleteManagedIdentity(ctx, aadApp); err != nil && !azuread.IsNotFound(err) {
+		r.Log.Error(err, "Failed to delete user-assigned managed identity", "managedIdentity.ResourceID", mi.ResourceID)
+		r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to delete user-assigned managed identity "+mi.Name+": "+err.Error())
+		return err
+	}
+
+	r.Log.Info("Successfully deleted user-assigned managed identity", "managedIdentity.ResourceID", mi.ResourceID)
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Deleted user-assigned managed identity "+mi.Name)
+	t.Status.ManagedIdentity.ResourceID = nil
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+// terminatorMetrics is refreshed with every status update and exports the state of each
+// AzureIdentityTerminator when scraped, so the time until expiry is always current
+var terminatorMetrics = newTerminatorCollector()
+
+func init() {
+	metrics.Registry.MustRegister(terminatorMetrics)
+}
+
+// terminatorState is the part of an AzureIdentityTerminator status exported as metrics
+type terminatorState struct {
+	clientSecretExpiration *time.Time
+	conditions             map[string]v1.ConditionStatus
+}
+
+// terminatorCollector is a prometheus.Collector for the AzureIdentityTerminators seen by the controller
+type terminatorCollector struct {
+	mu          sync.Mutex
+	terminators map[types.NamespacedName]terminatorState
+
+	clientSecretExpiry *prometheus.Desc
+	conditions         *prometheus.Desc
+}
+
+func newTerminatorCollector() *terminatorCollector {
+	return &terminatorCollector{
+		terminators: map[types.NamespacedName]terminatorState{},
+		clientSecretExpiry: prometheus.NewDesc(
+			"azidterminator_client_secret_expiry_seconds",
+			"Seconds until the ClientSecret of an AzureIdentityTerminator expires. Negative once it has expired.",
+			[]string{"namespace", "name"}, nil),
+		conditions: prometheus.NewDesc(
+			"azidterminator_terminators",
+			"Number of AzureIdentityTerminators by condition and status.",
+			[]string{"condition", "status"}, nil),
+	}
+}
+
+// observe records the current status of the AzureIdentityTerminator
+func (c *terminatorCollector) observe(t *terminatorv1alpha1.AzureIdentityTerminator) {
+	state := terminatorState{conditions: map[string]v1.ConditionStatus{}}
+	if exp := t.Status.ServicePrincipal.ClientSecretExpiration; exp != nil {
+		state.clientSecretExpiration = &exp.Time
+	}
+	for _, condition := range t.Status.Conditions {
+		state.conditions[condition.Type] = condition.Status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.terminators[types.NamespacedName{Name: t.Name, Namespace: t.Namespace}] = state
+}
+
+// forget stops exporting an AzureIdentityTerminator once it is deleted
+func (c *terminatorCollector) forget(name types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.terminators, name)
+}
+
+// Describe implements prometheus.Collector
+func (c *terminatorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clientSecretExpiry
+	ch <- c.conditions
+}
+
+// Collect implements prometheus.Collector
+func (c *terminatorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := map[[2]string]int{}
+	for name, state := range c.terminators {
+		if state.clientSecretExpiration != nil {
+			ch <- prometheus.MustNewConstMetric(c.clientSecretExpiry, prometheus.GaugeValue,
+				time.Until(*state.clientSecretExpiration).Seconds(), name.Namespace, name.Name)
+		}
+		for conditionType, status := range state.conditions {
+			counts[[2]string{conditionType, string(status)}]++
+		}
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.conditions, prometheus.GaugeValue, float64(count), key[0], key[1])
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// azureIdentityName returns the name of the AzureIdentity and AzureIdentityBinding, which falls back to the
+// name of the AzureIdentityTerminator for terminators admitted before azureIdentityName was required
+func azureIdentityName(t *terminatorv1alpha1.AzureIdentityTerminator) string {
+	if t.Spec.AzureIdentityName != "" {
+		return t.Spec.AzureIdentityName
+	}
+	return t.Name
+}
+
+// secretName returns the name of the Secret holding the ClientSecret
+func secretName(t *terminatorv1alpha1.AzureIdentityTerminator) string {
+	if t.Spec.SecretName != "" {
+		return t.Spec.SecretName
+	}
+	return t.Name
+}
+
+// migrateResourceNames records the names of the objects created in podIdentity mode by terminators provisioned
+// before the names were recorded in the status. Those objects were all named after the AzureIdentityTerminator,
+// while status.azureIdentityBinding held a copy of spec.azureIdentityName.
+func migrateResourceNames(t *terminatorv1alpha1.AzureIdentityTerminator) {
+	if isWorkloadIdentity(t) || t.Status.AzureIdentity != "" || t.Status.AzureIdentityBinding == "" {
+		return
+	}
+
+	t.Status.AzureIdentity = t.Name
+	t.Status.AzureIdentityBinding = t.Name
+	if !isManagedIdentity(t) {
+		t.Status.Secret = t.Name
+	}
+}
+
+// movedSecret returns the Secret recorded in the status when the spec has since given the Secret another name,
+// so that the ClientSecret it holds can be moved rather than replaced. It returns nil when there is nothing to move.
+func (r *AzureIdentityTerminatorReconciler) movedSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) (*corev1.Secret, error) {
+	if t.Status.Secret == "" || t.Status.Secret == secretName(t) {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: t.Status.Secret, Namespace: t.Namespace}, secret)
+	if errors.IsNotFound(err) || (err == nil && len(secret.Data[clientSecretKey]) == 0) {
+		return nil, nil
+	}
+	return secret, err
+}
+
+// recordPodIdentityNames records the names of the AzureIdentity, AzureIdentityBinding and Secret once they
+// exist, deleting the ones left behind under the names previously recorded when the spec renamed them
+func (r *AzureIdentityTerminatorReconciler) recordPodIdentityNames(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	name := azureIdentityName(t)
+	var renamed []client.Object
+	if t.Status.AzureIdentityBinding != "" && t.Status.AzureIdentityBinding != name {
+		renamed = append(renamed, &aadpodv1.AzureIdentityBinding{ObjectMeta: v1.ObjectMeta{Name: t.Status.AzureIdentityBinding, Namespace: t.Namespace}})
+	}
+	if t.Status.AzureIdentity != "" && t.Status.AzureIdentity != name {
+		renamed = append(renamed, &aadpodv1.AzureIdentity{ObjectMeta: v1.ObjectMeta{Name: t.Status.AzureIdentity, Namespace: t.Namespace}})
+	}
+	if t.Status.Secret != "" && (isManagedIdentity(t) || t.Status.Secret != secretName(t)) {
+		renamed = append(renamed, &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: t.Status.Secret, Namespace: t.Namespace}})
+	}
+
+	for _, obj := range renamed {
+		if err := r.deleteObject(ctx, t, obj); err != nil {
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonDeleteFailed, err)
+		}
+	}
+
+	t.Status.AzureIdentity = name
+	t.Status.AzureIdentityBinding = name
+	t.Status.Secret = ""
+	if !isManagedIdentity(t) {
+		t.Status.Secret = secretName(t)
+	}
+	return nil
+}
+
+// podIdentityObjectNames returns the names recorded in the status and the names the spec asks for, without
+// repeats, so that objects created under either are found while a rename is in progress
+func podIdentityObjectNames(recorded string, desired string) []string {
+	if recorded == "" || recorded == desired {
+		return []string{desired}
+	}
+	return []string{recorded, desired}
+}
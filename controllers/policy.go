@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/policy"
+)
+
+// Reasons used for the PolicyCompliant condition
+const (
+	ReasonCompliant       = "Compliant"
+	ReasonPolicyViolation = "PolicyViolation"
+)
+
+// +kubebuilder:rbac:groups=azidterminator.io,resources=azureidentitypolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// checkPolicies records whether the AzureIdentityTerminator complies with the AzureIdentityPolicies selecting its
+// namespace. A non-compliant AzureIdentityTerminator is neither provisioned nor rotated until it or the policies change.
+func (r *AzureIdentityTerminatorReconciler) checkPolicies(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) (bool, error) {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+
+	violations, err := policy.Violations(ctx, r.Client, t, r.SubscriptionID)
+	if err != nil {
+		log.Error(err, "Failed to evaluate AzureIdentityPolicies")
+		return false, err
+	}
+
+	if len(violations) == 0 {
+		setCondition(t, terminatorv1alpha1.ConditionPolicyCompliant, v1.ConditionTrue, ReasonCompliant, "The AzureIdentityTerminator complies with every AzureIdentityPolicy")
+		return true, r.updateStatus(ctx, t)
+	}
+
+	message := violations.ToAggregate().Error()
+	log.Info("AzureIdentityTerminator violates AzureIdentityPolicies", "violations", message)
+
+	// Only record the event when the violations change, not on every requeue
+	if previous := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionPolicyCompliant); previous == nil || previous.Status != v1.ConditionFalse || previous.Message != message {
+		r.recordEvent(t, corev1.EventTypeWarning, EventPolicyViolation, message)
+	}
+
+	setCondition(t, terminatorv1alpha1.ConditionPolicyCompliant, v1.ConditionFalse, ReasonPolicyViolation, message)
+	return false, r.updateStatus(ctx, t)
+}
+
+// terminatorsForPolicy enqueues every AzureIdentityTerminator when an AzureIdentityPolicy changes
+func (r *AzureIdentityTerminatorReconciler) terminatorsForPolicy(obj client.Object) []reconcile.Request {
+	return r.terminatorRequests(&terminatorv1alpha1.AzureIdentityTerminatorList{})
+}
+
+// terminatorsForNamespace enqueues the AzureIdentityTerminators of a Namespace when its labels change
+// which AzureIdentityPolicies select it
+func (r *AzureIdentityTerminatorReconciler) terminatorsForNamespace(obj client.Object) []reconcile.Request {
+	return r.terminatorRequests(&terminatorv1alpha1.AzureIdentityTerminatorList{}, client.InNamespace(obj.GetName()))
+}
+
+func (r *AzureIdentityTerminatorReconciler) terminatorRequests(list *terminatorv1alpha1.AzureIdentityTerminatorList, opts ...client.ListOption) []reconcile.Request {
+	if err := r.List(context.Background(), list, opts...); err != nil {
+		r.Log.Error(err, "Failed to list AzureIdentityTerminators")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, t := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: t.Name, Namespace: t.Namespace}})
+	}
+	return requests
+}
@@ -0,0 +1,631 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+const (
+	// ReasonAdopted is used when a step finds the object an earlier, interrupted reconcile created
+	ReasonAdopted = "Adopted"
+	// ReasonLookupFailed is used when a step cannot check for an object an earlier reconcile created
+	ReasonLookupFailed = "LookupFailed"
+	// ReasonNameConflict is used when a step finds its object is controlled by another owner, such as another AzureIdentityTerminator
+	ReasonNameConflict = "NameConflict"
+
+	// Annotations on the Secret recording which ClientSecret it holds, so the status can be recovered from it
+	clientSecretKeyIDAnnotation      = "azidterminator.io/client-secret-key-id"
+	clientSecretExpirationAnnotation = "azidterminator.io/client-secret-expiration"
+)
+
+// provisionStep is a single, resumable step in provisioning an AzureIdentityTerminator
+type provisionStep func(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error
+
+// requeueError stops provisioning at a step that is waiting on Azure, to be retried after a delay rather than failing the reconcile
+type requeueError struct {
+	error
+	after time.Duration
+}
+
+// terminalError stops provisioning at a step that cannot succeed without someone intervening, so it is not retried
+type terminalError struct {
+	error
+}
+
+// conflictError stops provisioning at a step whose object already exists under the control of another owner
+type conflictError struct {
+	error
+}
+
+// adoptFailedReason returns the reason a step fails with when adopting its object failed with err
+func adoptFailedReason(err error) string {
+	var conflict *conflictError
+	if stderrors.As(err, &conflict) {
+		return ReasonNameConflict
+	}
+	return ReasonCreateFailed
+}
+
+// resultForError returns the result a reconcile that failed with err should end with
+func resultForError(err error) (ctrl.Result, error) {
+	var requeue *requeueError
+	if stderrors.As(err, &requeue) {
+		return ctrl.Result{RequeueAfter: requeue.after}, nil
+	}
+
+	var terminal *terminalError
+	if stderrors.As(err, &terminal) {
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{}, err
+}
+
+// Provision walks through each step of provisioning the AzureIdentityTerminator. Every step
+// that creates an object in Azure checkpoints its ID in the status before the next step runs,
+// so a reconcile that fails part way resumes where it left off instead of starting over.
+func (r *AzureIdentityTerminatorReconciler) Provision(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) error {
+	aadApp := r.AppForTerminator(t)
+	for _, step := range r.provisionSteps(t) {
+		if err := step(ctx, t, aadApp); err != nil {
+			return err
+		}
+	}
+
+	t.Status.ObservedGeneration = t.Generation
+	return r.updateStatus(ctx, t)
+}
+
+// provisionSteps returns the steps that provision the AzureIdentityTerminator in its mode
+func (r *AzureIdentityTerminatorReconciler) provisionSteps(t *terminatorv1alpha1.AzureIdentityTerminator) []provisionStep {
+	if isWorkloadIdentity(t) {
+		return []provisionStep{
+			r.ensureApplication,
+			r.ensureDisplayName,
+			r.ensureServicePrincipal,
+			r.ensureServicePrincipalTags,
+			r.ensureRoleAssignments,
+			r.ensureFederatedIdentityCredential,
+			r.ensureServiceAccount,
+		}
+	}
+
+	if isManagedIdentity(t) {
+		return []provisionStep{
+			r.ensureManagedIdentity,
+			r.ensureRoleAssignments,
+			r.ensureAzureIdentity,
+			r.ensureAzureIdentityBinding,
+			r.recordPodIdentityNames,
+		}
+	}
+
+	return []provisionStep{
+		r.ensureApplication,
+		r.ensureDisplayName,
+		r.ensureServicePrincipal,
+		r.ensureServicePrincipalTags,
+		r.ensureRoleAssignments,
+		r.ensureSecret,
+		r.ensureAzureIdentity,
+		r.ensureAzureIdentityBinding,
+		r.recordPodIdentityNames,
+	}
+}
+
+// AppForTerminator builds the azuread.App described by the spec and the checkpoints in the status of the AzureIdentityTerminator
+func (r *AzureIdentityTerminatorReconciler) AppForTerminator(t *terminatorv1alpha1.AzureIdentityTerminator) *azuread.App {
+	aadApp := &azuread.App{
+		DisplayName: t.Spec.AppRegistration.DisplayName,
+		OwnerID:     string(t.UID),
+		ServicePrincipal: azuread.ServicePrincipal{
+			Duration: t.Spec.ServicePrincipal.ClientSecretDuration,
+			Tags:     t.Spec.ServicePrincipal.Tags,
+		},
+	}
+
+	status := t.Status
+	if status.AppRegistration.ObjectID != nil {
+		aadApp.ObjectID = *status.AppRegistration.ObjectID
+	}
+	if status.AppRegistration.ClientID != nil {
+		aadApp.ClientID = *status.AppRegistration.ClientID
+	}
+	if status.AppRegistration.TenantID != nil {
+		aadApp.TenantID = *status.AppRegistration.TenantID
+	}
+	if status.ServicePrincipal.ObjectID != nil {
+		aadApp.ServicePrincipal.ObjectID = *status.ServicePrincipal.ObjectID
+	}
+	for _, ra := range status.RoleAssignments {
+		aadApp.RoleAssignments = append(aadApp.RoleAssignments, azureRoleAssignment(ra))
+	}
+	if isWorkloadIdentity(t) {
+		aadApp.FederatedIdentityCredential = r.federatedIdentityCredential(t)
+	}
+	if isManagedIdentity(t) {
+		aadApp.ManagedIdentity = r.managedIdentity(t)
+	}
+
+	return aadApp
+}
+
+// ensureApplication registers the Azure AD Application unless the status already records it.
+// An Application registered by an earlier reconcile that failed to record it is adopted instead.
+func (r *AzureIdentityTerminatorReconciler) ensureApplication(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if t.Status.AppRegistration.ObjectID != nil {
+		return r.backfillClientID(ctx, t, aadApp)
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	reason := ReasonAdopted
+	found, err := r.Azure.FindApplication(ctx, aadApp)
+	if err != nil {
+		log.Error(err, "Failed to look up Azure AD Application")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionAppRegistered, ReasonLookupFailed, err)
+	}
+
+	if !found {
+		log.Info("Creating a new Azure AD App Registration", "appRegistration.displayName", aadApp.DisplayName)
+		if err := r.Azure.CreateApplication(ctx, aadApp); err != nil {
+			log.Error(err, "Failed to create azuread application")
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionAppRegistered, ReasonCreateFailed, err)
+		}
+		reason = ReasonCreated
+	}
+
+	log.Info("Successfully registered Azure AD Application", "appRegistration.ObjectID", aadApp.ObjectID, "reason", reason)
+	t.Status.AppRegistration.ObjectID = to.StringPtr(aadApp.ObjectID)
+	t.Status.AppRegistration.ClientID = to.StringPtr(aadApp.ClientID)
+	t.Status.AppRegistration.TenantID = to.StringPtr(aadApp.TenantID)
+	if reason == ReasonCreated {
+		t.Status.AppRegistration.DisplayName = aadApp.DisplayName
+	}
+	setCondition(t, terminatorv1alpha1.ConditionAppRegistered, v1.ConditionTrue, reason, "Application "+aadApp.ClientID+" has been registered")
+	r.recordEvent(t, corev1.EventTypeNormal, EventApplicationRegistered, reason+" Azure AD Application "+aadApp.DisplayName+" with ClientID "+aadApp.ClientID)
+	return r.updateStatus(ctx, t)
+}
+
+// backfillClientID recovers the ClientID and TenantID of terminators created before they were recorded
+// in the status from the AzureIdentity that references the Application
+func (r *AzureIdentityTerminatorReconciler) backfillClientID(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if aadApp.ClientID != "" {
+		return nil
+	}
+
+	name := t.Status.AzureIdentity
+	if name == "" {
+		name = azureIdentityName(t)
+	}
+
+	azID := &aadpodv1.AzureIdentity{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: t.Namespace}, azID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	aadApp.ClientID = azID.Spec.ClientID
+	aadApp.TenantID = azID.Spec.TenantID
+	t.Status.AppRegistration.ClientID = to.StringPtr(aadApp.ClientID)
+	t.Status.AppRegistration.TenantID = to.StringPtr(aadApp.TenantID)
+	return nil
+}
+
+// ensureServicePrincipal creates the Service Principal unless the status already records it
+func (r *AzureIdentityTerminatorReconciler) ensureServicePrincipal(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if t.Status.ServicePrincipal.ObjectID != nil {
+		return nil
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	reason := ReasonAdopted
+	found, err := r.Azure.FindServicePrincipal(ctx, aadApp)
+	if err != nil {
+		log.Error(err, "Failed to look up Service Principal")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionServicePrincipalReady, ReasonLookupFailed, err)
+	}
+
+	if !found {
+		log.Info("Creating Service Principal", "appRegistration.ClientID", aadApp.ClientID)
+		if err := r.Azure.CreateServicePrincipal(ctx, aadApp); err != nil {
+			log.Error(err, "Failed to create Service Principal")
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionServicePrincipalReady, ReasonCreateFailed, err)
+		}
+		reason = ReasonCreated
+	}
+
+	log.Info("Successfully created Service Principal", "servicePrincipal.ObjectID", aadApp.ServicePrincipal.ObjectID, "reason", reason)
+	t.Status.ServicePrincipal.ObjectID = to.StringPtr(aadApp.ServicePrincipal.ObjectID)
+	if reason == ReasonCreated {
+		t.Status.ServicePrincipal.Tags = append([]string(nil), aadApp.ServicePrincipal.Tags...)
+	}
+	setCondition(t, terminatorv1alpha1.ConditionServicePrincipalReady, v1.ConditionTrue, reason, "Service Principal "+aadApp.ServicePrincipal.ObjectID+" has been created")
+	r.recordEvent(t, corev1.EventTypeNormal, EventServicePrincipalCreated, reason+" Service Principal "+aadApp.ServicePrincipal.ObjectID)
+	return r.updateStatus(ctx, t)
+}
+
+// ensureSecret creates the Secret holding the ClientSecret. The ClientSecret is only known when it is
+// added, so a new one is added to the Service Principal whenever the Secret has to be created or no
+// longer holds it, and the ClientSecret it replaces is removed.
+func (r *AzureIdentityTerminatorReconciler) ensureSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName(t), Namespace: t.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Secret", "Secret.Name", secretName(t))
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonLookupFailed, err)
+	}
+
+	exists := err == nil
+	if !exists {
+		// A Secret given a new name in the spec keeps the ClientSecret of the Secret it replaces
+		moved, err := r.movedSecret(ctx, t)
+		if err != nil {
+			log.Error(err, "Failed to get Secret", "Secret.Name", t.Status.Secret)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonLookupFailed, err)
+		}
+		if moved != nil {
+			return r.moveSecret(ctx, t, moved)
+		}
+	}
+
+	if exists {
+		if err := r.adopt(ctx, t, secret); err != nil {
+			log.Error(err, "Failed to set owner reference on Secret", "Secret.Name", secret.Name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, adoptFailedReason(err), err)
+		}
+
+		recoverClientSecretStatus(t, secret)
+		if len(secret.Data[clientSecretKey]) > 0 {
+			if err := r.restoreClientSecretAnnotations(ctx, t, secret); err != nil {
+				log.Error(err, "Failed to restore annotations of Secret", "Secret.Name", secret.Name)
+				return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonSecretSyncFailed, err)
+			}
+
+			setCondition(t, terminatorv1alpha1.ConditionSecretSynced, v1.ConditionTrue, ReasonCreated, "Secret "+secret.Name+" holds the current ClientSecret")
+			return nil
+		}
+
+		log.Info("Secret no longer holds a ClientSecret", "Secret.Name", secret.Name)
+	}
+
+	// The ClientSecret held by a deleted or emptied Secret can't be recovered, so it is replaced
+	var lostKeyID string
+	if t.Status.ServicePrincipal.ClientSecretKeyID != nil {
+		lostKeyID = *t.Status.ServicePrincipal.ClientSecretKeyID
+	}
+
+	log.Info("Adding ClientSecret to Service Principal", "servicePrincipal.ObjectID", aadApp.ServicePrincipal.ObjectID)
+	if err := r.Azure.AddPassword(ctx, aadApp); err != nil {
+		log.Error(err, "Failed to add ClientSecret to Service Principal")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonCreateFailed, err)
+	}
+
+	// Create Secret that will contain the ClientSecret for the AzureIdentity
+	sec := secret
+	if exists {
+		log.Info("Restoring ClientSecret in Secret", "Secret.Name", secret.Name)
+		setClientSecret(secret, aadApp.ServicePrincipal)
+		err = r.Update(ctx, secret)
+	} else {
+		log.Info("Creating secret for AzureIdentityBinding", "clientID", aadApp.ClientID)
+		if sec, err = r.SecretManfiest(t, aadApp); err == nil {
+			err = r.Create(ctx, sec)
+		}
+	}
+	if err != nil {
+		log.Error(err, "Failed to write Secret", "Secret.Name", secretName(t))
+
+		// The ClientSecret can never be read back, so don't leave it behind on the Service Principal
+		if removeErr := r.Azure.RemovePassword(ctx, aadApp, aadApp.ServicePrincipal.ClientSecretKeyID); removeErr != nil {
+			log.Error(removeErr, "Failed to remove unused ClientSecret", "keyID", aadApp.ServicePrincipal.ClientSecretKeyID)
+		}
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonCreateFailed, err)
+	}
+
+	log.Info("Successfully created Secret", "Secret.Name", sec.Name)
+	t.Status.ServicePrincipal.ClientSecretKeyID = to.StringPtr(aadApp.ServicePrincipal.ClientSecretKeyID)
+	t.Status.ServicePrincipal.ClientSecretExpiration = &v1.Time{Time: aadApp.ServicePrincipal.ClientSecretExpiration.Time}
+	setCondition(t, terminatorv1alpha1.ConditionSecretSynced, v1.ConditionTrue, ReasonCreated, "Secret "+sec.Name+" holds the current ClientSecret")
+	expiration := t.Status.ServicePrincipal.ClientSecretExpiration.UTC().Format(time.RFC3339)
+	if lostKeyID == "" {
+		r.recordEvent(t, corev1.EventTypeNormal, EventSecretCreated, "Created Secret "+sec.Name+" with a ClientSecret that expires at "+expiration)
+		return r.updateStatus(ctx, t)
+	}
+
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Replaced the lost ClientSecret "+lostKeyID+" in Secret "+sec.Name+" with one that expires at "+expiration)
+	if err := r.updateStatus(ctx, t); err != nil {
+		return err
+	}
+
+	// Nothing can use the lost ClientSecret any more, so a failure to remove it is only logged
+	if err := r.Azure.RemovePassword(ctx, aadApp, lostKeyID); err != nil {
+		log.Error(err, "Failed to remove lost ClientSecret", "keyID", lostKeyID)
+	}
+	return nil
+}
+
+// moveSecret copies the ClientSecret and the annotations recording it from the Secret recorded in the status to a
+// Secret under the name the spec asks for. The Secret it was moved from is deleted once the AzureIdentity references the new one.
+func (r *AzureIdentityTerminatorReconciler) moveSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, moved *corev1.Secret) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	sec := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        secretName(t),
+			Namespace:   t.Namespace,
+			Annotations: map[string]string{},
+		},
+		Immutable: to.BoolPtr(false),
+		Data:      map[string][]byte{clientSecretKey: moved.Data[clientSecretKey]},
+	}
+	for _, key := range []string{clientSecretKeyIDAnnotation, clientSecretExpirationAnnotation} {
+		if value, ok := moved.Annotations[key]; ok {
+			sec.Annotations[key] = value
+		}
+	}
+	if err := ctrl.SetControllerReference(t, sec, r.Scheme); err != nil {
+		log.Error(err, "Failed to set owner reference on Secret", "Secret.Name", sec.Name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonCreateFailed, err)
+	}
+
+	log.Info("Moving ClientSecret to renamed Secret", "Secret.Name", sec.Name, "previous", moved.Name)
+	if err := r.Create(ctx, sec); err != nil {
+		log.Error(err, "Failed to create Secret", "Secret.Name", sec.Name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonCreateFailed, err)
+	}
+
+	recoverClientSecretStatus(t, sec)
+	setCondition(t, terminatorv1alpha1.ConditionSecretSynced, v1.ConditionTrue, ReasonCreated, "Secret "+sec.Name+" holds the current ClientSecret")
+	r.recordEvent(t, corev1.EventTypeNormal, EventSecretCreated, "Moved the ClientSecret from Secret "+moved.Name+" to Secret "+sec.Name)
+	return r.updateStatus(ctx, t)
+}
+
+// setClientSecret writes the ClientSecret of the Service Principal and the annotations recording it into the Secret
+func setClientSecret(secret *corev1.Secret, sp azuread.ServicePrincipal) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[clientSecretKey] = []byte(sp.ClientSecret)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	for k, v := range clientSecretAnnotations(sp) {
+		secret.Annotations[k] = v
+	}
+}
+
+// restoreClientSecretAnnotations puts back the annotations recording which ClientSecret the Secret holds when they were removed
+func (r *AzureIdentityTerminatorReconciler) restoreClientSecretAnnotations(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, secret *corev1.Secret) error {
+	status := t.Status.ServicePrincipal
+	if _, ok := secret.Annotations[clientSecretKeyIDAnnotation]; ok || status.ClientSecretKeyID == nil || status.ClientSecretExpiration == nil {
+		return nil
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[clientSecretKeyIDAnnotation] = *status.ClientSecretKeyID
+	secret.Annotations[clientSecretExpirationAnnotation] = status.ClientSecretExpiration.UTC().Format(time.RFC3339)
+	if err := r.Patch(ctx, secret, patch); err != nil {
+		return err
+	}
+
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Restored the ClientSecret annotations of Secret "+secret.Name)
+	return nil
+}
+
+// recoverClientSecretStatus restores the ClientSecret checkpoint from the annotations of the Secret
+// when the status update that should have recorded it did not happen
+func recoverClientSecretStatus(t *terminatorv1alpha1.AzureIdentityTerminator, secret *corev1.Secret) {
+	keyID, ok := secret.Annotations[clientSecretKeyIDAnnotation]
+	if !ok || t.Status.ServicePrincipal.ClientSecretKeyID != nil {
+		return
+	}
+
+	expiration, err := time.Parse(time.RFC3339, secret.Annotations[clientSecretExpirationAnnotation])
+	if err != nil {
+		return
+	}
+
+	t.Status.ServicePrincipal.ClientSecretKeyID = to.StringPtr(keyID)
+	t.Status.ServicePrincipal.ClientSecretExpiration = &v1.Time{Time: expiration}
+}
+
+// clientSecretAnnotations returns the annotations recording which ClientSecret a Secret holds
+func clientSecretAnnotations(sp azuread.ServicePrincipal) map[string]string {
+	return map[string]string{
+		clientSecretKeyIDAnnotation:      sp.ClientSecretKeyID,
+		clientSecretExpirationAnnotation: sp.ClientSecretExpiration.UTC().Format(time.RFC3339),
+	}
+}
+
+// ensureAzureIdentity creates the AzureIdentity, or restores it when it was edited
+func (r *AzureIdentityTerminatorReconciler) ensureAzureIdentity(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	azID, err := r.AzureIdentityManifest(t, aadApp)
+	if err != nil {
+		log.Error(err, "Failed to set owner reference on AzureIdentity", "AzureIdentity.Name", azureIdentityName(t))
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+	}
+
+	existing := &aadpodv1.AzureIdentity{}
+	err = r.Get(ctx, types.NamespacedName{Name: azID.Name, Namespace: azID.Namespace}, existing)
+	if err == nil {
+		if err := r.adopt(ctx, t, existing); err != nil {
+			log.Error(err, "Failed to set owner reference on AzureIdentity", "AzureIdentity.Name", existing.Name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, adoptFailedReason(err), err)
+		}
+
+		if azureIdentityMatches(existing, azID) {
+			return nil
+		}
+
+		log.Info("Restoring AzureIdentity", "AzureIdentity.Name", existing.Name)
+		existing.Spec.Type = azID.Spec.Type
+		existing.Spec.ResourceID = azID.Spec.ResourceID
+		existing.Spec.ClientID = azID.Spec.ClientID
+		existing.Spec.TenantID = azID.Spec.TenantID
+		existing.Spec.ClientPassword = azID.Spec.ClientPassword
+		if err := r.Update(ctx, existing); err != nil {
+			log.Error(err, "Failed to restore AzureIdentity", "AzureIdentity.Name", existing.Name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+		}
+
+		r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Restored the edited spec of AzureIdentity "+existing.Name)
+		return nil
+	}
+
+	if !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get AzureIdentity")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonLookupFailed, err)
+	}
+
+	log.Info("Creating AzureIdentity", "AzureIdentity.Name", azID.Name)
+	if err := r.Create(ctx, azID); err != nil {
+		log.Error(err, "Failed to create AzureIdentity", "AzureIdentity.Name", azID.Name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+	}
+
+	log.Info("Successfully created AzureIdentity", "AzureIdentity.Name", azID.Name)
+	if meta.IsStatusConditionTrue(t.Status.Conditions, terminatorv1alpha1.ConditionIdentityBound) {
+		r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Recreated deleted AzureIdentity "+azID.Name+" for ClientID "+azID.Spec.ClientID)
+	} else {
+		r.recordEvent(t, corev1.EventTypeNormal, EventAzureIdentityCreated, "Created AzureIdentity "+azID.Name+" for ClientID "+azID.Spec.ClientID)
+	}
+	return nil
+}
+
+// azureIdentityMatches reports whether the fields of the AzureIdentity set by the controller are as desired
+func azureIdentityMatches(existing, desired *aadpodv1.AzureIdentity) bool {
+	return existing.Spec.Type == desired.Spec.Type &&
+		existing.Spec.ResourceID == desired.Spec.ResourceID &&
+		existing.Spec.ClientID == desired.Spec.ClientID &&
+		existing.Spec.TenantID == desired.Spec.TenantID &&
+		existing.Spec.ClientPassword == desired.Spec.ClientPassword
+}
+
+// ensureAzureIdentityBinding creates the AzureIdentityBinding, or restores it when it was edited
+func (r *AzureIdentityTerminatorReconciler) ensureAzureIdentityBinding(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	azID, err := r.AzureIdentityManifest(t, aadApp)
+	if err != nil {
+		log.Error(err, "Failed to set owner reference on AzureIdentity", "AzureIdentity.Name", azureIdentityName(t))
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+	}
+
+	azIDBinding, err := r.AzureIdentityBindingManifest(t, azID)
+	if err != nil {
+		log.Error(err, "Failed to set owner reference on AzureIdentityBinding", "AzureIdentityBinding.Name", azureIdentityName(t))
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+	}
+
+	existing := &aadpodv1.AzureIdentityBinding{}
+	err = r.Get(ctx, types.NamespacedName{Name: azIDBinding.Name, Namespace: azIDBinding.Namespace}, existing)
+	if err == nil {
+		if err := r.adopt(ctx, t, existing); err != nil {
+			log.Error(err, "Failed to set owner reference on AzureIdentityBinding", "AzureIdentityBinding.Name", existing.Name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, adoptFailedReason(err), err)
+		}
+
+		if existing.Spec.AzureIdentity != azIDBinding.Spec.AzureIdentity || existing.Spec.Selector != azIDBinding.Spec.Selector {
+			log.Info("Updating AzureIdentityBinding", "AzureIdentityBinding.Name", existing.Name)
+			existing.Spec.AzureIdentity = azIDBinding.Spec.AzureIdentity
+			existing.Spec.Selector = azIDBinding.Spec.Selector
+			if err := r.Update(ctx, existing); err != nil {
+				log.Error(err, "Failed to update AzureIdentityBinding", "AzureIdentityBinding.Name", existing.Name)
+				return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+			}
+
+			if specUpdated(t) {
+				r.recordEvent(t, corev1.EventTypeNormal, EventAzureIdentityBindingUpdated, "Updated AzureIdentityBinding "+existing.Name+" to select pods labelled aadpodidbinding="+existing.Spec.Selector)
+			} else {
+				r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Restored AzureIdentityBinding "+existing.Name+" to select pods labelled aadpodidbinding="+existing.Spec.Selector)
+			}
+		}
+
+		setCondition(t, terminatorv1alpha1.ConditionIdentityBound, v1.ConditionTrue, ReasonCreated, "AzureIdentity and AzureIdentityBinding "+azIDBinding.Name+" have been created")
+		return nil
+	}
+
+	if !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get AzureIdentityBinding")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonLookupFailed, err)
+	}
+
+	log.Info("Creating AzureIdentityBinding", "AzureIdentityBinding.Name", azIDBinding.Name)
+	if err := r.Create(ctx, azIDBinding); err != nil {
+		log.Error(err, "Failed to create AzureIdentityBinding", "AzureIdentityBinding.Name", azIDBinding.Name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+	}
+
+	log.Info("Sucessfully created AzureIdentityBinding", "AzureIdentityBinding.Name", azIDBinding.Name)
+	if meta.IsStatusConditionTrue(t.Status.Conditions, terminatorv1alpha1.ConditionIdentityBound) {
+		r.recordEvent(t, corev1.EventTypeNormal, EventResourceRepaired, "Recreated deleted AzureIdentityBinding "+azIDBinding.Name)
+	} else {
+		r.recordEvent(t, corev1.EventTypeNormal, EventAzureIdentityBindingCreated, "Created AzureIdentityBinding "+azIDBinding.Name+" selecting pods labelled aadpodidbinding="+azIDBinding.Spec.Selector)
+	}
+	setCondition(t, terminatorv1alpha1.ConditionIdentityBound, v1.ConditionTrue, ReasonCreated, "AzureIdentity and AzureIdentityBinding "+azIDBinding.Name+" have been created")
+	return nil
+}
+
+// adopt sets the AzureIdentityTerminator as the controller of an object created before owner references were set,
+// so that its events trigger a reconcile and it is garbage collected with the AzureIdentityTerminator. An object
+// controlled by anything else, such as another AzureIdentityTerminator given the same name, is left untouched.
+func (r *AzureIdentityTerminatorReconciler) adopt(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, obj client.Object) error {
+	if controlledByOther(t, obj) {
+		owner := v1.GetControllerOf(obj)
+		return &conflictError{fmt.Errorf("%s %s is controlled by %s %s", reflect.TypeOf(obj).Elem().Name(), obj.GetName(), owner.Kind, owner.Name)}
+	}
+	if v1.GetControllerOf(obj) != nil {
+		return nil
+	}
+
+	if err := ctrl.SetControllerReference(t, obj, r.Scheme); err != nil {
+		return err
+	}
+
+	r.Log.Info("Setting owner reference", "AzureIdentityTerminator.Name", t.Name, "Object.Name", obj.GetName())
+	return r.Update(ctx, obj)
+}
+
+// controlledByOther reports whether the object has a controller other than the AzureIdentityTerminator
+func controlledByOther(t *terminatorv1alpha1.AzureIdentityTerminator, obj client.Object) bool {
+	owner := v1.GetControllerOf(obj)
+	return owner != nil && owner.UID != t.UID
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// Bounds of the delay before retrying a role assignment whose principal has not replicated yet
+const (
+	minRoleAssignmentBackoff = 5 * time.Second
+	maxRoleAssignmentBackoff = 5 * time.Minute
+)
+
+// roleAssignmentNamespace is used to derive a stable role assignment name for each AzureIdentityTerminator
+var roleAssignmentNamespace = uuid.MustParse("5c3e0f5e-7d2a-4b8e-9a51-3f0b6c1d2e47")
+
+// roleAssignmentName derives the role assignment name from the UID of the AzureIdentityTerminator and the
+// role and scope, so that retrying a role assignment never creates a second one
+func roleAssignmentName(t *terminatorv1alpha1.AzureIdentityTerminator, ra terminatorv1alpha1.RoleAssignmentSpec) string {
+	return uuid.NewSHA1(roleAssignmentNamespace, []byte(string(t.UID)+"/"+ra.Role+"/"+ra.Scope)).String()
+}
+
+// azureRoleAssignment converts a role assignment recorded in the status to the azuread.RoleAssignment it was created from
+func azureRoleAssignment(ra terminatorv1alpha1.RoleAssignment) azuread.RoleAssignment {
+	assignment := azuread.RoleAssignment{Role: ra.Role, Scope: ra.Scope}
+	if ra.Name != nil {
+		assignment.Name = *ra.Name
+	}
+	if ra.ObjectID != nil {
+		assignment.ObjectID = *ra.ObjectID
+	}
+	return assignment
+}
+
+// migrateRoleAssignment moves the single role assignment of terminators created before
+// spec.roleAssignments into the list, as the Reader role over the nodeResourceGroup
+func migrateRoleAssignment(t *terminatorv1alpha1.AzureIdentityTerminator) {
+	legacy := t.Status.RoleAssignment
+	if legacy.ObjectID == nil {
+		return
+	}
+
+	legacy.Role = terminatorv1alpha1.DefaultRole
+	legacy.Scope = t.Spec.NodeResourceGroup
+	t.Status.RoleAssignments = append(t.Status.RoleAssignments, legacy)
+	t.Status.RoleAssignment = terminatorv1alpha1.RoleAssignment{}
+}
+
+// assignedRole returns the role assignment recorded in the status for the role and scope, if any
+func assignedRole(t *terminatorv1alpha1.AzureIdentityTerminator, role string, scope string) *terminatorv1alpha1.RoleAssignment {
+	for i := range t.Status.RoleAssignments {
+		if ra := &t.Status.RoleAssignments[i]; ra.Role == role && ra.Scope == scope {
+			return ra
+		}
+	}
+	return nil
+}
+
+// wantsRole reports whether the spec asks for the role assignment recorded in the status
+func wantsRole(desired []terminatorv1alpha1.RoleAssignmentSpec, ra terminatorv1alpha1.RoleAssignment) bool {
+	for _, spec := range desired {
+		if spec.Role == ra.Role && spec.Scope == ra.Scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureRoleAssignments assigns the Service Principal each role in the spec that the status does not
+// already record, and deletes the role assignments that were removed from the spec. The new role assignments
+// are created first, so a role moved to another scope, such as a new nodeResourceGroup, is never missing.
+func (r *AzureIdentityTerminatorReconciler) ensureRoleAssignments(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	desired := t.Spec.EffectiveRoleAssignments()
+
+	for _, spec := range desired {
+		if assigned := assignedRole(t, spec.Role, spec.Scope); assigned != nil && assigned.ObjectID != nil {
+			continue
+		}
+
+		ra := &azuread.RoleAssignment{Name: roleAssignmentName(t, spec), Role: spec.Role, Scope: spec.Scope}
+		log.Info("Creating role assignment", "roleAssignment.Role", ra.Role, "roleAssignment.Scope", ra.Scope)
+		if err := r.Azure.CreateRoleAssignment(ctx, aadApp, ra); err != nil {
+			log.Error(err, "Failed to create role assignment", "roleAssignment.Role", ra.Role, "roleAssignment.Scope", ra.Scope)
+			return r.failRoleAssignment(ctx, t, aadApp, ra, err)
+		}
+
+		log.Info("Successfully created role assignment", "roleAssignment.ObjectID", ra.ObjectID)
+		t.Status.RoleAssignments = append(t.Status.RoleAssignments, terminatorv1alpha1.RoleAssignment{
+			Name:     to.StringPtr(ra.Name),
+			ObjectID: to.StringPtr(ra.ObjectID),
+			Role:     ra.Role,
+			Scope:    ra.Scope,
+		})
+		aadApp.RoleAssignments = append(aadApp.RoleAssignments, *ra)
+		r.recordEvent(t, corev1.EventTypeNormal, EventRoleAssigned, "Assigned principal "+aadApp.PrincipalID()+" the "+ra.Role+" role over "+ra.Scope)
+		if err := r.updateStatus(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	for _, ra := range append([]terminatorv1alpha1.RoleAssignment(nil), t.Status.RoleAssignments...) {
+		if wantsRole(desired, ra) {
+			continue
+		}
+
+		if err := r.deleteRoleAssignment(ctx, t, aadApp, ra); err != nil {
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionRoleAssigned, ReasonDeleteFailed, err)
+		}
+		if err := r.updateStatus(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	setCondition(t, terminatorv1alpha1.ConditionRoleAssigned, v1.ConditionTrue, ReasonCreated, fmt.Sprintf("%d role assignments have been created", len(desired)))
+	return nil
+}
+
+// failRoleAssignment records why a role assignment could not be created. A principal that has not replicated
+// to Azure Resource Manager yet is retried after a delay that doubles with the time spent waiting, while an
+// authorization failure is not retried until the AzureIdentityTerminator changes.
+func (r *AzureIdentityTerminatorReconciler) failRoleAssignment(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App, ra *azuread.RoleAssignment, err error) error {
+	err = fmt.Errorf("role %s over %s: %w", ra.Role, ra.Scope, err)
+
+	switch {
+	case azuread.IsPrincipalNotFound(err):
+		after := roleAssignmentBackoff(t)
+		r.Log.Info("Principal has not replicated yet, retrying role assignment", "principalID", aadApp.PrincipalID(), "requeueAfter", after)
+		if condition := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned); condition == nil || condition.Reason != ReasonPrincipalNotFound {
+			r.recordEvent(t, corev1.EventTypeNormal, ReasonPrincipalNotFound, "Waiting for principal "+aadApp.PrincipalID()+" to replicate before assigning it roles")
+		}
+
+		// The message is left unchanged between retries so that updating the status does not trigger another reconcile
+		setCondition(t, terminatorv1alpha1.ConditionRoleAssigned, v1.ConditionUnknown, ReasonPrincipalNotFound, "Waiting for principal "+aadApp.PrincipalID()+" to replicate before assigning it the "+ra.Role+" role over "+ra.Scope)
+		if err := r.updateStatus(ctx, t); err != nil {
+			return err
+		}
+		return &requeueError{error: err, after: after}
+
+	case azuread.IsAuthorizationFailed(err):
+		return &terminalError{r.failStep(ctx, t, terminatorv1alpha1.ConditionRoleAssigned, ReasonAuthorizationFailed, err)}
+
+	default:
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionRoleAssigned, ReasonCreateFailed, err)
+	}
+}
+
+// roleAssignmentBackoff returns how long to wait before assigning a role to a principal that has not replicated yet.
+// Waiting as long again as has already been waited doubles the delay on every retry, up to maxRoleAssignmentBackoff.
+func roleAssignmentBackoff(t *terminatorv1alpha1.AzureIdentityTerminator) time.Duration {
+	condition := meta.FindStatusCondition(t.Status.Conditions, terminatorv1alpha1.ConditionRoleAssigned)
+	if condition == nil || condition.Reason != ReasonPrincipalNotFound {
+		return minRoleAssignmentBackoff
+	}
+
+	waited := time.Since(condition.LastTransitionTime.Time)
+	if waited < minRoleAssignmentBackoff {
+		return minRoleAssignmentBackoff
+	}
+	if waited > maxRoleAssignmentBackoff {
+		return maxRoleAssignmentBackoff
+	}
+	return waited
+}
+
+// deleteRoleAssignment deletes a role assignment recorded in the status and removes it from the status.
+// A role assignment that no longer exists counts as deleted.
+func (r *AzureIdentityTerminatorReconciler) deleteRoleAssignment(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App, ra terminatorv1alpha1.RoleAssignment) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	if ra.ObjectID != nil {
+		assignment := azureRoleAssignment(ra)
+		log.Info("Deleting role assignment", "roleAssignment.ObjectID", assignment.ObjectID)
+		if err := r.Azure.DeleteRoleAssignment(ctx, aadApp, &assignment); err != nil && !azuread.IsNotFound(err) {
+			log.Error(err, "Failed to delete role assignment", "roleAssignment.ObjectID", assignment.ObjectID)
+			r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to remove the "+ra.Role+" role over "+ra.Scope+": "+err.Error())
+			return err
+		}
+	}
+
+	assigned := t.Status.RoleAssignments[:0]
+	for _, existing := range t.Status.RoleAssignments {
+		if existing.Role != ra.Role || existing.Scope != ra.Scope {
+			assigned = append(assigned, existing)
+		}
+	}
+	t.Status.RoleAssignments = assigned
+
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Removed the "+ra.Role+" role over "+ra.Scope+" from principal "+aadApp.PrincipalID())
+	return nil
+}
+
+// deleteRoleAssignments deletes every role assignment recorded in the status
+func (r *AzureIdentityTerminatorReconciler) deleteRoleAssignments(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	for _, ra := range append([]terminatorv1alpha1.RoleAssignment(nil), t.Status.RoleAssignments...) {
+		if err := r.deleteRoleAssignment(ctx, t, aadApp, ra); err != nil {
+			return err
+		}
+	}
+	return nil
+}
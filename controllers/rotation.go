@@ -0,0 +1,184 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// clientSecretKey is the key in the Secret that holds the ClientSecret
+const clientSecretKey = "clientSecret"
+
+// RotateClientSecret issues a new ClientSecret once the current one enters its rotation window,
+// updates the Secret in place and removes the previous credential after the grace period.
+// It returns a result that requeues the terminator for its next rotation event.
+func (r *AzureIdentityTerminatorReconciler) RotateClientSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) (ctrl.Result, error) {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	status := &t.Status.ServicePrincipal
+	if status.ObjectID == nil || status.ClientSecretExpiration == nil {
+		return ctrl.Result{}, nil
+	}
+
+	rotateBefore, gracePeriod := t.Spec.ServicePrincipal.RotationWindow()
+	now := time.Now()
+	aadApp := r.AppForTerminator(t)
+
+	// Remove the credential replaced by the last rotation once its grace period has passed
+	if status.PreviousClientSecretKeyID != nil && status.LastRotationTime != nil && !now.Before(status.LastRotationTime.Add(gracePeriod)) {
+		if err := r.removePreviousClientSecret(ctx, t, aadApp, "after the rotation grace period"); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	rotateAt := status.ClientSecretExpiration.Add(-rotateBefore)
+	if now.Before(rotateAt) {
+		setCondition(t, terminatorv1alpha1.ConditionSecretExpiringSoon, v1.ConditionFalse, ReasonSecretValid, "The ClientSecret is rotated at "+rotateAt.UTC().Format(time.RFC3339))
+		if err := r.updateStatus(ctx, t); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: nextRotationEvent(t, now, rotateAt, gracePeriod)}, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: secretName(t), Namespace: t.Namespace}, secret); err != nil {
+		log.Error(err, "Failed to get Secret", "Secret.Name", secretName(t))
+		return ctrl.Result{}, r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonSecretSyncFailed, err)
+	}
+
+	// Only one previous ClientSecret is tracked, so one still inside its grace period, such as after the
+	// rotation window was changed, is removed before it would be replaced and forgotten
+	if status.PreviousClientSecretKeyID != nil {
+		if err := r.removePreviousClientSecret(ctx, t, aadApp, "before the next rotation"); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	setCondition(t, terminatorv1alpha1.ConditionSecretExpiringSoon, v1.ConditionTrue, ReasonRotationDue, "The ClientSecret expires at "+status.ClientSecretExpiration.UTC().Format(time.RFC3339))
+	if rotated, ok := rotatedClientSecret(t, secret); ok {
+		// A rotation whose status update failed already wrote the new ClientSecret to the Secret
+		log.Info("Resuming rotation from the ClientSecret in the Secret", "keyID", rotated.ClientSecretKeyID)
+		aadApp.ServicePrincipal.ClientSecretKeyID = rotated.ClientSecretKeyID
+		aadApp.ServicePrincipal.ClientSecretExpiration = rotated.ClientSecretExpiration
+	} else {
+		log.Info("Rotating ClientSecret", "clientSecretExpiration", status.ClientSecretExpiration)
+		if err := r.Azure.AddPassword(ctx, aadApp); err != nil {
+			log.Error(err, "Failed to add new ClientSecret to Service Principal", "servicePrincipal.ObjectID", *status.ObjectID)
+			setCondition(t, terminatorv1alpha1.ConditionSecretExpiringSoon, v1.ConditionTrue, ReasonRotationFailed, err.Error())
+			r.recordEvent(t, corev1.EventTypeWarning, EventRotationFailed, "Failed to add a new ClientSecret to Service Principal "+*status.ObjectID+": "+err.Error())
+			r.updateStatus(ctx, t)
+			return ctrl.Result{}, err
+		}
+
+		setClientSecret(secret, aadApp.ServicePrincipal)
+		if err := r.Update(ctx, secret); err != nil {
+			log.Error(err, "Failed to update Secret", "Secret.Name", secret.Name)
+
+			// The ClientSecret can never be read back, so don't leave it behind on the Service Principal
+			if removeErr := r.Azure.RemovePassword(ctx, aadApp, aadApp.ServicePrincipal.ClientSecretKeyID); removeErr != nil {
+				log.Error(removeErr, "Failed to remove unused ClientSecret", "keyID", aadApp.ServicePrincipal.ClientSecretKeyID)
+			}
+			return ctrl.Result{}, r.failStep(ctx, t, terminatorv1alpha1.ConditionSecretSynced, ReasonSecretSyncFailed, err)
+		}
+	}
+
+	status.PreviousClientSecretKeyID = status.ClientSecretKeyID
+	status.ClientSecretKeyID = to.StringPtr(aadApp.ServicePrincipal.ClientSecretKeyID)
+	status.ClientSecretExpiration = &v1.Time{Time: aadApp.ServicePrincipal.ClientSecretExpiration.Time}
+	status.LastRotationTime = &v1.Time{Time: now}
+	setCondition(t, terminatorv1alpha1.ConditionSecretSynced, v1.ConditionTrue, ReasonSecretRotated, "Secret "+secret.Name+" holds the current ClientSecret")
+	setCondition(t, terminatorv1alpha1.ConditionSecretExpiringSoon, v1.ConditionFalse, ReasonSecretRotated, "The ClientSecret was rotated at "+now.UTC().Format(time.RFC3339))
+	if err := r.updateStatus(ctx, t); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Successfully rotated ClientSecret", "clientSecretExpiration", status.ClientSecretExpiration)
+	r.recordEvent(t, corev1.EventTypeNormal, EventClientSecretRotated, "Rotated the ClientSecret in Secret "+secret.Name+", the new ClientSecret expires at "+status.ClientSecretExpiration.UTC().Format(time.RFC3339))
+	return ctrl.Result{RequeueAfter: nextRotationEvent(t, now, status.ClientSecretExpiration.Add(-rotateBefore), gracePeriod)}, nil
+}
+
+// removePreviousClientSecret removes the credential replaced by the last rotation from the Service Principal
+// and stops tracking it in the status. The reason completes the event recorded for the removal.
+func (r *AzureIdentityTerminatorReconciler) removePreviousClientSecret(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App, reason string) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	status := &t.Status.ServicePrincipal
+	keyID := *status.PreviousClientSecretKeyID
+
+	log.Info("Removing previous ClientSecret", "keyID", keyID)
+	if err := r.Azure.RemovePassword(ctx, aadApp, keyID); err != nil {
+		log.Error(err, "Failed to remove previous ClientSecret", "keyID", keyID)
+		r.recordEvent(t, corev1.EventTypeWarning, EventRotationFailed, "Failed to remove previous ClientSecret "+keyID+": "+err.Error())
+		return err
+	}
+
+	status.PreviousClientSecretKeyID = nil
+	if err := r.updateStatus(ctx, t); err != nil {
+		return err
+	}
+
+	log.Info("Successfully removed previous ClientSecret")
+	r.recordEvent(t, corev1.EventTypeNormal, EventPreviousSecretRemoved, "Removed previous ClientSecret "+keyID+" "+reason)
+	return nil
+}
+
+// rotatedClientSecret returns the ClientSecret recorded by the annotations of the Secret when it is newer than the
+// one recorded in the status, which happens when the status update of a rotation fails after the Secret was written
+func rotatedClientSecret(t *terminatorv1alpha1.AzureIdentityTerminator, secret *corev1.Secret) (azuread.ServicePrincipal, bool) {
+	status := t.Status.ServicePrincipal
+	keyID, ok := secret.Annotations[clientSecretKeyIDAnnotation]
+	if !ok || len(secret.Data[clientSecretKey]) == 0 || status.ClientSecretKeyID == nil || keyID == *status.ClientSecretKeyID {
+		return azuread.ServicePrincipal{}, false
+	}
+
+	expiration, err := time.Parse(time.RFC3339, secret.Annotations[clientSecretExpirationAnnotation])
+	if err != nil || !expiration.After(status.ClientSecretExpiration.Time) {
+		return azuread.ServicePrincipal{}, false
+	}
+
+	return azuread.ServicePrincipal{
+		ClientSecretKeyID:      keyID,
+		ClientSecretExpiration: date.Time{Time: expiration},
+	}, true
+}
+
+// nextRotationEvent returns the time until either the next rotation or the removal of the previous ClientSecret
+func nextRotationEvent(t *terminatorv1alpha1.AzureIdentityTerminator, now time.Time, rotateAt time.Time, gracePeriod time.Duration) time.Duration {
+	next := rotateAt.Sub(now)
+	status := t.Status.ServicePrincipal
+	if status.PreviousClientSecretKeyID != nil && status.LastRotationTime != nil {
+		if removeIn := status.LastRotationTime.Add(gracePeriod).Sub(now); removeIn < next {
+			next = removeIn
+		}
+	}
+
+	if next < time.Second {
+		next = time.Second
+	}
+
+	return next
+}
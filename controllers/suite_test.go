@@ -17,20 +17,25 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
 	aadpiterminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/azure/fake"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -40,6 +45,21 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var fakeAzure *fake.IdentityProvider
+
+// oidcIssuerURL is the cluster OIDC issuer the test reconciler is configured with
+const oidcIssuerURL = "https://oidc.example.com/issuer"
+
+// azureResyncInterval is short so that the Azure objects are audited within the test timeouts
+const azureResyncInterval = time.Second
+
+// The resource group and region the test reconciler creates user-assigned managed identities in
+const (
+	managedIdentityResourceGroup = "identities"
+	managedIdentityLocation      = "westus2"
+)
+
+var cancelManager context.CancelFunc
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -54,7 +74,10 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			filepath.Join("testdata", "crds"),
+		},
 	}
 
 	cfg, err := testEnv.Start()
@@ -64,16 +87,52 @@ var _ = BeforeSuite(func() {
 	err = aadpiterminatorv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = aadpodv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the AzureIdentityTerminator controller against a fake Azure backend")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	fakeAzure = fake.NewIdentityProvider()
+	err = (&AzureIdentityTerminatorReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("AzureIdentityTerminator"),
+		Scheme:   mgr.GetScheme(),
+		Azure:    fakeAzure,
+		Recorder: mgr.GetEventRecorderFor("azureidentityterminator-controller"),
+
+		OIDCIssuerURL:       oidcIssuerURL,
+		AzureResyncInterval: azureResyncInterval,
+
+		ManagedIdentityResourceGroup: managedIdentityResourceGroup,
+		ManagedIdentityLocation:      managedIdentityLocation,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	var ctx context.Context
+	ctx, cancelManager = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancelManager != nil {
+		cancelManager()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
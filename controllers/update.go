@@ -0,0 +1,94 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// ReasonUpdateFailed is used when a step cannot apply a change to the spec to an existing object
+const ReasonUpdateFailed = "UpdateFailed"
+
+// specUpdated reports whether the spec has changed since it was last fully reconciled, so that a difference
+// between an object and the spec is an update rather than drift to repair
+func specUpdated(t *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	return t.Status.ObservedGeneration != 0 && t.Status.ObservedGeneration != t.Generation
+}
+
+// ensureDisplayName renames the Azure AD Application when the displayName in the spec differs from the one
+// recorded in the status
+func (r *AzureIdentityTerminatorReconciler) ensureDisplayName(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if t.Status.AppRegistration.DisplayName == aadApp.DisplayName {
+		return nil
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	log.Info("Renaming Azure AD Application", "appRegistration.ObjectID", aadApp.ObjectID, "appRegistration.displayName", aadApp.DisplayName)
+	if err := r.Azure.UpdateApplication(ctx, aadApp); err != nil {
+		log.Error(err, "Failed to rename Azure AD Application")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionAppRegistered, ReasonUpdateFailed, err)
+	}
+
+	// Terminators created before the display name was recorded are renamed once to the name they already have
+	if previous := t.Status.AppRegistration.DisplayName; previous != "" {
+		r.recordEvent(t, corev1.EventTypeNormal, EventApplicationUpdated, "Renamed Azure AD Application "+aadApp.ClientID+" from "+previous+" to "+aadApp.DisplayName)
+	}
+	t.Status.AppRegistration.DisplayName = aadApp.DisplayName
+	setCondition(t, terminatorv1alpha1.ConditionAppRegistered, v1.ConditionTrue, ReasonUpdated, "Application "+aadApp.ClientID+" has been registered")
+	return r.updateStatus(ctx, t)
+}
+
+// ensureServicePrincipalTags sets the tags of the Service Principal when the tags in the spec differ from the
+// ones recorded in the status
+func (r *AzureIdentityTerminatorReconciler) ensureServicePrincipalTags(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if equalTags(t.Status.ServicePrincipal.Tags, aadApp.ServicePrincipal.Tags) {
+		return nil
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	log.Info("Updating tags of Service Principal", "servicePrincipal.ObjectID", aadApp.ServicePrincipal.ObjectID, "tags", aadApp.ServicePrincipal.Tags)
+	if err := r.Azure.UpdateServicePrincipal(ctx, aadApp); err != nil {
+		log.Error(err, "Failed to update tags of Service Principal")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionServicePrincipalReady, ReasonUpdateFailed, err)
+	}
+
+	r.recordEvent(t, corev1.EventTypeNormal, EventServicePrincipalUpdated, "Set the tags of Service Principal "+aadApp.ServicePrincipal.ObjectID+" to ["+strings.Join(aadApp.ServicePrincipal.Tags, ", ")+"]")
+	t.Status.ServicePrincipal.Tags = append([]string(nil), aadApp.ServicePrincipal.Tags...)
+	setCondition(t, terminatorv1alpha1.ConditionServicePrincipalReady, v1.ConditionTrue, ReasonUpdated, "Service Principal "+aadApp.ServicePrincipal.ObjectID+" has been created")
+	return r.updateStatus(ctx, t)
+}
+
+// equalTags reports whether two lists hold the same tags in the same order, treating nil and empty alike
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
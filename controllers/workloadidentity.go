@@ -0,0 +1,252 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+const (
+	// Annotations read by the Azure AD Workload Identity webhook from the ServiceAccount of a pod
+	workloadIdentityClientIDAnnotation = "azure.workload.identity/client-id"
+	workloadIdentityTenantIDAnnotation = "azure.workload.identity/tenant-id"
+	// createdByAnnotation marks a ServiceAccount the controller created, rather than annotated, for an AzureIdentityTerminator
+	createdByAnnotation = "azidterminator.io/created-by"
+
+	// defaultTokenAudience is the audience Azure AD expects on federated ServiceAccount tokens
+	defaultTokenAudience = "api://AzureADTokenExchange"
+)
+
+// isWorkloadIdentity reports whether the AzureIdentityTerminator uses Azure AD Workload Identity instead of aad-pod-identity
+func isWorkloadIdentity(t *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	return t.Spec.Mode == terminatorv1alpha1.ModeWorkloadIdentity
+}
+
+// serviceAccountName returns the ServiceAccount trusted by the federated identity credential
+func serviceAccountName(t *terminatorv1alpha1.AzureIdentityTerminator) string {
+	if t.Spec.WorkloadIdentity == nil {
+		return ""
+	}
+	return t.Spec.WorkloadIdentity.ServiceAccountName
+}
+
+// federatedIdentityCredential describes the federated identity credential that trusts the ServiceAccount of the AzureIdentityTerminator
+func (r *AzureIdentityTerminatorReconciler) federatedIdentityCredential(t *terminatorv1alpha1.AzureIdentityTerminator) azuread.FederatedIdentityCredential {
+	fic := azuread.FederatedIdentityCredential{
+		Audiences: []string{defaultTokenAudience},
+		Issuer:    r.OIDCIssuerURL,
+		Name:      "azidterminator-" + string(t.UID),
+		Subject:   "system:serviceaccount:" + t.Namespace + ":" + serviceAccountName(t),
+	}
+
+	if wi := t.Spec.WorkloadIdentity; wi != nil {
+		if wi.Issuer != "" {
+			fic.Issuer = wi.Issuer
+		}
+		if len(wi.Audiences) > 0 {
+			fic.Audiences = wi.Audiences
+		}
+	}
+
+	if t.Status.FederatedIdentityCredential.ObjectID != nil {
+		fic.ObjectID = *t.Status.FederatedIdentityCredential.ObjectID
+	}
+
+	return fic
+}
+
+// ensureFederatedIdentityCredential lets the Application trust tokens of the ServiceAccount. A credential the status
+// already records is updated when the issuer, subject or audiences it trusts have changed, such as when the operator is
+// given a new OIDC issuer URL.
+func (r *AzureIdentityTerminatorReconciler) ensureFederatedIdentityCredential(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	fic := &aadApp.FederatedIdentityCredential
+	if t.Status.FederatedIdentityCredential.ObjectID != nil && fic.Trusts(recordedFederatedIdentityCredential(t)) {
+		return nil
+	}
+
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	if serviceAccountName(t) == "" {
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionFederatedCredentialReady, ReasonCreateFailed, fmt.Errorf("spec.workloadIdentity.serviceAccountName must be set in workloadIdentity mode"))
+	}
+	if fic.Issuer == "" {
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionFederatedCredentialReady, ReasonCreateFailed, fmt.Errorf("no OIDC issuer URL is set in spec.workloadIdentity.issuer or configured for the operator"))
+	}
+
+	if t.Status.FederatedIdentityCredential.ObjectID != nil {
+		log.Info("Updating federated identity credential", "federatedIdentityCredential.ObjectID", fic.ObjectID, "issuer", fic.Issuer, "subject", fic.Subject)
+		if err := r.Azure.UpdateFederatedIdentityCredential(ctx, aadApp); err != nil {
+			log.Error(err, "Failed to update federated identity credential")
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionFederatedCredentialReady, ReasonUpdateFailed, err)
+		}
+
+		recordFederatedIdentityCredential(t, fic)
+		setCondition(t, terminatorv1alpha1.ConditionFederatedCredentialReady, v1.ConditionTrue, ReasonUpdated, "Federated identity credential "+fic.Name+" trusts "+fic.Subject)
+		r.recordEvent(t, corev1.EventTypeNormal, EventFederatedCredentialUpdated, "Updated federated identity credential "+fic.Name+" to trust "+fic.Subject+" issued by "+fic.Issuer)
+		return r.updateStatus(ctx, t)
+	}
+
+	log.Info("Creating federated identity credential", "issuer", fic.Issuer, "subject", fic.Subject)
+	if err := r.Azure.CreateFederatedIdentityCredential(ctx, aadApp); err != nil {
+		log.Error(err, "Failed to create federated identity credential")
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionFederatedCredentialReady, ReasonCreateFailed, err)
+	}
+
+	log.Info("Successfully created federated identity credential", "federatedIdentityCredential.ObjectID", fic.ObjectID)
+	recordFederatedIdentityCredential(t, fic)
+	setCondition(t, terminatorv1alpha1.ConditionFederatedCredentialReady, v1.ConditionTrue, ReasonCreated, "Federated identity credential "+fic.Name+" trusts "+fic.Subject)
+	r.recordEvent(t, corev1.EventTypeNormal, EventFederatedCredentialCreated, "Created federated identity credential "+fic.Name+" trusting "+fic.Subject+" issued by "+fic.Issuer)
+	return r.updateStatus(ctx, t)
+}
+
+// recordedFederatedIdentityCredential returns the federated identity credential recorded in the status
+func recordedFederatedIdentityCredential(t *terminatorv1alpha1.AzureIdentityTerminator) azuread.FederatedIdentityCredential {
+	status := t.Status.FederatedIdentityCredential
+	return azuread.FederatedIdentityCredential{
+		Audiences: status.Audiences,
+		Issuer:    status.Issuer,
+		Name:      to.String(status.Name),
+		ObjectID:  to.String(status.ObjectID),
+		Subject:   status.Subject,
+	}
+}
+
+// recordFederatedIdentityCredential records the federated identity credential and the tokens it trusts in the status
+func recordFederatedIdentityCredential(t *terminatorv1alpha1.AzureIdentityTerminator, fic *azuread.FederatedIdentityCredential) {
+	t.Status.FederatedIdentityCredential = terminatorv1alpha1.FederatedIdentityCredential{
+		Audiences: append([]string(nil), fic.Audiences...),
+		Issuer:    fic.Issuer,
+		Name:      to.StringPtr(fic.Name),
+		ObjectID:  to.StringPtr(fic.ObjectID),
+		Subject:   fic.Subject,
+	}
+}
+
+// ensureServiceAccount creates the ServiceAccount, or annotates an existing one, with the ClientID of the Application
+func (r *AzureIdentityTerminatorReconciler) ensureServiceAccount(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	log := r.Log.WithValues("AzureIdentityTerminator", types.NamespacedName{Name: t.Name, Namespace: t.Namespace})
+	name := serviceAccountName(t)
+	sa := &corev1.ServiceAccount{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: t.Namespace}, sa)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get ServiceAccount", "ServiceAccount.Name", name)
+		return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonLookupFailed, err)
+	}
+
+	if errors.IsNotFound(err) {
+		log.Info("Creating ServiceAccount", "ServiceAccount.Name", name)
+		sa = r.ServiceAccountManifest(t, aadApp)
+		if err := r.Create(ctx, sa); err != nil {
+			log.Error(err, "Failed to create ServiceAccount", "ServiceAccount.Name", name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+		}
+		r.recordEvent(t, corev1.EventTypeNormal, EventServiceAccountAnnotated, "Created ServiceAccount "+name+" for ClientID "+aadApp.ClientID)
+	} else if sa.Annotations[workloadIdentityClientIDAnnotation] != aadApp.ClientID || sa.Annotations[workloadIdentityTenantIDAnnotation] != aadApp.TenantID {
+		log.Info("Annotating ServiceAccount", "ServiceAccount.Name", name)
+		patch := client.MergeFrom(sa.DeepCopy())
+		if sa.Annotations == nil {
+			sa.Annotations = map[string]string{}
+		}
+		sa.Annotations[workloadIdentityClientIDAnnotation] = aadApp.ClientID
+		sa.Annotations[workloadIdentityTenantIDAnnotation] = aadApp.TenantID
+		if err := r.Patch(ctx, sa, patch); err != nil {
+			log.Error(err, "Failed to annotate ServiceAccount", "ServiceAccount.Name", name)
+			return r.failStep(ctx, t, terminatorv1alpha1.ConditionIdentityBound, ReasonCreateFailed, err)
+		}
+		r.recordEvent(t, corev1.EventTypeNormal, EventServiceAccountAnnotated, "Annotated ServiceAccount "+name+" with ClientID "+aadApp.ClientID)
+	}
+
+	t.Status.ServiceAccount = name
+	setCondition(t, terminatorv1alpha1.ConditionIdentityBound, v1.ConditionTrue, ReasonCreated, "ServiceAccount "+name+" has been annotated with ClientID "+aadApp.ClientID)
+	return nil
+}
+
+// deleteServiceAccount deletes the ServiceAccount if it was created for the AzureIdentityTerminator,
+// otherwise it only removes the annotations that were added to it
+func (r *AzureIdentityTerminatorReconciler) deleteServiceAccount(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator, aadApp *azuread.App) error {
+	if t.Status.ServiceAccount == "" {
+		return nil
+	}
+
+	sa := &corev1.ServiceAccount{}
+	err := r.Get(ctx, types.NamespacedName{Name: t.Status.ServiceAccount, Namespace: t.Namespace}, sa)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			t.Status.ServiceAccount = ""
+			return nil
+		}
+		return err
+	}
+
+	if sa.Annotations[createdByAnnotation] == t.Name {
+		if err := r.Delete(ctx, sa); err != nil && !errors.IsNotFound(err) {
+			r.Log.Error(err, "Failed to delete ServiceAccount", "ServiceAccount.Name", sa.Name)
+			r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to delete ServiceAccount "+sa.Name+": "+err.Error())
+			return err
+		}
+
+		r.Log.Info("Successfully deleted ServiceAccount", "ServiceAccount.Name", sa.Name)
+		r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Deleted ServiceAccount "+sa.Name)
+		t.Status.ServiceAccount = ""
+		return nil
+	}
+
+	patch := client.MergeFrom(sa.DeepCopy())
+	delete(sa.Annotations, workloadIdentityClientIDAnnotation)
+	delete(sa.Annotations, workloadIdentityTenantIDAnnotation)
+	if err := r.Patch(ctx, sa, patch); err != nil {
+		r.Log.Error(err, "Failed to remove annotations from ServiceAccount", "ServiceAccount.Name", sa.Name)
+		r.recordEvent(t, corev1.EventTypeWarning, EventDeleteFailed, "Failed to remove the workload identity annotations from ServiceAccount "+sa.Name+": "+err.Error())
+		return err
+	}
+
+	r.Log.Info("Successfully removed annotations from ServiceAccount", "ServiceAccount.Name", sa.Name)
+	r.recordEvent(t, corev1.EventTypeNormal, EventResourceDeleted, "Removed the workload identity annotations from ServiceAccount "+sa.Name)
+	t.Status.ServiceAccount = ""
+	return nil
+}
+
+// ServiceAccountManifest creates the ServiceAccount manifest used in workloadIdentity mode
+func (r *AzureIdentityTerminatorReconciler) ServiceAccountManifest(t *terminatorv1alpha1.AzureIdentityTerminator, app *azuread.App) *corev1.ServiceAccount {
+	sa := &corev1.ServiceAccount{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "ServiceAccount",
+			APIVersion: "v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      serviceAccountName(t),
+			Namespace: t.Namespace,
+			Annotations: map[string]string{
+				createdByAnnotation:                t.Name,
+				workloadIdentityClientIDAnnotation: app.ClientID,
+				workloadIdentityTenantIDAnnotation: app.TenantID,
+			},
+		},
+	}
+
+	return sa
+}
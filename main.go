@@ -26,14 +26,21 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	aadpodv1 "github.com/tonedefdev/aad-pod-identity/pkg/apis/aadpodidentity/v1"
 	aadpiterminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
 	"github.com/tonedefdev/azure-identity-terminator/controllers"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/iam"
+	"github.com/tonedefdev/azure-identity-terminator/webhooks"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -50,14 +57,8 @@ func init() {
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+	var configFlags config.Flags
+	configFlags.BindFlags(flag.CommandLine)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,27 +67,86 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "ccc00a1c.k8s.io",
-	})
+	cfg, err := configFlags.Load()
+	if err != nil {
+		setupLog.Error(err, "unable to load the configuration")
+		os.Exit(1)
+	}
+
+	// Every invalid setting is reported at once, rather than one per restart
+	allErrs := cfg.Validate()
+	allErrs = append(allErrs, iam.ValidateCredentials(cfg.Azure, field.NewPath("azure"))...)
+	allErrs = append(allErrs, azuread.ValidateOperationTimeouts(cfg.Azure, field.NewPath("azure"))...)
+	if len(allErrs) > 0 {
+		setupLog.Error(allErrs.ToAggregate(), "invalid configuration")
+		os.Exit(1)
+	}
+
+	environment, err := cfg.Azure.Environment()
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	options, err := ctrl.Options{Scheme: scheme}.AndFrom(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to apply the configuration")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// The reconciles share one token per Azure API, refreshed by the manager while it is the leader
+	credentials, err := iam.NewCredentialProvider(cfg.Azure, ctrl.Log.WithName("credentials"))
+	if err != nil {
+		setupLog.Error(err, "unable to set up credentials")
+		os.Exit(1)
+	}
+	if err := mgr.Add(credentials); err != nil {
+		setupLog.Error(err, "unable to set up token refresh")
+		os.Exit(1)
+	}
+	metrics.Registry.MustRegister(credentials)
+
 	if err = (&controllers.AzureIdentityTerminatorReconciler{
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("AzureIdentityTerminator"),
 		Scheme: mgr.GetScheme(),
+		Azure: azuread.Provider{
+			Credentials:       credentials,
+			Environment:       environment,
+			TenantID:          cfg.Azure.TenantID,
+			SubscriptionID:    cfg.Azure.SubscriptionID,
+			Timeout:           cfg.Azure.Timeout.Duration,
+			OperationTimeouts: cfg.Azure.OperationTimeoutDurations(),
+		},
+		SubscriptionID: cfg.Azure.SubscriptionID,
+		Recorder:       mgr.GetEventRecorderFor("azureidentityterminator-controller"),
+
+		OIDCIssuerURL:                cfg.Azure.OIDCIssuerURL,
+		AzureResyncInterval:          cfg.Azure.ResyncInterval.Duration,
+		ManagedIdentityResourceGroup: cfg.Azure.ManagedIdentityResourceGroup,
+		ManagedIdentityLocation:      cfg.Azure.ManagedIdentityLocation,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AzureIdentityTerminator")
 		os.Exit(1)
 	}
+	if cfg.Admission.Enabled {
+		mgr.GetWebhookServer().Register(webhooks.DefaultPath, &webhook.Admission{Handler: &webhooks.TerminatorDefaulter{
+			Client:   mgr.GetClient(),
+			Defaults: webhooks.Defaults(cfg.Defaults),
+		}})
+		mgr.GetWebhookServer().Register(webhooks.ValidatePath, &webhook.Admission{Handler: &webhooks.TerminatorValidator{
+			MinClientSecretDuration: cfg.Admission.MinClientSecretDuration.Duration,
+			MaxClientSecretDuration: cfg.Admission.MaxClientSecretDuration.Duration,
+			Client:                  mgr.GetClient(),
+			SubscriptionID:          cfg.Azure.SubscriptionID,
+		}})
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
@@ -3,194 +3,581 @@ package azuread
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"
-	"github.com/Azure/azure-sdk-for-go/services/graphrbac/1.6/graphrbac"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/google/uuid"
-	iam "github.com/tonedefdev/azure-identity-terminator/pkg/iam"
-	config "github.com/tonedefdev/azure-identity-terminator/pkg/internal"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
 )
 
 // App struct defines an Azure AD Application and its permissions
 type App struct {
-	ClientID         string
-	DisplayName      string
-	ObjectID         string
-	TenantID         string
-	RoleAssignment   RoleAssignment
+	ClientID                    string
+	DisplayName                 string
+	FederatedIdentityCredential FederatedIdentityCredential
+	// ManagedIdentity is the user-assigned managed identity used instead of the Application when it has a Name
+	ManagedIdentity ManagedIdentity
+	ObjectID        string
+	// OwnerID uniquely identifies the AzureIdentityTerminator the Application is registered for
+	OwnerID  string
+	TenantID string
+	// RoleAssignments are the roles assigned to the service principal
+	RoleAssignments  []RoleAssignment
 	ServicePrincipal ServicePrincipal
 }
 
+// FederatedIdentityCredential lets the Application trust tokens issued to Subject by Issuer
+type FederatedIdentityCredential struct {
+	Audiences []string
+	Issuer    string
+	Name      string
+	ObjectID  string
+	Subject   string
+}
+
+// RoleAssignment grants the service principal a role over a scope
 type RoleAssignment struct {
-	Name              string
-	NodeResourceGroup string
-	ObjectID          string
+	Name     string
+	ObjectID string
+	// Role is the name or ID of the role definition
+	Role string
+	// Scope is the name of a resource group in the subscription, or the full ID of a resource or subscription
+	Scope string
 }
 
 type ServicePrincipal struct {
 	ClientSecret           string
 	ClientSecretExpiration date.Time
+	ClientSecretKeyID      string
 	Duration               string
 	ObjectID               string
-	Tags                   []string
+	// PasswordKeyIDs are the key IDs of every ClientSecret found on the service principal by GetServicePrincipal
+	PasswordKeyIDs []string
+	Tags           []string
 }
 
-// Adds the provided SPN to the 'Reader' role for the AKS cluster node resource group
-func createRoleAssignment(aadApp *App) error {
-	ctx := context.Background()
-	sub := config.SubscriptionID()
-	reader := "/subscriptions/" + sub + "/providers/Microsoft.Authorization/roleDefinitions/acdd72a7-3385-48ef-bd42-f606fba81ae7"
-	rg := "/subscriptions/" + sub + "/resourceGroups/" + aadApp.RoleAssignment.NodeResourceGroup
+// Assigns the provided SPN the role definition over the scope of the role assignment
+func (p Provider) createRoleAssignment(ctx context.Context, aadApp *App, ra *RoleAssignment, roleDefinitionID string) error {
+	ctx = withOperation(ctx, "CreateRoleAssignment")
+
+	roleAssignmentsClient, err := p.roleAssignmentsClient()
+	if err != nil {
+		return err
+	}
 
-	roleAssignmentsClient, _ := getRoleAssignmentsClient()
 	create, err := roleAssignmentsClient.Create(
 		ctx,
-		rg,
-		uuid.New().String(),
+		ScopeID(p.SubscriptionID, ra.Scope),
+		roleAssignmentName(ra),
 		authorization.RoleAssignmentCreateParameters{
 			Properties: &authorization.RoleAssignmentProperties{
-				PrincipalID:      to.StringPtr(aadApp.ServicePrincipal.ObjectID),
-				RoleDefinitionID: to.StringPtr(reader),
+				PrincipalID:      to.StringPtr(aadApp.PrincipalID()),
+				RoleDefinitionID: to.StringPtr(roleDefinitionID),
 			},
 		})
 
-	aadApp.RoleAssignment.Name = *create.Name
-	aadApp.RoleAssignment.ObjectID = *create.ID
+	if err != nil {
+		return err
+	}
 
-	return err
+	ra.Name = *create.Name
+	ra.ObjectID = *create.ID
+	return nil
 }
 
-func generateRandomSecret() string {
-	randomPassword := uuid.New()
-	return randomPassword.String()
+// roleAssignmentName returns the role assignment's name, or a new one when none has been chosen.
+// Reusing the same name makes creating the role assignment again a no-op.
+func roleAssignmentName(ra *RoleAssignment) string {
+	if ra.Name != "" {
+		return ra.Name
+	}
+	return uuid.New().String()
 }
 
-func getApplicationsClient() graphrbac.ApplicationsClient {
-	appClient := graphrbac.NewApplicationsClient(config.TenantID())
-	a, _ := iam.GetGraphAuthorizer()
-	appClient.Authorizer = a
-	appClient.AddToUserAgent(config.UserAgent())
-	return appClient
+// ScopeID returns the full ID of a role assignment scope. A scope that is not already
+// an ID is the name of a resource group in the subscription the controller manages.
+func ScopeID(subscriptionID string, scope string) string {
+	if strings.HasPrefix(scope, "/") {
+		return scope
+	}
+	return "/subscriptions/" + subscriptionID + "/resourceGroups/" + scope
 }
 
-func getRoleAssignmentsClient() (authorization.RoleAssignmentsClient, error) {
-	roleClient := authorization.NewRoleAssignmentsClient(config.SubscriptionID())
-	a, _ := iam.GetResourceManagementAuthorizer()
+// roleDefinitionID returns the full ID of the role definition with the given name or ID
+func (p Provider) roleDefinitionID(ctx context.Context, role string, scope string) (string, error) {
+	if strings.HasPrefix(role, "/") {
+		return role, nil
+	}
+	if _, err := uuid.Parse(role); err == nil {
+		return "/subscriptions/" + p.SubscriptionID + "/providers/Microsoft.Authorization/roleDefinitions/" + role, nil
+	}
+
+	roleDefinitionsClient, err := p.roleDefinitionsClient()
+	if err != nil {
+		return "", err
+	}
+
+	definitions, err := roleDefinitionsClient.List(ctx, scope, "roleName eq "+odataString(role))
+	if err != nil {
+		return "", err
+	}
+	for _, definition := range definitions.Values() {
+		if definition.ID != nil {
+			return *definition.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("role definition %q not found", role)
+}
+
+// ownerTag is recorded as a tag on each Application so it can be found again by its OwnerID
+func ownerTag(ownerID string) string {
+	return "azidterminator.io/owner:" + ownerID
+}
+
+// odataString quotes a value for use in an OData filter
+func odataString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// graphClient returns a GraphClient for the cloud of the Provider, authorized by its Credentials
+func (p Provider) graphClient() (GraphClient, error) {
+	graphClient := NewGraphClient(config.MicrosoftGraphEndpoint(p.Environment))
+	a, err := p.graphAuthorizer()
+	if err != nil {
+		return graphClient, err
+	}
+	graphClient.Authorizer = a
+	graphClient.AddToUserAgent(config.UserAgent())
+	return graphClient, nil
+}
+
+// roleDefinitionsClient returns a RoleDefinitionsClient for the subscription of the Provider, authorized by its Credentials
+func (p Provider) roleDefinitionsClient() (authorization.RoleDefinitionsClient, error) {
+	roleClient := authorization.NewRoleDefinitionsClientWithBaseURI(p.Environment.ResourceManagerEndpoint, p.SubscriptionID)
+	a, err := p.resourceManagementAuthorizer()
+	if err != nil {
+		return roleClient, err
+	}
 	roleClient.Authorizer = a
 	roleClient.AddToUserAgent(config.UserAgent())
+	roleClient.Sender = instrumentedSender(apiARM)
 	return roleClient, nil
 }
 
-func getServicePrincipalClient() graphrbac.ServicePrincipalsClient {
-	spnClient := graphrbac.NewServicePrincipalsClient(config.TenantID())
-	a, _ := iam.GetGraphAuthorizer()
-	spnClient.Authorizer = a
-	spnClient.AddToUserAgent(config.UserAgent())
-	return spnClient
+// roleAssignmentsClient returns a RoleAssignmentsClient for the subscription of the Provider, authorized by its Credentials
+func (p Provider) roleAssignmentsClient() (authorization.RoleAssignmentsClient, error) {
+	roleClient := authorization.NewRoleAssignmentsClientWithBaseURI(p.Environment.ResourceManagerEndpoint, p.SubscriptionID)
+	a, err := p.resourceManagementAuthorizer()
+	if err != nil {
+		return roleClient, err
+	}
+	roleClient.Authorizer = a
+	roleClient.AddToUserAgent(config.UserAgent())
+	roleClient.Sender = instrumentedSender(apiARM)
+	return roleClient, nil
 }
 
 // CreateAzureADApp creates an Azure AD Application
-func (aadApp *App) CreateAzureADApp() (graphrbac.Application, error) {
-	ctx := context.Background()
-	appClient := getApplicationsClient()
+func (aadApp *App) CreateAzureADApp(ctx context.Context, p Provider) (GraphApplication, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return GraphApplication{}, err
+	}
 
-	appCreateParam := graphrbac.ApplicationCreateParameters{
-		DisplayName:             to.StringPtr(aadApp.DisplayName),
-		AvailableToOtherTenants: to.BoolPtr(false),
+	appCreateParam := GraphApplication{
+		DisplayName:    to.StringPtr(aadApp.DisplayName),
+		SignInAudience: to.StringPtr("AzureADMyOrg"),
+		Tags:           &[]string{ownerTag(aadApp.OwnerID)},
 	}
 
-	appReg, err := appClient.Create(ctx, appCreateParam)
+	appReg, err := graphClient.CreateApplication(ctx, appCreateParam)
 	if err != nil {
 		return appReg, err
 	}
 
 	aadApp.ClientID = *appReg.AppID
-	aadApp.ObjectID = *appReg.ObjectID
-	aadApp.TenantID = config.TenantID()
+	aadApp.ObjectID = *appReg.ID
+	aadApp.TenantID = p.TenantID
 	return appReg, err
 }
 
-// CreateServicePrincipal generates a service princiapl for an AzureIdentityTerminator resource
-func (aadApp *App) CreateServicePrincipal() (graphrbac.ServicePrincipal, error) {
-	ctx := context.Background()
-	spnClient := getServicePrincipalClient()
-	secret := generateRandomSecret()
+// CreateServicePrincipal generates a service princiapl for an AzureIdentityTerminator resource.
+// The ClientSecret is added separately with AddPassword.
+func (aadApp *App) CreateServicePrincipal(ctx context.Context, p Provider) (GraphServicePrincipal, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return GraphServicePrincipal{}, err
+	}
 
-	duration, err := time.ParseDuration(aadApp.ServicePrincipal.Duration)
+	spnCreateParam := GraphServicePrincipal{
+		AppID: to.StringPtr(aadApp.ClientID),
+		Tags:  &aadApp.ServicePrincipal.Tags,
+	}
+
+	spnCreate, err := graphClient.CreateServicePrincipal(ctx, spnCreateParam)
 	if err != nil {
-		// TODO: Implement error handling
+		return spnCreate, err
 	}
 
-	now := &date.Time{
-		Time: time.Now(),
+	aadApp.ServicePrincipal.ObjectID = *spnCreate.ID
+	return spnCreate, err
+}
+
+// UpdateAzureADApp sets the display name of the Azure AD Application to the App's DisplayName
+func (aadApp *App) UpdateAzureADApp(ctx context.Context, p Provider) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
 	}
-	expiration := &date.Time{
-		Time: time.Now().Add(duration),
+	return graphClient.UpdateApplication(ctx, aadApp.ObjectID, GraphApplication{DisplayName: to.StringPtr(aadApp.DisplayName)})
+}
+
+// UpdateServicePrincipal sets the tags of the service principal to the App's tags
+func (aadApp *App) UpdateServicePrincipal(ctx context.Context, p Provider) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
 	}
 
-	var clientSecret = []graphrbac.PasswordCredential{}
+	// An empty list clears the tags, where a nil one would leave them unchanged
+	tags := append([]string{}, aadApp.ServicePrincipal.Tags...)
+	return graphClient.UpdateServicePrincipal(ctx, aadApp.ServicePrincipal.ObjectID, GraphServicePrincipal{Tags: &tags})
+}
 
-	newClientSecret := graphrbac.PasswordCredential{
-		StartDate: now,
-		EndDate:   expiration,
-		Value:     to.StringPtr(secret),
+// FindAzureADApp looks up the Azure AD Application previously registered for the App's OwnerID.
+// It returns false when no such Application exists.
+func (aadApp *App) FindAzureADApp(ctx context.Context, p Provider) (bool, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return false, err
+	}
+
+	apps, err := graphClient.ListApplications(ctx, "tags/any(t:t eq "+odataString(ownerTag(aadApp.OwnerID))+")")
+	if err != nil {
+		return false, err
+	}
+
+	if len(apps) == 0 {
+		return false, nil
 	}
 
-	clientSecret = append(clientSecret, newClientSecret)
+	aadApp.ClientID = *apps[0].AppID
+	aadApp.ObjectID = *apps[0].ID
+	aadApp.TenantID = p.TenantID
+	return true, nil
+}
 
-	spnCreateParam := graphrbac.ServicePrincipalCreateParameters{
-		AppID:               to.StringPtr(aadApp.ClientID),
-		PasswordCredentials: &clientSecret,
-		Tags:                &aadApp.ServicePrincipal.Tags,
+// FindServicePrincipal looks up the service principal previously created for the App's ClientID.
+// It returns false when no such service principal exists.
+func (aadApp *App) FindServicePrincipal(ctx context.Context, p Provider) (bool, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return false, err
 	}
 
-	spnCreate, err := spnClient.Create(ctx, spnCreateParam)
+	spns, err := graphClient.ListServicePrincipals(ctx, "appId eq "+odataString(aadApp.ClientID))
 	if err != nil {
-		return spnCreate, err
+		return false, err
+	}
+
+	if len(spns) == 0 {
+		return false, nil
+	}
+
+	aadApp.ServicePrincipal.ObjectID = *spns[0].ID
+	return true, nil
+}
+
+// GetAzureADApp reports whether the Azure AD application with the App's ObjectID still exists
+func (aadApp *App) GetAzureADApp(ctx context.Context, p Provider) (bool, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return false, err
 	}
 
-	aadApp.ServicePrincipal.ClientSecret = secret
-	aadApp.ServicePrincipal.ClientSecretExpiration = *expiration
-	aadApp.ServicePrincipal.ObjectID = *spnCreate.ObjectID
+	_, err = graphClient.GetApplication(ctx, aadApp.ObjectID)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetServicePrincipal reports whether the service principal with the App's ObjectID still exists
+// and records the key IDs of its client secrets
+func (aadApp *App) GetServicePrincipal(ctx context.Context, p Provider) (bool, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return false, err
+	}
 
-	// Loop through multiple times to avoid crashing when the Service Principal can't be initially found
-	for {
-		err = createRoleAssignment(aadApp)
-		if err == nil {
-			break
-		} else {
-			fmt.Println(err)
+	sp, err := graphClient.GetServicePrincipal(ctx, aadApp.ServicePrincipal.ObjectID)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	aadApp.ServicePrincipal.PasswordKeyIDs = nil
+	if sp.PasswordCredentials != nil {
+		for _, credential := range *sp.PasswordCredentials {
+			if credential.KeyID != nil {
+				aadApp.ServicePrincipal.PasswordKeyIDs = append(aadApp.ServicePrincipal.PasswordKeyIDs, *credential.KeyID)
+			}
 		}
 	}
+	return true, nil
+}
+
+// CreateFederatedIdentityCredential adds the federated identity credential to the Azure AD Application.
+// A credential that already exists with the same name is adopted instead, and updated when it trusts other tokens.
+func (aadApp *App) CreateFederatedIdentityCredential(ctx context.Context, p Provider) (GraphFederatedIdentityCredential, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return GraphFederatedIdentityCredential{}, err
+	}
+	return createFederatedIdentityCredential(ctx, graphClient, aadApp.ObjectID, &aadApp.FederatedIdentityCredential)
+}
 
-	return spnCreate, err
+// createFederatedIdentityCredential adds fic to the application with the given object ID unless one with its
+// name exists, in which case that credential is updated to trust the issuer, subject and audiences of fic
+func createFederatedIdentityCredential(ctx context.Context, graphClient GraphClient, objectID string, fic *FederatedIdentityCredential) (GraphFederatedIdentityCredential, error) {
+	existing, err := graphClient.ListFederatedIdentityCredentials(ctx, objectID)
+	if err != nil {
+		return GraphFederatedIdentityCredential{}, err
+	}
+
+	for _, credential := range existing {
+		if credential.Name == nil || *credential.Name != fic.Name {
+			continue
+		}
+
+		fic.ObjectID = *credential.ID
+		if fic.Trusts(federatedIdentityCredential(credential)) {
+			return credential, nil
+		}
+
+		trusted := fic.trusted()
+		if err := graphClient.UpdateFederatedIdentityCredential(ctx, objectID, fic.ObjectID, trusted); err != nil {
+			return credential, err
+		}
+		trusted.ID = credential.ID
+		trusted.Name = credential.Name
+		return trusted, nil
+	}
+
+	create := fic.trusted()
+	create.Name = to.StringPtr(fic.Name)
+	credential, err := graphClient.CreateFederatedIdentityCredential(ctx, objectID, create)
+	if err != nil {
+		return credential, err
+	}
+
+	fic.ObjectID = *credential.ID
+	return credential, err
+}
+
+// GetFederatedIdentityCredential reports whether the federated identity credential with the ObjectID of fic still
+// exists on the Application and records the issuer, subject and audiences it trusts onto fic
+func (aadApp *App) GetFederatedIdentityCredential(ctx context.Context, p Provider, fic *FederatedIdentityCredential) (bool, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return false, err
+	}
+
+	credential, err := graphClient.GetFederatedIdentityCredential(ctx, aadApp.ObjectID, fic.ObjectID)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	found := federatedIdentityCredential(credential)
+	fic.Issuer, fic.Subject, fic.Audiences = found.Issuer, found.Subject, found.Audiences
+	return true, nil
+}
+
+// UpdateFederatedIdentityCredential sets the issuer, subject and audiences of the federated identity credential
+// with the App's ObjectID to those of the App
+func (aadApp *App) UpdateFederatedIdentityCredential(ctx context.Context, p Provider) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
+	}
+
+	fic := aadApp.FederatedIdentityCredential
+	return graphClient.UpdateFederatedIdentityCredential(ctx, aadApp.ObjectID, fic.ObjectID, fic.trusted())
+}
+
+// Trusts reports whether the credential trusts the same tokens as other, that is whether they have the same issuer,
+// subject and audiences
+func (fic FederatedIdentityCredential) Trusts(other FederatedIdentityCredential) bool {
+	if fic.Issuer != other.Issuer || fic.Subject != other.Subject || len(fic.Audiences) != len(other.Audiences) {
+		return false
+	}
+
+	audiences := map[string]bool{}
+	for _, audience := range fic.Audiences {
+		audiences[audience] = true
+	}
+	for _, audience := range other.Audiences {
+		if !audiences[audience] {
+			return false
+		}
+	}
+	return true
+}
+
+// trusted returns the Microsoft Graph properties of the tokens the credential trusts
+func (fic FederatedIdentityCredential) trusted() GraphFederatedIdentityCredential {
+	audiences := append([]string(nil), fic.Audiences...)
+	return GraphFederatedIdentityCredential{
+		Audiences: &audiences,
+		Issuer:    to.StringPtr(fic.Issuer),
+		Subject:   to.StringPtr(fic.Subject),
+	}
+}
+
+// federatedIdentityCredential converts a Microsoft Graph federatedIdentityCredential
+func federatedIdentityCredential(credential GraphFederatedIdentityCredential) FederatedIdentityCredential {
+	fic := FederatedIdentityCredential{
+		Issuer:  to.String(credential.Issuer),
+		Name:    to.String(credential.Name),
+		Subject: to.String(credential.Subject),
+	}
+	if credential.ID != nil {
+		fic.ObjectID = *credential.ID
+	}
+	if credential.Audiences != nil {
+		fic.Audiences = *credential.Audiences
+	}
+	return fic
+}
+
+// CreateRoleAssignment assigns the service principal the role of the role assignment over its scope.
+// It fails with an error IsPrincipalNotFound recognises while the principal has not replicated yet.
+func (aadApp *App) CreateRoleAssignment(ctx context.Context, p Provider, ra *RoleAssignment) error {
+	roleDefinition, err := p.roleDefinitionID(withOperation(ctx, "GetRoleDefinition"), ra.Role, ScopeID(p.SubscriptionID, ra.Scope))
+	if err != nil {
+		return err
+	}
+
+	// A new principal takes a while to replicate to Azure Resource Manager, the caller retries until it has
+	err = p.createRoleAssignment(ctx, aadApp, ra, roleDefinition)
+	if IsPrincipalNotFound(err) {
+		roleAssignmentRetries.Inc()
+	}
+	return err
+}
+
+// AddPassword adds a new client secret to the service principal while keeping its existing credentials
+func (aadApp *App) AddPassword(ctx context.Context, p Provider) (GraphPasswordCredential, error) {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return GraphPasswordCredential{}, err
+	}
+
+	duration, err := time.ParseDuration(aadApp.ServicePrincipal.Duration)
+	if err != nil {
+		return GraphPasswordCredential{}, fmt.Errorf("invalid client secret duration %q: %w", aadApp.ServicePrincipal.Duration, err)
+	}
+
+	now := time.Now()
+	newClientSecret, err := graphClient.AddPassword(ctx, aadApp.ServicePrincipal.ObjectID, GraphPasswordCredential{
+		DisplayName:   to.StringPtr("azure-identity-terminator"),
+		StartDateTime: &date.Time{Time: now},
+		EndDateTime:   &date.Time{Time: now.Add(duration)},
+	})
+	if err != nil {
+		return newClientSecret, err
+	}
+
+	aadApp.ServicePrincipal.ClientSecret = *newClientSecret.SecretText
+	aadApp.ServicePrincipal.ClientSecretExpiration = *newClientSecret.EndDateTime
+	aadApp.ServicePrincipal.ClientSecretKeyID = *newClientSecret.KeyID
+	return newClientSecret, err
+}
+
+// RemovePassword removes the client secret with the given key ID from the service principal
+func (aadApp *App) RemovePassword(ctx context.Context, p Provider, keyID string) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
+	}
+
+	return graphClient.RemovePassword(ctx, aadApp.ServicePrincipal.ObjectID, keyID)
+}
+
+// DisableServicePrincipal stops the service principal from signing in and removes every client secret from it, along
+// with the federated identity credential of the application. The application is kept so that it can be restored.
+func (aadApp *App) DisableServicePrincipal(ctx context.Context, p Provider) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
+	}
+
+	err = graphClient.UpdateServicePrincipal(ctx, aadApp.ServicePrincipal.ObjectID, GraphServicePrincipal{AccountEnabled: to.BoolPtr(false)})
+	if err != nil {
+		return err
+	}
+
+	if _, err := aadApp.GetServicePrincipal(ctx, p); err != nil {
+		return err
+	}
+	for _, keyID := range aadApp.ServicePrincipal.PasswordKeyIDs {
+		if err := graphClient.RemovePassword(ctx, aadApp.ServicePrincipal.ObjectID, keyID); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	aadApp.ServicePrincipal.PasswordKeyIDs = nil
+
+	if fic := aadApp.FederatedIdentityCredential; fic.ObjectID != "" {
+		if err := graphClient.DeleteFederatedIdentityCredential(ctx, aadApp.ObjectID, fic.ObjectID); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 // DeleteAzureApp deletes the requested Azure AD application
-func (aadApp *App) DeleteAzureApp() (autorest.Response, error) {
-	ctx := context.Background()
-	appClient := getApplicationsClient()
+func (aadApp *App) DeleteAzureApp(ctx context.Context, p Provider) error {
+	graphClient, err := p.graphClient()
+	if err != nil {
+		return err
+	}
 
-	appDelete, err := appClient.Delete(ctx, aadApp.ObjectID)
+	return graphClient.DeleteApplication(ctx, aadApp.ObjectID)
+}
+
+// GetRoleAssignment reports whether the role assignment still exists
+func (aadApp *App) GetRoleAssignment(ctx context.Context, p Provider, ra *RoleAssignment) (bool, error) {
+	ctx = withOperation(ctx, "GetRoleAssignment")
+	roleClient, err := p.roleAssignmentsClient()
 	if err != nil {
-		return appDelete, err
+		return false, err
 	}
 
-	return appDelete, err
+	_, err = roleClient.GetByID(ctx, ra.ObjectID)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-func (aadApp *App) DeleteRoleAssignment() (authorization.RoleAssignment, error) {
-	ctx := context.Background()
-	roleClient, err := getRoleAssignmentsClient()
+// DeleteRoleAssignment deletes the role assignment
+func (aadApp *App) DeleteRoleAssignment(ctx context.Context, p Provider, ra *RoleAssignment) (authorization.RoleAssignment, error) {
+	ctx = withOperation(ctx, "DeleteRoleAssignment")
+	roleClient, err := p.roleAssignmentsClient()
 	if err != nil {
 		return authorization.RoleAssignment{}, err
 	}
 
-	delRoleAssignment, err := roleClient.DeleteByID(ctx, aadApp.RoleAssignment.ObjectID)
+	delRoleAssignment, err := roleClient.DeleteByID(ctx, ra.ObjectID)
 	if err != nil {
 		return delRoleAssignment, err
 	}
@@ -0,0 +1,693 @@
+// Package fake provides an in-memory azuread.IdentityProvider for testing the controller without Azure
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/google/uuid"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// Operation names used as keys for injected errors
+const (
+	FindApplication                   = "FindApplication"
+	CreateApplication                 = "CreateApplication"
+	GetApplication                    = "GetApplication"
+	GetServicePrincipal               = "GetServicePrincipal"
+	GetRoleAssignment                 = "GetRoleAssignment"
+	FindServicePrincipal              = "FindServicePrincipal"
+	CreateServicePrincipal            = "CreateServicePrincipal"
+	AddPassword                       = "AddPassword"
+	RemovePassword                    = "RemovePassword"
+	CreateFederatedIdentityCredential = "CreateFederatedIdentityCredential"
+	GetFederatedIdentityCredential    = "GetFederatedIdentityCredential"
+	UpdateFederatedIdentityCredential = "UpdateFederatedIdentityCredential"
+	CreateManagedIdentity             = "CreateManagedIdentity"
+	GetManagedIdentity                = "GetManagedIdentity"
+	DeleteManagedIdentity             = "DeleteManagedIdentity"
+	CreateRoleAssignment              = "CreateRoleAssignment"
+	DeleteRoleAssignment              = "DeleteRoleAssignment"
+	DeleteApplication                 = "DeleteApplication"
+	DisableServicePrincipal           = "DisableServicePrincipal"
+	UpdateApplication                 = "UpdateApplication"
+	UpdateServicePrincipal            = "UpdateServicePrincipal"
+)
+
+// TenantID is the tenant every fake Application is registered in
+const TenantID = "00000000-0000-0000-0000-000000000000"
+
+// Application is an Azure AD Application held by the fake
+type Application struct {
+	ClientID    string
+	DisplayName string
+	ObjectID    string
+	OwnerID     string
+	// FederatedIdentityCredentials maps the name of each federated identity credential to it
+	FederatedIdentityCredentials map[string]FederatedIdentityCredential
+}
+
+// FederatedIdentityCredential is a federated identity credential of an Application held by the fake
+type FederatedIdentityCredential struct {
+	Audiences []string
+	Issuer    string
+	ObjectID  string
+	Subject   string
+}
+
+// ServicePrincipal is a Service Principal held by the fake
+type ServicePrincipal struct {
+	ApplicationObjectID string
+	// Disabled is set when the Service Principal is no longer allowed to sign in
+	Disabled bool
+	ObjectID string
+	Tags     []string
+	// Passwords maps the key ID of each ClientSecret to its value
+	Passwords map[string]string
+}
+
+// ManagedIdentity is a user-assigned managed identity held by the fake
+type ManagedIdentity struct {
+	ClientID      string
+	Location      string
+	Name          string
+	PrincipalID   string
+	ResourceGroup string
+	ResourceID    string
+	Tags          map[string]string
+}
+
+// RoleAssignment is a role assignment held by the fake
+type RoleAssignment struct {
+	Name        string
+	ObjectID    string
+	PrincipalID string
+	Role        string
+	Scope       string
+}
+
+// IdentityProvider is an in-memory azuread.IdentityProvider. It is safe for concurrent use.
+type IdentityProvider struct {
+	mu sync.Mutex
+
+	Applications      map[string]*Application
+	ServicePrincipals map[string]*ServicePrincipal
+	// ManagedIdentities maps the resource ID of each user-assigned managed identity to it
+	ManagedIdentities map[string]*ManagedIdentity
+	RoleAssignments   map[string]*RoleAssignment
+
+	// Errors holds an error to return from the named operation instead of performing it
+	Errors map[string]error
+	// Calls counts how many times each operation has been called
+	Calls map[string]int
+}
+
+var _ azuread.IdentityProvider = &IdentityProvider{}
+
+// NewIdentityProvider returns an empty fake IdentityProvider
+func NewIdentityProvider() *IdentityProvider {
+	return &IdentityProvider{
+		Applications:      map[string]*Application{},
+		ServicePrincipals: map[string]*ServicePrincipal{},
+		ManagedIdentities: map[string]*ManagedIdentity{},
+		RoleAssignments:   map[string]*RoleAssignment{},
+		Errors:            map[string]error{},
+		Calls:             map[string]int{},
+	}
+}
+
+// SetError makes the named operation fail with err until it is cleared with a nil error
+func (f *IdentityProvider) SetError(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.Errors, op)
+		return
+	}
+	f.Errors[op] = err
+}
+
+// CallCount returns how many times the named operation has been called
+func (f *IdentityProvider) CallCount(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Calls[op]
+}
+
+// Modify calls fn with the fake locked, to change the objects it holds as if they were changed outside the controller
+func (f *IdentityProvider) Modify(fn func(f *IdentityProvider)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+// Application returns a copy of the Application with the given object ID
+func (f *IdentityProvider) Application(objectID string) (Application, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	app, ok := f.Applications[objectID]
+	if !ok {
+		return Application{}, false
+	}
+
+	cp := *app
+	cp.FederatedIdentityCredentials = map[string]FederatedIdentityCredential{}
+	for k, v := range app.FederatedIdentityCredentials {
+		cp.FederatedIdentityCredentials[k] = v
+	}
+	return cp, true
+}
+
+// ServicePrincipal returns a copy of the Service Principal with the given object ID
+func (f *IdentityProvider) ServicePrincipal(objectID string) (ServicePrincipal, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sp, ok := f.ServicePrincipals[objectID]
+	if !ok {
+		return ServicePrincipal{}, false
+	}
+
+	cp := *sp
+	cp.Passwords = map[string]string{}
+	for k, v := range sp.Passwords {
+		cp.Passwords[k] = v
+	}
+	return cp, true
+}
+
+// ManagedIdentity returns a copy of the user-assigned managed identity with the given resource ID
+func (f *IdentityProvider) ManagedIdentity(resourceID string) (ManagedIdentity, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	mi, ok := f.ManagedIdentities[resourceID]
+	if !ok {
+		return ManagedIdentity{}, false
+	}
+	return *mi, true
+}
+
+// RoleAssignment returns a copy of the role assignment with the given ID
+func (f *IdentityProvider) RoleAssignment(objectID string) (RoleAssignment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ra, ok := f.RoleAssignments[objectID]
+	if !ok {
+		return RoleAssignment{}, false
+	}
+	return *ra, true
+}
+
+// notFound returns the error Azure responds with when the requested object does not exist
+func notFound(format string, args ...interface{}) error {
+	return &azure.RequestError{
+		DetailedError: autorest.DetailedError{StatusCode: http.StatusNotFound},
+		ServiceError:  &azure.ServiceError{Code: "Request_ResourceNotFound", Message: fmt.Sprintf(format, args...)},
+	}
+}
+
+// call records the operation and returns its injected error, if any. The caller must hold f.mu.
+func (f *IdentityProvider) call(op string) error {
+	f.Calls[op]++
+	return f.Errors[op]
+}
+
+// FindApplication looks up the Application registered for the App's OwnerID
+func (f *IdentityProvider) FindApplication(ctx context.Context, app *azuread.App) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(FindApplication); err != nil {
+		return false, err
+	}
+
+	for _, existing := range f.Applications {
+		if existing.OwnerID == app.OwnerID {
+			app.ClientID = existing.ClientID
+			app.ObjectID = existing.ObjectID
+			app.TenantID = TenantID
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CreateApplication registers a new Application
+func (f *IdentityProvider) CreateApplication(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(CreateApplication); err != nil {
+		return err
+	}
+
+	created := &Application{
+		ClientID:    uuid.New().String(),
+		DisplayName: app.DisplayName,
+		ObjectID:    uuid.New().String(),
+		OwnerID:     app.OwnerID,
+
+		FederatedIdentityCredentials: map[string]FederatedIdentityCredential{},
+	}
+	f.Applications[created.ObjectID] = created
+
+	app.ClientID = created.ClientID
+	app.ObjectID = created.ObjectID
+	app.TenantID = TenantID
+	return nil
+}
+
+// UpdateApplication sets the display name of the Application
+func (f *IdentityProvider) UpdateApplication(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(UpdateApplication); err != nil {
+		return err
+	}
+
+	application, ok := f.Applications[app.ObjectID]
+	if !ok {
+		return notFound("application %q not found", app.ObjectID)
+	}
+	application.DisplayName = app.DisplayName
+	return nil
+}
+
+// UpdateServicePrincipal sets the tags of the Service Principal
+func (f *IdentityProvider) UpdateServicePrincipal(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(UpdateServicePrincipal); err != nil {
+		return err
+	}
+
+	sp, ok := f.ServicePrincipals[app.ServicePrincipal.ObjectID]
+	if !ok {
+		return notFound("service principal %q not found", app.ServicePrincipal.ObjectID)
+	}
+	sp.Tags = append([]string(nil), app.ServicePrincipal.Tags...)
+	return nil
+}
+
+// GetApplication reports whether the Application with the App's ObjectID exists
+func (f *IdentityProvider) GetApplication(ctx context.Context, app *azuread.App) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(GetApplication); err != nil {
+		return false, err
+	}
+
+	_, ok := f.Applications[app.ObjectID]
+	return ok, nil
+}
+
+// GetServicePrincipal reports whether the Service Principal with the App's ObjectID exists
+// and records the key IDs of its ClientSecrets
+func (f *IdentityProvider) GetServicePrincipal(ctx context.Context, app *azuread.App) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(GetServicePrincipal); err != nil {
+		return false, err
+	}
+
+	sp, ok := f.ServicePrincipals[app.ServicePrincipal.ObjectID]
+	if !ok {
+		return false, nil
+	}
+
+	app.ServicePrincipal.PasswordKeyIDs = nil
+	for keyID := range sp.Passwords {
+		app.ServicePrincipal.PasswordKeyIDs = append(app.ServicePrincipal.PasswordKeyIDs, keyID)
+	}
+	return true, nil
+}
+
+// GetRoleAssignment reports whether the role assignment with the given ID exists
+func (f *IdentityProvider) GetRoleAssignment(ctx context.Context, app *azuread.App, ra *azuread.RoleAssignment) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(GetRoleAssignment); err != nil {
+		return false, err
+	}
+
+	_, ok := f.RoleAssignments[ra.ObjectID]
+	return ok, nil
+}
+
+// FindServicePrincipal looks up the Service Principal of the Application
+func (f *IdentityProvider) FindServicePrincipal(ctx context.Context, app *azuread.App) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(FindServicePrincipal); err != nil {
+		return false, err
+	}
+
+	for _, sp := range f.ServicePrincipals {
+		if sp.ApplicationObjectID == app.ObjectID {
+			app.ServicePrincipal.ObjectID = sp.ObjectID
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CreateServicePrincipal creates the Service Principal for the Application
+func (f *IdentityProvider) CreateServicePrincipal(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(CreateServicePrincipal); err != nil {
+		return err
+	}
+
+	if _, ok := f.Applications[app.ObjectID]; !ok {
+		return notFound("application %q not found", app.ObjectID)
+	}
+
+	sp := &ServicePrincipal{
+		ApplicationObjectID: app.ObjectID,
+		ObjectID:            uuid.New().String(),
+		Tags:                append([]string(nil), app.ServicePrincipal.Tags...),
+		Passwords:           map[string]string{},
+	}
+	f.ServicePrincipals[sp.ObjectID] = sp
+
+	app.ServicePrincipal.ObjectID = sp.ObjectID
+	return nil
+}
+
+// AddPassword adds a new ClientSecret to the Service Principal
+func (f *IdentityProvider) AddPassword(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(AddPassword); err != nil {
+		return err
+	}
+
+	sp, ok := f.ServicePrincipals[app.ServicePrincipal.ObjectID]
+	if !ok {
+		return notFound("service principal %q not found", app.ServicePrincipal.ObjectID)
+	}
+
+	return addPassword(sp, app)
+}
+
+func addPassword(sp *ServicePrincipal, app *azuread.App) error {
+	duration, err := time.ParseDuration(app.ServicePrincipal.Duration)
+	if err != nil {
+		return fmt.Errorf("invalid client secret duration %q: %w", app.ServicePrincipal.Duration, err)
+	}
+
+	keyID := uuid.New().String()
+	secret := uuid.New().String()
+	sp.Passwords[keyID] = secret
+
+	app.ServicePrincipal.ClientSecret = secret
+	app.ServicePrincipal.ClientSecretExpiration = date.Time{Time: time.Now().Add(duration)}
+	app.ServicePrincipal.ClientSecretKeyID = keyID
+	return nil
+}
+
+// RemovePassword removes the ClientSecret with the given key ID from the Service Principal
+func (f *IdentityProvider) RemovePassword(ctx context.Context, app *azuread.App, keyID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(RemovePassword); err != nil {
+		return err
+	}
+
+	sp, ok := f.ServicePrincipals[app.ServicePrincipal.ObjectID]
+	if !ok {
+		return notFound("service principal %q not found", app.ServicePrincipal.ObjectID)
+	}
+
+	delete(sp.Passwords, keyID)
+	return nil
+}
+
+// CreateFederatedIdentityCredential adds the federated identity credential to the Application. One that exists with
+// its name is adopted and updated to trust the tokens of the App.
+func (f *IdentityProvider) CreateFederatedIdentityCredential(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(CreateFederatedIdentityCredential); err != nil {
+		return err
+	}
+
+	application, ok := f.Applications[app.ObjectID]
+	if !ok {
+		return notFound("application %q not found", app.ObjectID)
+	}
+
+	fic := &app.FederatedIdentityCredential
+	objectID := uuid.New().String()
+	if existing, ok := application.FederatedIdentityCredentials[fic.Name]; ok {
+		objectID = existing.ObjectID
+	}
+
+	application.FederatedIdentityCredentials[fic.Name] = FederatedIdentityCredential{
+		Audiences: append([]string(nil), fic.Audiences...),
+		Issuer:    fic.Issuer,
+		ObjectID:  objectID,
+		Subject:   fic.Subject,
+	}
+
+	fic.ObjectID = objectID
+	return nil
+}
+
+// GetFederatedIdentityCredential reports whether the federated identity credential with the ObjectID of fic exists
+// and records what it trusts onto fic
+func (f *IdentityProvider) GetFederatedIdentityCredential(ctx context.Context, app *azuread.App, fic *azuread.FederatedIdentityCredential) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(GetFederatedIdentityCredential); err != nil {
+		return false, err
+	}
+
+	name, existing, ok := f.federatedIdentityCredential(app.ObjectID, fic.ObjectID)
+	if !ok {
+		return false, nil
+	}
+
+	fic.Name = name
+	fic.Issuer = existing.Issuer
+	fic.Subject = existing.Subject
+	fic.Audiences = append([]string(nil), existing.Audiences...)
+	return true, nil
+}
+
+// UpdateFederatedIdentityCredential sets the tokens the federated identity credential trusts to those of the App
+func (f *IdentityProvider) UpdateFederatedIdentityCredential(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(UpdateFederatedIdentityCredential); err != nil {
+		return err
+	}
+
+	fic := app.FederatedIdentityCredential
+	name, existing, ok := f.federatedIdentityCredential(app.ObjectID, fic.ObjectID)
+	if !ok {
+		return notFound("federated identity credential %q not found", fic.ObjectID)
+	}
+
+	existing.Audiences = append([]string(nil), fic.Audiences...)
+	existing.Issuer = fic.Issuer
+	existing.Subject = fic.Subject
+	f.Applications[app.ObjectID].FederatedIdentityCredentials[name] = existing
+	return nil
+}
+
+// federatedIdentityCredential looks up the federated identity credential of an Application by its object ID
+func (f *IdentityProvider) federatedIdentityCredential(applicationObjectID, objectID string) (string, FederatedIdentityCredential, bool) {
+	application, ok := f.Applications[applicationObjectID]
+	if !ok {
+		return "", FederatedIdentityCredential{}, false
+	}
+
+	for name, fic := range application.FederatedIdentityCredentials {
+		if fic.ObjectID == objectID {
+			return name, fic, true
+		}
+	}
+	return "", FederatedIdentityCredential{}, false
+}
+
+// managedIdentityID returns the resource ID of the managed identity described by the App
+func managedIdentityID(app *azuread.App) string {
+	mi := app.ManagedIdentity
+	return "/subscriptions/" + TenantID + "/resourceGroups/" + mi.ResourceGroup + "/providers/Microsoft.ManagedIdentity/userAssignedIdentities/" + mi.Name
+}
+
+// CreateManagedIdentity creates the user-assigned managed identity unless one with its name exists
+func (f *IdentityProvider) CreateManagedIdentity(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(CreateManagedIdentity); err != nil {
+		return err
+	}
+
+	mi := &app.ManagedIdentity
+	id := managedIdentityID(app)
+	existing, ok := f.ManagedIdentities[id]
+	if !ok {
+		existing = &ManagedIdentity{
+			ClientID:      uuid.New().String(),
+			Location:      mi.Location,
+			Name:          mi.Name,
+			PrincipalID:   uuid.New().String(),
+			ResourceGroup: mi.ResourceGroup,
+			ResourceID:    id,
+		}
+		f.ManagedIdentities[id] = existing
+	}
+	existing.Tags = map[string]string{}
+	for k, v := range mi.Tags {
+		existing.Tags[k] = v
+	}
+
+	mi.ClientID = existing.ClientID
+	mi.PrincipalID = existing.PrincipalID
+	mi.ResourceID = existing.ResourceID
+	app.TenantID = TenantID
+	return nil
+}
+
+// GetManagedIdentity reports whether the user-assigned managed identity exists
+func (f *IdentityProvider) GetManagedIdentity(ctx context.Context, app *azuread.App) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(GetManagedIdentity); err != nil {
+		return false, err
+	}
+
+	_, ok := f.ManagedIdentities[managedIdentityID(app)]
+	return ok, nil
+}
+
+// DeleteManagedIdentity deletes the user-assigned managed identity
+func (f *IdentityProvider) DeleteManagedIdentity(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(DeleteManagedIdentity); err != nil {
+		return err
+	}
+
+	delete(f.ManagedIdentities, managedIdentityID(app))
+	return nil
+}
+
+// CreateRoleAssignment assigns the Service Principal the role of the role assignment over its scope
+func (f *IdentityProvider) CreateRoleAssignment(ctx context.Context, app *azuread.App, ra *azuread.RoleAssignment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(CreateRoleAssignment); err != nil {
+		return err
+	}
+
+	name := ra.Name
+	if name == "" {
+		name = uuid.New().String()
+	}
+	scope := ra.Scope
+	if !strings.HasPrefix(scope, "/") {
+		scope = "/subscriptions/" + TenantID + "/resourceGroups/" + scope
+	}
+	created := &RoleAssignment{
+		Name:        name,
+		ObjectID:    scope + "/providers/Microsoft.Authorization/roleAssignments/" + name,
+		PrincipalID: app.PrincipalID(),
+		Role:        ra.Role,
+		Scope:       scope,
+	}
+	f.RoleAssignments[created.ObjectID] = created
+
+	ra.Name = created.Name
+	ra.ObjectID = created.ObjectID
+	return nil
+}
+
+// DeleteRoleAssignment deletes the role assignment
+func (f *IdentityProvider) DeleteRoleAssignment(ctx context.Context, app *azuread.App, ra *azuread.RoleAssignment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(DeleteRoleAssignment); err != nil {
+		return err
+	}
+
+	delete(f.RoleAssignments, ra.ObjectID)
+	return nil
+}
+
+// DeleteApplication deletes the Application together with its Service Principals
+func (f *IdentityProvider) DeleteApplication(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(DeleteApplication); err != nil {
+		return err
+	}
+
+	if _, ok := f.Applications[app.ObjectID]; !ok {
+		return notFound("application %q not found", app.ObjectID)
+	}
+
+	delete(f.Applications, app.ObjectID)
+	for id, sp := range f.ServicePrincipals {
+		if sp.ApplicationObjectID == app.ObjectID {
+			delete(f.ServicePrincipals, id)
+		}
+	}
+	return nil
+}
+
+// DisableServicePrincipal disables the Service Principal and removes its ClientSecrets and federated identity credentials
+func (f *IdentityProvider) DisableServicePrincipal(ctx context.Context, app *azuread.App) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(DisableServicePrincipal); err != nil {
+		return err
+	}
+
+	sp, ok := f.ServicePrincipals[app.ServicePrincipal.ObjectID]
+	if !ok {
+		return notFound("service principal %q not found", app.ServicePrincipal.ObjectID)
+	}
+
+	sp.Disabled = true
+	sp.Passwords = map[string]string{}
+	if application, ok := f.Applications[sp.ApplicationObjectID]; ok {
+		application.FederatedIdentityCredentials = map[string]FederatedIdentityCredential{}
+	}
+	return nil
+}
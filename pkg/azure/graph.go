@@ -0,0 +1,357 @@
+package azuread
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+// GraphVersion is the Microsoft Graph API version used by GraphClient
+const GraphVersion = "v1.0"
+
+// GraphApplication is a Microsoft Graph application resource
+type GraphApplication struct {
+	ID             *string   `json:"id,omitempty"`
+	AppID          *string   `json:"appId,omitempty"`
+	DisplayName    *string   `json:"displayName,omitempty"`
+	SignInAudience *string   `json:"signInAudience,omitempty"`
+	Tags           *[]string `json:"tags,omitempty"`
+}
+
+// GraphServicePrincipal is a Microsoft Graph servicePrincipal resource
+type GraphServicePrincipal struct {
+	ID                  *string                    `json:"id,omitempty"`
+	AccountEnabled      *bool                      `json:"accountEnabled,omitempty"`
+	AppID               *string                    `json:"appId,omitempty"`
+	PasswordCredentials *[]GraphPasswordCredential `json:"passwordCredentials,omitempty"`
+	Tags                *[]string                  `json:"tags,omitempty"`
+}
+
+// GraphPasswordCredential is a Microsoft Graph passwordCredential resource.
+// SecretText is only returned by addPassword.
+type GraphPasswordCredential struct {
+	DisplayName   *string    `json:"displayName,omitempty"`
+	EndDateTime   *date.Time `json:"endDateTime,omitempty"`
+	KeyID         *string    `json:"keyId,omitempty"`
+	SecretText    *string    `json:"secretText,omitempty"`
+	StartDateTime *date.Time `json:"startDateTime,omitempty"`
+}
+
+// GraphFederatedIdentityCredential is a Microsoft Graph federatedIdentityCredential resource
+type GraphFederatedIdentityCredential struct {
+	ID        *string   `json:"id,omitempty"`
+	Audiences *[]string `json:"audiences,omitempty"`
+	Issuer    *string   `json:"issuer,omitempty"`
+	Name      *string   `json:"name,omitempty"`
+	Subject   *string   `json:"subject,omitempty"`
+}
+
+type graphApplicationList struct {
+	Value    []GraphApplication `json:"value"`
+	NextLink *string            `json:"@odata.nextLink,omitempty"`
+}
+
+type graphServicePrincipalList struct {
+	Value    []GraphServicePrincipal `json:"value"`
+	NextLink *string                 `json:"@odata.nextLink,omitempty"`
+}
+
+type graphFederatedIdentityCredentialList struct {
+	Value    []GraphFederatedIdentityCredential `json:"value"`
+	NextLink *string                            `json:"@odata.nextLink,omitempty"`
+}
+
+type graphAddPasswordParameters struct {
+	PasswordCredential GraphPasswordCredential `json:"passwordCredential"`
+}
+
+type graphRemovePasswordParameters struct {
+	KeyID string `json:"keyId"`
+}
+
+// GraphClient manages Applications, Service Principals and their password credentials through Microsoft Graph
+type GraphClient struct {
+	autorest.Client
+	// BaseURI is the versioned Microsoft Graph endpoint, such as https://graph.microsoft.com/v1.0
+	BaseURI string
+}
+
+// NewGraphClient creates a GraphClient for the Microsoft Graph endpoint of a cloud
+func NewGraphClient(endpoint string) GraphClient {
+	client := autorest.NewClientWithUserAgent("")
+	client.Sender = instrumentedSender(apiGraph)
+	return GraphClient{
+		Client:  client,
+		BaseURI: strings.TrimSuffix(endpoint, "/") + "/" + GraphVersion,
+	}
+}
+
+// CreateApplication registers a new application
+func (c GraphClient) CreateApplication(ctx context.Context, app GraphApplication) (GraphApplication, error) {
+	var result GraphApplication
+	err := c.do(ctx, "CreateApplication", &result, []int{http.StatusCreated},
+		autorest.AsPost(),
+		autorest.AsJSON(),
+		autorest.WithPath("/applications"),
+		autorest.WithJSON(app))
+	return result, err
+}
+
+// ListApplications lists every application matching the OData filter
+func (c GraphClient) ListApplications(ctx context.Context, filter string) ([]GraphApplication, error) {
+	var apps []GraphApplication
+	page := graphApplicationList{}
+	err := c.do(ctx, "ListApplications", &page, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPath("/applications"),
+		autorest.WithQueryParameters(map[string]interface{}{"$filter": filter}))
+
+	for err == nil {
+		apps = append(apps, page.Value...)
+		if page.NextLink == nil {
+			break
+		}
+
+		next := *page.NextLink
+		page = graphApplicationList{}
+		err = c.do(ctx, "ListApplications", &page, []int{http.StatusOK}, autorest.AsGet(), autorest.WithBaseURL(next))
+	}
+
+	return apps, err
+}
+
+// GetApplication gets the application with the given object ID
+func (c GraphClient) GetApplication(ctx context.Context, objectID string) (GraphApplication, error) {
+	var result GraphApplication
+	err := c.do(ctx, "GetApplication", &result, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPathParameters("/applications/{id}", map[string]interface{}{"id": objectID}))
+	return result, err
+}
+
+// UpdateApplication patches the properties set on app onto the application with the given object ID
+func (c GraphClient) UpdateApplication(ctx context.Context, objectID string, app GraphApplication) error {
+	return c.do(ctx, "UpdateApplication", nil, []int{http.StatusNoContent},
+		autorest.AsPatch(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/applications/{id}", map[string]interface{}{"id": objectID}),
+		autorest.WithJSON(app))
+}
+
+// DeleteApplication deletes the application with the given object ID together with its service principal
+func (c GraphClient) DeleteApplication(ctx context.Context, objectID string) error {
+	return c.do(ctx, "DeleteApplication", nil, []int{http.StatusNoContent},
+		autorest.AsDelete(),
+		autorest.WithPathParameters("/applications/{id}", map[string]interface{}{"id": objectID}))
+}
+
+// CreateFederatedIdentityCredential adds a federated identity credential to the application
+func (c GraphClient) CreateFederatedIdentityCredential(ctx context.Context, objectID string, credential GraphFederatedIdentityCredential) (GraphFederatedIdentityCredential, error) {
+	var result GraphFederatedIdentityCredential
+	err := c.do(ctx, "CreateFederatedIdentityCredential", &result, []int{http.StatusCreated},
+		autorest.AsPost(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/applications/{id}/federatedIdentityCredentials", map[string]interface{}{"id": objectID}),
+		autorest.WithJSON(credential))
+	return result, err
+}
+
+// GetFederatedIdentityCredential gets the federated identity credential with the given ID of the application
+func (c GraphClient) GetFederatedIdentityCredential(ctx context.Context, objectID string, credentialID string) (GraphFederatedIdentityCredential, error) {
+	var result GraphFederatedIdentityCredential
+	err := c.do(ctx, "GetFederatedIdentityCredential", &result, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPathParameters("/applications/{id}/federatedIdentityCredentials/{credentialId}", map[string]interface{}{"id": objectID, "credentialId": credentialID}))
+	return result, err
+}
+
+// UpdateFederatedIdentityCredential patches the properties set on credential onto the federated identity credential
+// with the given ID. The name of a federated identity credential cannot be changed.
+func (c GraphClient) UpdateFederatedIdentityCredential(ctx context.Context, objectID string, credentialID string, credential GraphFederatedIdentityCredential) error {
+	return c.do(ctx, "UpdateFederatedIdentityCredential", nil, []int{http.StatusNoContent},
+		autorest.AsPatch(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/applications/{id}/federatedIdentityCredentials/{credentialId}", map[string]interface{}{"id": objectID, "credentialId": credentialID}),
+		autorest.WithJSON(credential))
+}
+
+// DeleteFederatedIdentityCredential removes the federated identity credential with the given ID from the application
+func (c GraphClient) DeleteFederatedIdentityCredential(ctx context.Context, objectID string, credentialID string) error {
+	return c.do(ctx, "DeleteFederatedIdentityCredential", nil, []int{http.StatusNoContent},
+		autorest.AsDelete(),
+		autorest.WithPathParameters("/applications/{id}/federatedIdentityCredentials/{credentialId}", map[string]interface{}{"id": objectID, "credentialId": credentialID}))
+}
+
+// ListFederatedIdentityCredentials lists the federated identity credentials of the application
+func (c GraphClient) ListFederatedIdentityCredentials(ctx context.Context, objectID string) ([]GraphFederatedIdentityCredential, error) {
+	var credentials []GraphFederatedIdentityCredential
+	page := graphFederatedIdentityCredentialList{}
+	err := c.do(ctx, "ListFederatedIdentityCredentials", &page, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPathParameters("/applications/{id}/federatedIdentityCredentials", map[string]interface{}{"id": objectID}))
+
+	for err == nil {
+		credentials = append(credentials, page.Value...)
+		if page.NextLink == nil {
+			break
+		}
+
+		next := *page.NextLink
+		page = graphFederatedIdentityCredentialList{}
+		err = c.do(ctx, "ListFederatedIdentityCredentials", &page, []int{http.StatusOK}, autorest.AsGet(), autorest.WithBaseURL(next))
+	}
+
+	return credentials, err
+}
+
+// CreateServicePrincipal creates the service principal of an application
+func (c GraphClient) CreateServicePrincipal(ctx context.Context, sp GraphServicePrincipal) (GraphServicePrincipal, error) {
+	var result GraphServicePrincipal
+	err := c.do(ctx, "CreateServicePrincipal", &result, []int{http.StatusCreated},
+		autorest.AsPost(),
+		autorest.AsJSON(),
+		autorest.WithPath("/servicePrincipals"),
+		autorest.WithJSON(sp))
+	return result, err
+}
+
+// GetServicePrincipal gets the service principal with the given object ID, including the metadata of its password credentials
+func (c GraphClient) GetServicePrincipal(ctx context.Context, objectID string) (GraphServicePrincipal, error) {
+	var result GraphServicePrincipal
+	err := c.do(ctx, "GetServicePrincipal", &result, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPathParameters("/servicePrincipals/{id}", map[string]interface{}{"id": objectID}))
+	return result, err
+}
+
+// UpdateServicePrincipal patches the properties set on sp onto the service principal with the given object ID
+func (c GraphClient) UpdateServicePrincipal(ctx context.Context, objectID string, sp GraphServicePrincipal) error {
+	return c.do(ctx, "UpdateServicePrincipal", nil, []int{http.StatusNoContent},
+		autorest.AsPatch(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/servicePrincipals/{id}", map[string]interface{}{"id": objectID}),
+		autorest.WithJSON(sp))
+}
+
+// ListServicePrincipals lists every service principal matching the OData filter
+func (c GraphClient) ListServicePrincipals(ctx context.Context, filter string) ([]GraphServicePrincipal, error) {
+	var sps []GraphServicePrincipal
+	page := graphServicePrincipalList{}
+	err := c.do(ctx, "ListServicePrincipals", &page, []int{http.StatusOK},
+		autorest.AsGet(),
+		autorest.WithPath("/servicePrincipals"),
+		autorest.WithQueryParameters(map[string]interface{}{"$filter": filter}))
+
+	for err == nil {
+		sps = append(sps, page.Value...)
+		if page.NextLink == nil {
+			break
+		}
+
+		next := *page.NextLink
+		page = graphServicePrincipalList{}
+		err = c.do(ctx, "ListServicePrincipals", &page, []int{http.StatusOK}, autorest.AsGet(), autorest.WithBaseURL(next))
+	}
+
+	return sps, err
+}
+
+// AddPassword adds a new password credential to the service principal. Graph generates the
+// secret and only returns it in the response to this call.
+func (c GraphClient) AddPassword(ctx context.Context, objectID string, credential GraphPasswordCredential) (GraphPasswordCredential, error) {
+	var result GraphPasswordCredential
+	err := c.do(ctx, "AddPassword", &result, []int{http.StatusOK},
+		autorest.AsPost(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/servicePrincipals/{id}/addPassword", map[string]interface{}{"id": objectID}),
+		autorest.WithJSON(graphAddPasswordParameters{PasswordCredential: credential}))
+	return result, err
+}
+
+// RemovePassword removes the password credential with the given key ID from the service principal
+func (c GraphClient) RemovePassword(ctx context.Context, objectID string, keyID string) error {
+	return c.do(ctx, "RemovePassword", nil, []int{http.StatusNoContent},
+		autorest.AsPost(),
+		autorest.AsJSON(),
+		autorest.WithPathParameters("/servicePrincipals/{id}/removePassword", map[string]interface{}{"id": objectID}),
+		autorest.WithJSON(graphRemovePasswordParameters{KeyID: keyID}))
+}
+
+// do sends a request for the operation to Microsoft Graph and unmarshals the response into result unless it is nil.
+// A response with any other status code than expected is returned as an error.
+func (c GraphClient) do(ctx context.Context, operation string, result interface{}, expected []int, decorators ...autorest.PrepareDecorator) error {
+	decorators = append([]autorest.PrepareDecorator{autorest.WithBaseURL(c.BaseURI)}, decorators...)
+	req, err := autorest.Prepare((&http.Request{}).WithContext(withOperation(ctx, operation)), decorators...)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Send(req, autorest.DoRetryForStatusCodes(c.RetryAttempts, c.RetryDuration, autorest.StatusCodesForRetry...))
+	if err != nil {
+		return err
+	}
+
+	responders := []autorest.RespondDecorator{c.ByInspecting(), azure.WithErrorUnlessStatusCode(expected...)}
+	if result != nil {
+		responders = append(responders, autorest.ByUnmarshallingJSON(result))
+	}
+	responders = append(responders, autorest.ByClosing())
+	return autorest.Respond(resp, responders...)
+}
+
+// IsNotFound reports whether err is a response from Microsoft Graph or Azure Resource Manager
+// saying the requested object does not exist
+func IsNotFound(err error) bool {
+	var requestErr *azure.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.StatusCode == http.StatusNotFound
+	}
+
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		return detailedErr.StatusCode == http.StatusNotFound
+	}
+
+	return false
+}
+
+// IsPrincipalNotFound reports whether err is a response from Azure Resource Manager saying the principal of a
+// role assignment does not exist, which is usually because a new principal has not replicated to it yet
+func IsPrincipalNotFound(err error) bool {
+	return serviceErrorCode(err) == "PrincipalNotFound"
+}
+
+// IsAuthorizationFailed reports whether err is a response from Microsoft Graph or Azure Resource Manager saying
+// the controller is not allowed to perform the operation. Retrying will not help until it is granted permission.
+func IsAuthorizationFailed(err error) bool {
+	switch serviceErrorCode(err) {
+	case "AuthorizationFailed", "LinkedAuthorizationFailed", "Authorization_RequestDenied":
+		return true
+	}
+
+	var requestErr *azure.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.StatusCode == http.StatusForbidden
+	}
+
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		return detailedErr.StatusCode == http.StatusForbidden
+	}
+
+	return false
+}
+
+// serviceErrorCode returns the error code in the body of a failed response, if any
+func serviceErrorCode(err error) string {
+	var requestErr *azure.RequestError
+	if errors.As(err, &requestErr) && requestErr.ServiceError != nil {
+		return requestErr.ServiceError.Code
+	}
+	return ""
+}
@@ -0,0 +1,336 @@
+package azuread
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// newTestGraphClient returns a GraphClient that sends its requests to handler instead of Microsoft Graph
+func newTestGraphClient(t *testing.T, handler http.HandlerFunc) GraphClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewGraphClient(server.URL)
+	client.Authorizer = autorest.NullAuthorizer{}
+	client.RetryAttempts = 1
+	return client
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("encoding response: %v", err)
+	}
+}
+
+func TestGraphClientCreateApplication(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1.0/applications" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		var app GraphApplication
+		if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		if *app.DisplayName != "my-app" || (*app.Tags)[0] != ownerTag("uid") {
+			t.Errorf("unexpected application %+v", app)
+		}
+
+		app.ID = to.StringPtr("object-id")
+		app.AppID = to.StringPtr("client-id")
+		writeJSON(t, w, http.StatusCreated, app)
+	})
+
+	app, err := client.CreateApplication(context.Background(), GraphApplication{
+		DisplayName: to.StringPtr("my-app"),
+		Tags:        &[]string{ownerTag("uid")},
+	})
+	if err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+	if *app.ID != "object-id" || *app.AppID != "client-id" {
+		t.Errorf("unexpected application %+v", app)
+	}
+}
+
+func TestGraphClientListApplicationsFollowsNextLink(t *testing.T) {
+	var serverURL string
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$skiptoken") == "" {
+			if filter := r.URL.Query().Get("$filter"); filter != "tags/any(t:t eq 'owner')" {
+				t.Errorf("unexpected filter %q", filter)
+			}
+			writeJSON(t, w, http.StatusOK, map[string]interface{}{
+				"value":           []GraphApplication{{ID: to.StringPtr("first")}},
+				"@odata.nextLink": serverURL + "/v1.0/applications?$skiptoken=next",
+			})
+			return
+		}
+
+		writeJSON(t, w, http.StatusOK, map[string]interface{}{
+			"value": []GraphApplication{{ID: to.StringPtr("second")}},
+		})
+	})
+	serverURL = client.BaseURI[:len(client.BaseURI)-len("/"+GraphVersion)]
+
+	apps, err := client.ListApplications(context.Background(), "tags/any(t:t eq 'owner')")
+	if err != nil {
+		t.Fatalf("ListApplications: %v", err)
+	}
+	if len(apps) != 2 || *apps[0].ID != "first" || *apps[1].ID != "second" {
+		t.Errorf("unexpected applications %+v", apps)
+	}
+}
+
+func TestGraphClientPasswords(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/servicePrincipals/sp-id/addPassword":
+			var params graphAddPasswordParameters
+			if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+				t.Fatalf("decoding request: %v", err)
+			}
+			if !params.PasswordCredential.EndDateTime.Equal(expiration) {
+				t.Errorf("unexpected endDateTime %v", params.PasswordCredential.EndDateTime)
+			}
+
+			params.PasswordCredential.KeyID = to.StringPtr("key-id")
+			params.PasswordCredential.SecretText = to.StringPtr("secret")
+			writeJSON(t, w, http.StatusOK, params.PasswordCredential)
+
+		case "/v1.0/servicePrincipals/sp-id/removePassword":
+			var params graphRemovePasswordParameters
+			if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+				t.Fatalf("decoding request: %v", err)
+			}
+			if params.KeyID != "key-id" {
+				t.Errorf("unexpected keyId %q", params.KeyID)
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	credential, err := client.AddPassword(context.Background(), "sp-id", GraphPasswordCredential{
+		EndDateTime: &date.Time{Time: expiration},
+	})
+	if err != nil {
+		t.Fatalf("AddPassword: %v", err)
+	}
+	if *credential.KeyID != "key-id" || *credential.SecretText != "secret" {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+
+	if err := client.RemovePassword(context.Background(), "sp-id", "key-id"); err != nil {
+		t.Fatalf("RemovePassword: %v", err)
+	}
+}
+
+func TestGraphClientReturnsServiceError(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusForbidden, map[string]interface{}{
+			"error": map[string]string{
+				"code":    "Authorization_RequestDenied",
+				"message": "Insufficient privileges to complete the operation.",
+			},
+		})
+	})
+
+	_, err := client.CreateServicePrincipal(context.Background(), GraphServicePrincipal{AppID: to.StringPtr("client-id")})
+	if err == nil {
+		t.Fatal("CreateServicePrincipal: expected an error")
+	}
+
+	requestErr, ok := err.(*azure.RequestError)
+	if !ok || requestErr.StatusCode != http.StatusForbidden || requestErr.ServiceError.Code != "Authorization_RequestDenied" {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
+func TestGraphClientGetServicePrincipalNotFound(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1.0/servicePrincipals/sp-object-id" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(t, w, http.StatusNotFound, map[string]interface{}{
+			"error": map[string]string{"code": "Request_ResourceNotFound", "message": "Resource does not exist."},
+		})
+	})
+
+	_, err := client.GetServicePrincipal(context.Background(), "sp-object-id")
+	if !IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestGraphClientGetServicePrincipalPasswords(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusOK, GraphServicePrincipal{
+			ID:                  to.StringPtr("sp-object-id"),
+			PasswordCredentials: &[]GraphPasswordCredential{{KeyID: to.StringPtr("key-id")}},
+		})
+	})
+
+	sp, err := client.GetServicePrincipal(context.Background(), "sp-object-id")
+	if err != nil {
+		t.Fatalf("GetServicePrincipal: %v", err)
+	}
+	if len(*sp.PasswordCredentials) != 1 || *(*sp.PasswordCredentials)[0].KeyID != "key-id" {
+		t.Errorf("unexpected service principal %+v", sp)
+	}
+}
+
+func TestGraphClientUpdateServicePrincipal(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/v1.0/servicePrincipals/sp-object-id" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		if len(body) != 1 || body["accountEnabled"] != false {
+			t.Errorf("unexpected body %v", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	err := client.UpdateServicePrincipal(context.Background(), "sp-object-id", GraphServicePrincipal{AccountEnabled: to.BoolPtr(false)})
+	if err != nil {
+		t.Fatalf("UpdateServicePrincipal: %v", err)
+	}
+}
+
+func TestCreateFederatedIdentityCredentialAdoptsWithDifferentIssuer(t *testing.T) {
+	var patched GraphFederatedIdentityCredential
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1.0/applications/app-object-id/federatedIdentityCredentials":
+			writeJSON(t, w, http.StatusOK, graphFederatedIdentityCredentialList{Value: []GraphFederatedIdentityCredential{{
+				ID:        to.StringPtr("fic-id"),
+				Audiences: &[]string{"api://AzureADTokenExchange"},
+				Issuer:    to.StringPtr("https://old-issuer.example.com"),
+				Name:      to.StringPtr("azidterminator-uid"),
+				Subject:   to.StringPtr("system:serviceaccount:default:workload"),
+			}}})
+
+		case r.Method == http.MethodPatch && r.URL.Path == "/v1.0/applications/app-object-id/federatedIdentityCredentials/fic-id":
+			raw, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("reading request: %v", err)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(raw, &body); err != nil {
+				t.Fatalf("decoding request: %v", err)
+			}
+			if _, ok := body["name"]; ok {
+				t.Errorf("the name of a federated identity credential cannot be patched, got %v", body)
+			}
+			if err := json.Unmarshal(raw, &patched); err != nil {
+				t.Fatalf("decoding request: %v", err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	fic := &FederatedIdentityCredential{
+		Audiences: []string{"api://AzureADTokenExchange"},
+		Issuer:    "https://new-issuer.example.com",
+		Name:      "azidterminator-uid",
+		Subject:   "system:serviceaccount:default:workload",
+	}
+	credential, err := createFederatedIdentityCredential(context.Background(), client, "app-object-id", fic)
+	if err != nil {
+		t.Fatalf("createFederatedIdentityCredential: %v", err)
+	}
+	if fic.ObjectID != "fic-id" {
+		t.Errorf("expected the existing credential to be adopted, got %q", fic.ObjectID)
+	}
+	if patched.Issuer == nil || *patched.Issuer != "https://new-issuer.example.com" || *patched.Subject != fic.Subject {
+		t.Errorf("expected the adopted credential to be patched to trust the new issuer, got %+v", patched)
+	}
+	if *credential.Issuer != "https://new-issuer.example.com" || *credential.ID != "fic-id" {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+}
+
+func TestCreateFederatedIdentityCredentialAdoptsMatching(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected a matching credential to be adopted as is, got %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(t, w, http.StatusOK, graphFederatedIdentityCredentialList{Value: []GraphFederatedIdentityCredential{{
+			ID:        to.StringPtr("fic-id"),
+			Audiences: &[]string{"b", "a"},
+			Issuer:    to.StringPtr("https://issuer.example.com"),
+			Name:      to.StringPtr("azidterminator-uid"),
+			Subject:   to.StringPtr("system:serviceaccount:default:workload"),
+		}}})
+	})
+
+	fic := &FederatedIdentityCredential{
+		Audiences: []string{"a", "b"},
+		Issuer:    "https://issuer.example.com",
+		Name:      "azidterminator-uid",
+		Subject:   "system:serviceaccount:default:workload",
+	}
+	if _, err := createFederatedIdentityCredential(context.Background(), client, "app-object-id", fic); err != nil {
+		t.Fatalf("createFederatedIdentityCredential: %v", err)
+	}
+	if fic.ObjectID != "fic-id" {
+		t.Errorf("expected the existing credential to be adopted, got %q", fic.ObjectID)
+	}
+}
+
+func TestClassifyRoleAssignmentErrors(t *testing.T) {
+	// armError builds the error the Azure Resource Manager clients return for a failed response
+	armError := func(status int, code string) error {
+		return autorest.NewErrorWithError(&azure.RequestError{
+			DetailedError: autorest.DetailedError{StatusCode: status},
+			ServiceError:  &azure.ServiceError{Code: code},
+		}, "authorization.RoleAssignmentsClient", "Create", &http.Response{StatusCode: status}, "Failure responding to request")
+	}
+
+	tests := []struct {
+		name                string
+		err                 error
+		principalNotFound   bool
+		authorizationFailed bool
+	}{
+		{name: "principal not replicated", err: armError(http.StatusBadRequest, "PrincipalNotFound"), principalNotFound: true},
+		{name: "authorization failed", err: armError(http.StatusForbidden, "AuthorizationFailed"), authorizationFailed: true},
+		{name: "forbidden without a code", err: autorest.DetailedError{StatusCode: http.StatusForbidden}, authorizationFailed: true},
+		{name: "conflict", err: armError(http.StatusConflict, "RoleAssignmentExists")},
+		{name: "nil", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPrincipalNotFound(tt.err); got != tt.principalNotFound {
+				t.Errorf("IsPrincipalNotFound: expected %v, got %v", tt.principalNotFound, got)
+			}
+			if got := IsAuthorizationFailed(tt.err); got != tt.authorizationFailed {
+				t.Errorf("IsAuthorizationFailed: expected %v, got %v", tt.authorizationFailed, got)
+			}
+		})
+	}
+}
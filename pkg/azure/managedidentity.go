@@ -0,0 +1,110 @@
+package azuread
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/msi/mgmt/2018-11-30/msi"
+	"github.com/Azure/go-autorest/autorest/to"
+	gofrsuuid "github.com/gofrs/uuid"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+)
+
+// ManagedIdentity is a user-assigned managed identity bound to pods instead of a service principal
+type ManagedIdentity struct {
+	ClientID string
+	Location string
+	Name     string
+	// PrincipalID is the object ID of the service principal Azure creates for the managed identity
+	PrincipalID   string
+	ResourceGroup string
+	ResourceID    string
+	Tags          map[string]string
+}
+
+// PrincipalID returns the object ID the roles are assigned to: the managed identity's when the App has one,
+// otherwise the service principal's
+func (aadApp *App) PrincipalID() string {
+	if aadApp.ManagedIdentity.Name != "" {
+		return aadApp.ManagedIdentity.PrincipalID
+	}
+	return aadApp.ServicePrincipal.ObjectID
+}
+
+// userAssignedIdentitiesClient returns a UserAssignedIdentitiesClient for the subscription of the Provider, authorized by its Credentials
+func (p Provider) userAssignedIdentitiesClient() (msi.UserAssignedIdentitiesClient, error) {
+	msiClient := msi.NewUserAssignedIdentitiesClientWithBaseURI(p.Environment.ResourceManagerEndpoint, p.SubscriptionID)
+	a, err := p.resourceManagementAuthorizer()
+	if err != nil {
+		return msiClient, err
+	}
+	msiClient.Authorizer = a
+	msiClient.AddToUserAgent(config.UserAgent())
+	msiClient.Sender = instrumentedSender(apiARM)
+	return msiClient, nil
+}
+
+// CreateManagedIdentity creates the user-assigned managed identity, or updates the one that already has its name
+func (aadApp *App) CreateManagedIdentity(ctx context.Context, p Provider) (msi.Identity, error) {
+	ctx = withOperation(ctx, "CreateManagedIdentity")
+	msiClient, err := p.userAssignedIdentitiesClient()
+	if err != nil {
+		return msi.Identity{}, err
+	}
+
+	mi := &aadApp.ManagedIdentity
+	tags := map[string]*string{}
+	for k, v := range mi.Tags {
+		tags[k] = to.StringPtr(v)
+	}
+
+	identity, err := msiClient.CreateOrUpdate(ctx, mi.ResourceGroup, mi.Name, msi.Identity{
+		Location: to.StringPtr(mi.Location),
+		Tags:     tags,
+	})
+	if err != nil {
+		return identity, err
+	}
+
+	mi.ResourceID = *identity.ID
+	if props := identity.UserAssignedIdentityProperties; props != nil {
+		mi.ClientID = uuidString(props.ClientID)
+		mi.PrincipalID = uuidString(props.PrincipalID)
+		aadApp.TenantID = uuidString(props.TenantID)
+	}
+	return identity, err
+}
+
+// uuidString formats an ID returned by the msi client, which leaves out the IDs it has not assigned yet
+func uuidString(id *gofrsuuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// GetManagedIdentity reports whether the user-assigned managed identity still exists
+func (aadApp *App) GetManagedIdentity(ctx context.Context, p Provider) (bool, error) {
+	ctx = withOperation(ctx, "GetManagedIdentity")
+	msiClient, err := p.userAssignedIdentitiesClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = msiClient.Get(ctx, aadApp.ManagedIdentity.ResourceGroup, aadApp.ManagedIdentity.Name)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteManagedIdentity deletes the user-assigned managed identity and with it its service principal
+func (aadApp *App) DeleteManagedIdentity(ctx context.Context, p Provider) error {
+	ctx = withOperation(ctx, "DeleteManagedIdentity")
+	msiClient, err := p.userAssignedIdentitiesClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = msiClient.Delete(ctx, aadApp.ManagedIdentity.ResourceGroup, aadApp.ManagedIdentity.Name)
+	return err
+}
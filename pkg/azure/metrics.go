@@ -0,0 +1,73 @@
+package azuread
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// API labels of the Azure request metrics
+const (
+	apiGraph = "graph"
+	apiARM   = "arm"
+)
+
+var (
+	azureRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azidterminator_azure_requests_total",
+		Help: "Number of requests sent to Microsoft Graph and Azure Resource Manager, by operation and HTTP status code.",
+	}, []string{"api", "operation", "code"})
+
+	azureRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "azidterminator_azure_request_duration_seconds",
+		Help:    "Latency of requests sent to Microsoft Graph and Azure Resource Manager, by operation and HTTP status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"api", "operation", "code"})
+
+	roleAssignmentRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azidterminator_role_assignment_retries_total",
+		Help: "Number of times creating a role assignment was retried.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(azureRequestsTotal, azureRequestDuration, roleAssignmentRetries)
+}
+
+type operationKey struct{}
+
+// withOperation names the operation recorded for the requests sent with ctx
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// instrumentedSender returns a Sender that records every request it sends, retries included,
+// under the operation set on the request context
+func instrumentedSender(api string) autorest.Sender {
+	return autorest.CreateSender(func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			operation, ok := r.Context().Value(operationKey{}).(string)
+			if !ok {
+				operation = "unknown"
+			}
+
+			start := time.Now()
+			resp, err := s.Do(r)
+
+			// Requests that never got a response, such as timeouts, are recorded with code "error"
+			code := "error"
+			if resp != nil {
+				code = strconv.Itoa(resp.StatusCode)
+			}
+
+			azureRequestsTotal.WithLabelValues(api, operation, code).Inc()
+			azureRequestDuration.WithLabelValues(api, operation, code).Observe(time.Since(start).Seconds())
+			return resp, err
+		})
+	})
+}
@@ -0,0 +1,38 @@
+package azuread
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGraphClientRecordsRequests(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		writeJSON(t, w, http.StatusCreated, GraphServicePrincipal{ID: to.StringPtr("sp-object-id")})
+	})
+
+	created := azureRequestsTotal.WithLabelValues(apiGraph, "CreateServicePrincipal", "201")
+	denied := azureRequestsTotal.WithLabelValues(apiGraph, "DeleteApplication", "403")
+	createdBefore, deniedBefore := testutil.ToFloat64(created), testutil.ToFloat64(denied)
+
+	if _, err := client.CreateServicePrincipal(context.Background(), GraphServicePrincipal{AppID: to.StringPtr("client-id")}); err != nil {
+		t.Fatalf("CreateServicePrincipal: %v", err)
+	}
+	if err := client.DeleteApplication(context.Background(), "object-id"); err == nil {
+		t.Fatal("expected DeleteApplication to fail when denied")
+	}
+
+	if got := testutil.ToFloat64(created) - createdBefore; got != 1 {
+		t.Errorf("expected 1 CreateServicePrincipal request recorded with code 201, got %v", got)
+	}
+	if got := testutil.ToFloat64(denied) - deniedBefore; got != 1 {
+		t.Errorf("expected 1 DeleteApplication request recorded with code 403, got %v", got)
+	}
+}
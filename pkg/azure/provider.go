@@ -0,0 +1,308 @@
+package azuread
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+)
+
+// IdentityProvider manages the Azure AD and Azure Resource Manager objects that back an AzureIdentityTerminator.
+// Each method reads its input from the given App and records the objects it creates back onto it.
+type IdentityProvider interface {
+	// FindApplication looks up the Application registered for the App's OwnerID and reports whether it exists
+	FindApplication(ctx context.Context, app *App) (bool, error)
+	// CreateApplication registers a new Azure AD Application
+	CreateApplication(ctx context.Context, app *App) error
+	// UpdateApplication sets the display name of the Application to the App's DisplayName
+	UpdateApplication(ctx context.Context, app *App) error
+	// FindServicePrincipal looks up the Service Principal of the Application and reports whether it exists
+	FindServicePrincipal(ctx context.Context, app *App) (bool, error)
+	// GetApplication reports whether the Application with the App's ObjectID still exists
+	GetApplication(ctx context.Context, app *App) (bool, error)
+	// GetServicePrincipal reports whether the Service Principal with the App's ObjectID still exists
+	// and records the key IDs of its ClientSecrets onto the App
+	GetServicePrincipal(ctx context.Context, app *App) (bool, error)
+	// GetRoleAssignment reports whether the role assignment with the given ID still exists
+	GetRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) (bool, error)
+	// CreateServicePrincipal creates the Service Principal for the Application
+	CreateServicePrincipal(ctx context.Context, app *App) error
+	// UpdateServicePrincipal sets the tags of the Service Principal to the App's tags
+	UpdateServicePrincipal(ctx context.Context, app *App) error
+	// AddPassword adds a new ClientSecret to the Service Principal
+	AddPassword(ctx context.Context, app *App) error
+	// RemovePassword removes the ClientSecret with the given key ID from the Service Principal
+	RemovePassword(ctx context.Context, app *App, keyID string) error
+	// CreateFederatedIdentityCredential lets the Application trust the ServiceAccount tokens of the cluster.
+	// Creating a credential with the name of an existing one adopts it, updating it when it trusts other tokens.
+	CreateFederatedIdentityCredential(ctx context.Context, app *App) error
+	// GetFederatedIdentityCredential reports whether the federated identity credential with the ObjectID of fic still
+	// exists and records the issuer, subject and audiences it trusts onto fic
+	GetFederatedIdentityCredential(ctx context.Context, app *App, fic *FederatedIdentityCredential) (bool, error)
+	// UpdateFederatedIdentityCredential sets the issuer, subject and audiences of the federated identity credential
+	// to those of the App
+	UpdateFederatedIdentityCredential(ctx context.Context, app *App) error
+	// CreateManagedIdentity creates the user-assigned managed identity in its resource group and records
+	// its IDs onto the App. Creating a managed identity with the name of an existing one adopts it.
+	CreateManagedIdentity(ctx context.Context, app *App) error
+	// GetManagedIdentity reports whether the user-assigned managed identity still exists
+	GetManagedIdentity(ctx context.Context, app *App) (bool, error)
+	// DeleteManagedIdentity deletes the user-assigned managed identity
+	DeleteManagedIdentity(ctx context.Context, app *App) error
+	// CreateRoleAssignment assigns the Service Principal the role of the role assignment over its scope
+	// and records its name and ID onto it. Creating a role assignment with the name of an existing one
+	// does not create a duplicate.
+	CreateRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) error
+	// DeleteRoleAssignment deletes the role assignment with the given ID
+	DeleteRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) error
+	// DeleteApplication deletes the Azure AD Application and with it the Service Principal
+	DeleteApplication(ctx context.Context, app *App) error
+	// DisableServicePrincipal stops the Service Principal from signing in and removes its ClientSecrets and the
+	// federated identity credential of the Application, keeping the Application so that it can be restored
+	DisableServicePrincipal(ctx context.Context, app *App) error
+}
+
+// Credentials authorizes the requests sent to Microsoft Graph and Azure Resource Manager. It must be safe for
+// concurrent use, as concurrent reconciles share it.
+type Credentials interface {
+	GraphAuthorizer() (autorest.Authorizer, error)
+	ResourceManagementAuthorizer() (autorest.Authorizer, error)
+}
+
+// Provider is the IdentityProvider backed by Azure AD and Azure Resource Manager
+type Provider struct {
+	// Credentials authorizes every request the Provider sends
+	Credentials Credentials
+	// Environment holds the endpoints of the Azure cloud the Provider manages
+	Environment azure.Environment
+	// TenantID is the Azure AD tenant Applications are registered in
+	TenantID string
+	// SubscriptionID is the subscription role assignments and managed identities are created in
+	SubscriptionID string
+	// Timeout bounds each operation that OperationTimeouts sets no deadline for. Zero leaves it unbounded.
+	Timeout time.Duration
+	// OperationTimeouts bounds the operations named after the methods of IdentityProvider, such as CreateRoleAssignment
+	OperationTimeouts map[string]time.Duration
+}
+
+var _ IdentityProvider = Provider{}
+
+// ValidateOperationTimeouts checks that the operationTimeouts of the configuration name methods of IdentityProvider,
+// such as CreateRoleAssignment
+func ValidateOperationTimeouts(c config.Azure, path *field.Path) field.ErrorList {
+	operations := reflect.TypeOf((*IdentityProvider)(nil)).Elem()
+	names := make([]string, 0, len(c.OperationTimeouts))
+	for name := range c.OperationTimeouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var allErrs field.ErrorList
+	for _, name := range names {
+		if _, ok := operations.MethodByName(name); !ok {
+			allErrs = append(allErrs, field.NotSupported(path.Child("operationTimeouts"), name, operationNames(operations)))
+		}
+	}
+	return allErrs
+}
+
+// operationNames returns the names of the operations of IdentityProvider
+func operationNames(operations reflect.Type) []string {
+	names := make([]string, operations.NumMethod())
+	for i := range names {
+		names[i] = operations.Method(i).Name
+	}
+	return names
+}
+
+// operationContext returns the context an operation runs with, which is bounded by the deadline configured for the
+// operation. The returned cancel func must be called once the operation completes.
+func (p Provider) operationContext(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout, ok := p.OperationTimeouts[operation]
+	if !ok {
+		timeout = p.Timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// errNoCredentials is returned by operations run without Credentials
+var errNoCredentials = errors.New("no credentials to authorize requests to Azure with")
+
+// graphAuthorizer returns the authorizer for Microsoft Graph of the Provider's Credentials
+func (p Provider) graphAuthorizer() (autorest.Authorizer, error) {
+	if p.Credentials == nil {
+		return nil, errNoCredentials
+	}
+	return p.Credentials.GraphAuthorizer()
+}
+
+// resourceManagementAuthorizer returns the authorizer for Azure Resource Manager of the Provider's Credentials
+func (p Provider) resourceManagementAuthorizer() (autorest.Authorizer, error) {
+	if p.Credentials == nil {
+		return nil, errNoCredentials
+	}
+	return p.Credentials.ResourceManagementAuthorizer()
+}
+
+// FindApplication looks up the Application registered for the App's OwnerID
+func (p Provider) FindApplication(ctx context.Context, app *App) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "FindApplication")
+	defer cancel()
+	return app.FindAzureADApp(ctx, p)
+}
+
+// CreateApplication registers a new Azure AD Application
+func (p Provider) CreateApplication(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "CreateApplication")
+	defer cancel()
+	_, err := app.CreateAzureADApp(ctx, p)
+	return err
+}
+
+// UpdateApplication sets the display name of the Application to the App's DisplayName
+func (p Provider) UpdateApplication(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "UpdateApplication")
+	defer cancel()
+	return app.UpdateAzureADApp(ctx, p)
+}
+
+// FindServicePrincipal looks up the Service Principal of the Application
+func (p Provider) FindServicePrincipal(ctx context.Context, app *App) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "FindServicePrincipal")
+	defer cancel()
+	return app.FindServicePrincipal(ctx, p)
+}
+
+// GetApplication reports whether the Application with the App's ObjectID still exists
+func (p Provider) GetApplication(ctx context.Context, app *App) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "GetApplication")
+	defer cancel()
+	return app.GetAzureADApp(ctx, p)
+}
+
+// GetServicePrincipal reports whether the Service Principal with the App's ObjectID still exists
+func (p Provider) GetServicePrincipal(ctx context.Context, app *App) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "GetServicePrincipal")
+	defer cancel()
+	return app.GetServicePrincipal(ctx, p)
+}
+
+// GetRoleAssignment reports whether the role assignment with the given ID still exists
+func (p Provider) GetRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "GetRoleAssignment")
+	defer cancel()
+	return app.GetRoleAssignment(ctx, p, ra)
+}
+
+// CreateServicePrincipal creates the Service Principal for the Application
+func (p Provider) CreateServicePrincipal(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "CreateServicePrincipal")
+	defer cancel()
+	_, err := app.CreateServicePrincipal(ctx, p)
+	return err
+}
+
+// UpdateServicePrincipal sets the tags of the Service Principal to the App's tags
+func (p Provider) UpdateServicePrincipal(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "UpdateServicePrincipal")
+	defer cancel()
+	return app.UpdateServicePrincipal(ctx, p)
+}
+
+// AddPassword adds a new ClientSecret to the Service Principal
+func (p Provider) AddPassword(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "AddPassword")
+	defer cancel()
+	_, err := app.AddPassword(ctx, p)
+	return err
+}
+
+// RemovePassword removes the ClientSecret with the given key ID from the Service Principal
+func (p Provider) RemovePassword(ctx context.Context, app *App, keyID string) error {
+	ctx, cancel := p.operationContext(ctx, "RemovePassword")
+	defer cancel()
+	return app.RemovePassword(ctx, p, keyID)
+}
+
+// CreateFederatedIdentityCredential lets the Application trust the ServiceAccount tokens of the cluster
+func (p Provider) CreateFederatedIdentityCredential(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "CreateFederatedIdentityCredential")
+	defer cancel()
+	_, err := app.CreateFederatedIdentityCredential(ctx, p)
+	return err
+}
+
+// GetFederatedIdentityCredential reports whether the federated identity credential still exists and what it trusts
+func (p Provider) GetFederatedIdentityCredential(ctx context.Context, app *App, fic *FederatedIdentityCredential) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "GetFederatedIdentityCredential")
+	defer cancel()
+	return app.GetFederatedIdentityCredential(ctx, p, fic)
+}
+
+// UpdateFederatedIdentityCredential sets the tokens the federated identity credential trusts to those of the App
+func (p Provider) UpdateFederatedIdentityCredential(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "UpdateFederatedIdentityCredential")
+	defer cancel()
+	return app.UpdateFederatedIdentityCredential(ctx, p)
+}
+
+// CreateManagedIdentity creates the user-assigned managed identity in its resource group
+func (p Provider) CreateManagedIdentity(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "CreateManagedIdentity")
+	defer cancel()
+	_, err := app.CreateManagedIdentity(ctx, p)
+	return err
+}
+
+// GetManagedIdentity reports whether the user-assigned managed identity still exists
+func (p Provider) GetManagedIdentity(ctx context.Context, app *App) (bool, error) {
+	ctx, cancel := p.operationContext(ctx, "GetManagedIdentity")
+	defer cancel()
+	return app.GetManagedIdentity(ctx, p)
+}
+
+// DeleteManagedIdentity deletes the user-assigned managed identity
+func (p Provider) DeleteManagedIdentity(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "DeleteManagedIdentity")
+	defer cancel()
+	return app.DeleteManagedIdentity(ctx, p)
+}
+
+// CreateRoleAssignment assigns the Service Principal the role of the role assignment over its scope
+func (p Provider) CreateRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) error {
+	ctx, cancel := p.operationContext(ctx, "CreateRoleAssignment")
+	defer cancel()
+	return app.CreateRoleAssignment(ctx, p, ra)
+}
+
+// DeleteRoleAssignment deletes the role assignment with the given ID
+func (p Provider) DeleteRoleAssignment(ctx context.Context, app *App, ra *RoleAssignment) error {
+	ctx, cancel := p.operationContext(ctx, "DeleteRoleAssignment")
+	defer cancel()
+	_, err := app.DeleteRoleAssignment(ctx, p, ra)
+	return err
+}
+
+// DeleteApplication deletes the Azure AD Application and with it the Service Principal
+func (p Provider) DeleteApplication(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "DeleteApplication")
+	defer cancel()
+	return app.DeleteAzureApp(ctx, p)
+}
+
+// DisableServicePrincipal stops the Service Principal from signing in and removes its credentials
+func (p Provider) DisableServicePrincipal(ctx context.Context, app *App) error {
+	ctx, cancel := p.operationContext(ctx, "DisableServicePrincipal")
+	defer cancel()
+	return app.DisableServicePrincipal(ctx, p)
+}
@@ -0,0 +1,100 @@
+package azuread
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+)
+
+func TestValidateOperationTimeouts(t *testing.T) {
+	c := config.Azure{OperationTimeouts: map[string]metav1.Duration{
+		"CreateRoleAssignment": {Duration: 2 * time.Minute},
+		"DeleteApplication":    {Duration: 30 * time.Second},
+	}}
+	if errs := ValidateOperationTimeouts(c, field.NewPath("azure")); len(errs) > 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	c.OperationTimeouts["Reconcile"] = metav1.Duration{Duration: time.Minute}
+	errs := ValidateOperationTimeouts(c, field.NewPath("azure"))
+	if len(errs) != 1 || errs[0].Field != "azure.operationTimeouts" || errs[0].BadValue != "Reconcile" {
+		t.Errorf("expected Reconcile to be rejected, got %v", errs)
+	}
+}
+
+func TestProviderOperationContext(t *testing.T) {
+	p := Provider{
+		Timeout:           time.Minute,
+		OperationTimeouts: map[string]time.Duration{"DeleteApplication": time.Hour, "GetApplication": 0},
+	}
+
+	tests := []struct {
+		operation string
+		timeout   time.Duration
+	}{
+		{operation: "CreateApplication", timeout: time.Minute},
+		{operation: "DeleteApplication", timeout: time.Hour},
+		{operation: "GetApplication", timeout: 0},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := p.operationContext(context.Background(), tt.operation)
+		deadline, ok := ctx.Deadline()
+		cancel()
+
+		if tt.timeout == 0 {
+			if ok {
+				t.Errorf("%s: expected no deadline, got %v", tt.operation, deadline)
+			}
+			continue
+		}
+		if !ok || time.Until(deadline) > tt.timeout || time.Until(deadline) < tt.timeout-time.Second {
+			t.Errorf("%s: expected a deadline in %v, got %v", tt.operation, tt.timeout, deadline)
+		}
+	}
+}
+
+// failingCredentials fails to authorize any request
+type failingCredentials struct{}
+
+func (failingCredentials) GraphAuthorizer() (autorest.Authorizer, error) {
+	return nil, errors.New("graph credentials unavailable")
+}
+
+func (failingCredentials) ResourceManagementAuthorizer() (autorest.Authorizer, error) {
+	return nil, errors.New("arm credentials unavailable")
+}
+
+func TestProviderReturnsCredentialErrors(t *testing.T) {
+	app := &App{OwnerID: "uid", RoleAssignments: []RoleAssignment{{Role: "Reader", Scope: "rg"}}}
+
+	if _, err := (Provider{}).FindApplication(context.Background(), app); err != errNoCredentials {
+		t.Errorf("expected errNoCredentials without credentials, got %v", err)
+	}
+
+	p := Provider{Credentials: failingCredentials{}}
+	if _, err := p.FindApplication(context.Background(), app); err == nil || err.Error() != "graph credentials unavailable" {
+		t.Errorf("expected the Microsoft Graph credential error, got %v", err)
+	}
+	if err := p.CreateRoleAssignment(context.Background(), app, &app.RoleAssignments[0]); err == nil || err.Error() != "arm credentials unavailable" {
+		t.Errorf("expected the Azure Resource Manager credential error, got %v", err)
+	}
+}
+
+func TestProviderRoleDefinitionID(t *testing.T) {
+	p := Provider{SubscriptionID: "sub"}
+	id, err := p.roleDefinitionID(context.Background(), "acdd72a7-3385-48ef-bd42-f606fba81ae7", "/subscriptions/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "/subscriptions/sub/providers/Microsoft.Authorization/roleDefinitions/acdd72a7-3385-48ef-bd42-f606fba81ae7" {
+		t.Errorf("expected the role definition in the subscription of the Provider, got %s", id)
+	}
+}
@@ -0,0 +1,564 @@
+// Package config loads the configuration of the controller once at startup, from the ControllerManagerConfig file,
+// the environment and the command line.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	componentconfig "k8s.io/component-base/config/v1alpha1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the version of the controller, set when it is built with
+// -ldflags "-X github.com/tonedefdev/azure-identity-terminator/pkg/config.Version=<version>"
+var Version = "dev"
+
+// UserAgent identifies the controller and its version in every request it sends to Azure
+func UserAgent() string {
+	return "azure-identity-terminator/" + Version
+}
+
+// GroupVersion is the apiVersion of the ControllerManagerConfig file
+var GroupVersion = schema.GroupVersion{Group: "config.azidterminator.io", Version: "v1alpha1"}
+
+// Kind is the kind of the ControllerManagerConfig file
+const Kind = "ControllerManagerConfig"
+
+// ControllerManagerConfig is the configuration of the controller. It embeds the configuration of the controller
+// manager, so that it can be passed to ctrl.Options.AndFrom.
+type ControllerManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Azure configures the tenant and subscription the controller manages and how it authenticates to them
+	Azure Azure `json:"azure,omitempty"`
+	// Admission configures the admission webhooks for AzureIdentityTerminators
+	Admission Admission `json:"admission,omitempty"`
+	// Defaults are the values the defaulting webhook fills into AzureIdentityTerminators that leave them out
+	Defaults Defaults `json:"defaults,omitempty"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *ControllerManagerConfig) DeepCopyObject() runtime.Object {
+	out := new(ControllerManagerConfig)
+	*out = *in
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	if in.Azure.OperationTimeouts != nil {
+		out.Azure.OperationTimeouts = make(map[string]metav1.Duration, len(in.Azure.OperationTimeouts))
+		for k, v := range in.Azure.OperationTimeouts {
+			out.Azure.OperationTimeouts[k] = v
+		}
+	}
+	out.Defaults.Tags = append([]string(nil), in.Defaults.Tags...)
+	return out
+}
+
+// Azure is the Azure configuration of the controller
+type Azure struct {
+	// Cloud names the Azure cloud, such as AzurePublicCloud or AzureUSGovernmentCloud
+	Cloud string `json:"cloud,omitempty"`
+	// TenantID is the Azure AD tenant the controller registers applications in
+	TenantID string `json:"tenantID,omitempty"`
+	// SubscriptionID is the subscription role assignments and managed identities are created in
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	// AuthMode is how the controller authenticates to Azure AD, one of the iam.AuthMode values
+	AuthMode string `json:"authMode,omitempty"`
+	// ClientID is the application, or user-assigned managed identity, the controller authenticates as
+	ClientID string `json:"clientID,omitempty"`
+	// ClientSecret is only read from the environment, so that it never ends up in the file
+	ClientSecret string `json:"-"`
+	// ClientCertificatePath is the PEM or PFX file holding the client certificate and its private key
+	ClientCertificatePath string `json:"clientCertificatePath,omitempty"`
+	// ClientCertificatePassword decrypts a PFX file. Like ClientSecret, it is only read from the environment.
+	ClientCertificatePassword string `json:"-"`
+	// FederatedTokenFile is the projected service account token exchanged for an Azure AD token in workload identity
+	FederatedTokenFile string `json:"federatedTokenFile,omitempty"`
+	// OIDCIssuerURL is the OIDC issuer of the cluster trusted by federated identity credentials in workloadIdentity mode
+	OIDCIssuerURL string `json:"oidcIssuerURL,omitempty"`
+	// ManagedIdentityResourceGroup is the resource group user-assigned managed identities are created in
+	ManagedIdentityResourceGroup string `json:"managedIdentityResourceGroup,omitempty"`
+	// ManagedIdentityLocation is the Azure region user-assigned managed identities are created in
+	ManagedIdentityLocation string `json:"managedIdentityLocation,omitempty"`
+	// ResyncInterval is how often the Azure objects of each AzureIdentityTerminator are audited. Zero disables the audit.
+	ResyncInterval metav1.Duration `json:"resyncInterval,omitempty"`
+	// Timeout bounds each call to Azure AD or Azure Resource Manager. Zero disables the deadline.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// OperationTimeouts override Timeout for single operations, named after the methods of azuread.IdentityProvider
+	OperationTimeouts map[string]metav1.Duration `json:"operationTimeouts,omitempty"`
+}
+
+// OperationTimeoutDurations returns the OperationTimeouts as durations
+func (a Azure) OperationTimeoutDurations() map[string]time.Duration {
+	timeouts := make(map[string]time.Duration, len(a.OperationTimeouts))
+	for operation, timeout := range a.OperationTimeouts {
+		timeouts[operation] = timeout.Duration
+	}
+	return timeouts
+}
+
+// Admission is the configuration of the admission webhooks
+type Admission struct {
+	// Enabled registers the defaulting and validating webhooks. They are disabled when running outside the cluster.
+	Enabled bool `json:"enabled"`
+	// MinClientSecretDuration is the shortest clientSecretDuration the validating webhook accepts. Zero disables the check.
+	MinClientSecretDuration metav1.Duration `json:"minClientSecretDuration,omitempty"`
+	// MaxClientSecretDuration is the longest clientSecretDuration the validating webhook accepts. Zero disables the check.
+	MaxClientSecretDuration metav1.Duration `json:"maxClientSecretDuration,omitempty"`
+}
+
+// Defaults are the operator-wide values filled into AzureIdentityTerminators
+type Defaults struct {
+	// ClusterName prefixes the display name of AzureIdentityTerminators that do not set one
+	ClusterName string `json:"clusterName,omitempty"`
+	// ClientSecretDuration is the clientSecretDuration of AzureIdentityTerminators that do not set one
+	ClientSecretDuration string `json:"clientSecretDuration,omitempty"`
+	// NodeResourceGroup is the nodeResourceGroup of AzureIdentityTerminators that do not set one
+	NodeResourceGroup string `json:"nodeResourceGroup,omitempty"`
+	// Tags are the Service Principal tags of AzureIdentityTerminators that do not set any
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Environment returns the endpoints of the Azure cloud
+func (a Azure) Environment() (azure.Environment, error) {
+	return azure.EnvironmentFromName(a.Cloud)
+}
+
+// microsoftGraphEndpoints maps each cloud to its Microsoft Graph endpoint, which
+// `azure.Environment` only knows the retired Azure AD Graph endpoint for.
+var microsoftGraphEndpoints = map[string]string{
+	azure.PublicCloud.Name:       "https://graph.microsoft.com/",
+	azure.USGovernmentCloud.Name: "https://graph.microsoft.us/",
+	azure.ChinaCloud.Name:        "https://microsoftgraph.chinacloudapi.cn/",
+	azure.GermanCloud.Name:       "https://graph.microsoft.de/",
+}
+
+// MicrosoftGraphEndpoint returns the Microsoft Graph endpoint of the cloud env belongs to
+func MicrosoftGraphEndpoint(env azure.Environment) string {
+	return microsoftGraphEndpoints[env.Name]
+}
+
+// setting is a field of the configuration that can be set in the file, with an environment variable and with a
+// flag, each overriding the last. Settings without a flag are secrets, which are only read from the environment.
+type setting struct {
+	path  string
+	env   string
+	flag  string
+	usage string
+	// value returns a pointer to the field, a string, bool, metav1.Duration, []string or map[string]metav1.Duration
+	value func(c *ControllerManagerConfig) interface{}
+}
+
+var settings = []setting{
+	{
+		path: "metrics.bindAddress", flag: "metrics-bind-address",
+		usage: "The address the metric endpoint binds to. Defaults to :8080.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Metrics.BindAddress },
+	},
+	{
+		path: "health.healthProbeBindAddress", flag: "health-probe-bind-address",
+		usage: "The address the probe endpoint binds to. Defaults to :8081.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Health.HealthProbeBindAddress },
+	},
+	{
+		path: "azure.cloud", env: "AZURE_ENVIRONMENT", flag: "azure-cloud",
+		usage: "The Azure cloud the controller manages: AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud or AzureGermanCloud. Defaults to AzurePublicCloud.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.Cloud },
+	},
+	{
+		path: "azure.tenantID", env: "AZURE_TENANT_ID", flag: "azure-tenant-id",
+		usage: "The Azure AD tenant the controller registers applications in.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.TenantID },
+	},
+	{
+		path: "azure.subscriptionID", env: "AZURE_SUBSCRIPTION_ID", flag: "azure-subscription-id",
+		usage: "The subscription role assignments and managed identities are created in.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.SubscriptionID },
+	},
+	{
+		path: "azure.authMode", env: "AZURE_AUTH_MODE", flag: "azure-auth-mode",
+		usage: "How the controller authenticates to Azure AD: clientSecret, clientCertificate, managedIdentity, workloadIdentity or azureCLI. Defaults to clientSecret.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.AuthMode },
+	},
+	{
+		path: "azure.clientID", env: "AZURE_CLIENT_ID", flag: "azure-client-id",
+		usage: "The application, or user-assigned managed identity, the controller authenticates as.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ClientID },
+	},
+	{
+		path: "azure.clientSecret", env: "AZURE_CLIENT_SECRET",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ClientSecret },
+	},
+	{
+		path: "azure.clientCertificatePath", env: "AZURE_CLIENT_CERTIFICATE_PATH", flag: "azure-client-certificate-path",
+		usage: "The PEM or PFX file holding the client certificate and its private key in clientCertificate mode.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ClientCertificatePath },
+	},
+	{
+		path: "azure.clientCertificatePassword", env: "AZURE_CLIENT_CERTIFICATE_PASSWORD",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ClientCertificatePassword },
+	},
+	{
+		path: "azure.federatedTokenFile", env: "AZURE_FEDERATED_TOKEN_FILE", flag: "azure-federated-token-file",
+		usage: "The projected service account token exchanged for an Azure AD token in workloadIdentity mode.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.FederatedTokenFile },
+	},
+	{
+		path: "azure.oidcIssuerURL", env: "OIDC_ISSUER_URL", flag: "oidc-issuer-url",
+		usage: "The OIDC issuer URL of the cluster, trusted by federated identity credentials in workloadIdentity mode.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.OIDCIssuerURL },
+	},
+	{
+		path: "azure.managedIdentityResourceGroup", env: "MANAGED_IDENTITY_RESOURCE_GROUP", flag: "managed-identity-resource-group",
+		usage: "The resource group user-assigned managed identities are created in for terminators with identityType UserAssignedMSI.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ManagedIdentityResourceGroup },
+	},
+	{
+		path: "azure.managedIdentityLocation", env: "MANAGED_IDENTITY_LOCATION", flag: "managed-identity-location",
+		usage: "The Azure region user-assigned managed identities are created in.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ManagedIdentityLocation },
+	},
+	{
+		path: "azure.resyncInterval", env: "AZURE_RESYNC_INTERVAL", flag: "azure-resync-interval",
+		usage: "How often the Azure objects of each AzureIdentityTerminator are checked for changes made outside the cluster. Zero disables the check. Defaults to 1h.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.ResyncInterval },
+	},
+	{
+		path: "azure.timeout", env: "AZURE_TIMEOUT", flag: "azure-timeout",
+		usage: "How long each call to Azure AD or Azure Resource Manager may take before it is cancelled. Zero disables the deadline. Defaults to 30s.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.Timeout },
+	},
+	{
+		path: "azure.operationTimeouts", env: "AZURE_OPERATION_TIMEOUTS", flag: "azure-operation-timeouts",
+		usage: "A comma separated list of operation=duration pairs overriding --azure-timeout for single operations, such as CreateRoleAssignment=2m.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Azure.OperationTimeouts },
+	},
+	{
+		path: "admission.enabled", env: "ENABLE_WEBHOOKS", flag: "enable-webhooks",
+		usage: "Register the defaulting and validating webhooks. Defaults to true.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Admission.Enabled },
+	},
+	{
+		path: "admission.minClientSecretDuration", env: "MIN_CLIENT_SECRET_DURATION", flag: "min-client-secret-duration",
+		usage: "The shortest clientSecretDuration the validating webhook accepts. Zero disables the check. Defaults to 1h.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Admission.MinClientSecretDuration },
+	},
+	{
+		path: "admission.maxClientSecretDuration", env: "MAX_CLIENT_SECRET_DURATION", flag: "max-client-secret-duration",
+		usage: "The longest clientSecretDuration the validating webhook accepts. Zero disables the check. Defaults to 17520h.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Admission.MaxClientSecretDuration },
+	},
+	{
+		path: "defaults.clusterName", env: "CLUSTER_NAME", flag: "cluster-name",
+		usage: "The name of the cluster, used to prefix the display name of AzureIdentityTerminators that do not set one.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Defaults.ClusterName },
+	},
+	{
+		path: "defaults.clientSecretDuration", env: "DEFAULT_CLIENT_SECRET_DURATION", flag: "default-client-secret-duration",
+		usage: "The clientSecretDuration of AzureIdentityTerminators that do not set one.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Defaults.ClientSecretDuration },
+	},
+	{
+		path: "defaults.nodeResourceGroup", env: "DEFAULT_NODE_RESOURCE_GROUP", flag: "default-node-resource-group",
+		usage: "The nodeResourceGroup of AzureIdentityTerminators that do not set one.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Defaults.NodeResourceGroup },
+	},
+	{
+		path: "defaults.tags", env: "DEFAULT_TAGS", flag: "default-tags",
+		usage: "A comma separated list of Service Principal tags for AzureIdentityTerminators that do not set any.",
+		value: func(c *ControllerManagerConfig) interface{} { return &c.Defaults.Tags },
+	},
+}
+
+// set parses value into the field of the setting
+func (s setting) set(c *ControllerManagerConfig, value string) error {
+	switch dst := s.value(c).(type) {
+	case *string:
+		*dst = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*dst = b
+	case *metav1.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		dst.Duration = d
+	case *[]string:
+		*dst = splitList(value)
+	case *map[string]metav1.Duration:
+		durations := map[string]metav1.Duration{}
+		for _, pair := range splitList(value) {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%q is not of the form operation=duration", pair)
+			}
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return fmt.Errorf("timeout of operation %s: %w", parts[0], err)
+			}
+			durations[parts[0]] = metav1.Duration{Duration: d}
+		}
+		*dst = durations
+	default:
+		panic("unsupported type of setting " + s.path)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, leaving out empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Missing returns the error for a required setting that is not set, which names where it can be set
+func Missing(path *field.Path) *field.Error {
+	for _, s := range settings {
+		if s.path != path.String() {
+			continue
+		}
+		if s.flag == "" {
+			return field.Required(path, "set it with "+s.env)
+		}
+		if s.env == "" {
+			return field.Required(path, "set it in the config file or with --"+s.flag)
+		}
+		return field.Required(path, "set it in the config file, with "+s.env+" or with --"+s.flag)
+	}
+	return field.Required(path, "")
+}
+
+// Flags are the command line flags the configuration is loaded from
+type Flags struct {
+	// File is the ControllerManagerConfig file, if any
+	File string
+
+	fs          *flag.FlagSet
+	leaderElect bool
+	values      map[string]*string
+}
+
+// BindFlags binds the flags of the configuration to fs
+func (f *Flags) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.File, "config", "",
+		"The ControllerManagerConfig file to load the configuration from. Environment variables and flags override its settings.")
+	fs.BoolVar(&f.leaderElect, "leader-elect", false,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+
+	f.values = map[string]*string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			f.values[s.flag] = fs.String(s.flag, "", s.usage)
+		}
+	}
+	f.fs = fs
+}
+
+// Load loads the configuration from the defaults, the ControllerManagerConfig file, the environment and finally the
+// flags that are set, each overriding the settings of the last. It must be called once, after the flags are parsed.
+func (f *Flags) Load() (*ControllerManagerConfig, error) {
+	c := defaultConfig()
+	if f.File != "" {
+		if err := loadFile(f.File, c); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []string
+	for _, s := range settings {
+		if v := os.Getenv(s.env); s.env != "" && v != "" {
+			if err := s.set(c, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+
+	if f.fs != nil {
+		f.fs.Visit(func(fl *flag.Flag) {
+			if fl.Name == "leader-elect" {
+				leaderElect := f.leaderElect
+				c.LeaderElection.LeaderElect = &leaderElect
+			}
+			for _, s := range settings {
+				if s.flag == fl.Name {
+					if err := s.set(c, *f.values[s.flag]); err != nil {
+						errs = append(errs, fmt.Sprintf("--%s: %v", s.flag, err))
+					}
+				}
+			}
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid settings: %s", strings.Join(errs, "; "))
+	}
+	return c, nil
+}
+
+// defaultConfig returns the configuration that applies when nothing else is set
+func defaultConfig() *ControllerManagerConfig {
+	port := 9443
+	leaderElect := false
+	return &ControllerManagerConfig{
+		TypeMeta: metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: Kind},
+		ControllerManagerConfigurationSpec: cfg.ControllerManagerConfigurationSpec{
+			LeaderElection: &componentconfig.LeaderElectionConfiguration{
+				LeaderElect:  &leaderElect,
+				ResourceName: "ccc00a1c.k8s.io",
+			},
+			Metrics: cfg.ControllerMetrics{BindAddress: ":8080"},
+			Health:  cfg.ControllerHealth{HealthProbeBindAddress: ":8081"},
+			Webhook: cfg.ControllerWebhook{Port: &port},
+		},
+		Azure: Azure{
+			Cloud:          azure.PublicCloud.Name,
+			ResyncInterval: metav1.Duration{Duration: time.Hour},
+			Timeout:        metav1.Duration{Duration: 30 * time.Second},
+		},
+		Admission: Admission{
+			Enabled:                 true,
+			MinClientSecretDuration: metav1.Duration{Duration: time.Hour},
+			MaxClientSecretDuration: metav1.Duration{Duration: 2 * 365 * 24 * time.Hour},
+		},
+	}
+}
+
+// loadFile overrides the settings of c with those of the ControllerManagerConfig file at path
+func loadFile(path string, c *ControllerManagerConfig) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	// Unknown settings are rejected rather than ignored, which also keeps secrets out of the file
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("decoding config file %s: %w", path, err)
+	}
+	if gvk := c.GroupVersionKind(); gvk != GroupVersion.WithKind(Kind) {
+		return fmt.Errorf("config file %s holds a %s, not a %s", path, gvk, GroupVersion.WithKind(Kind))
+	}
+
+	// ctrl.Options.AndFrom does not expect leader election to be left out
+	if c.LeaderElection == nil {
+		c.LeaderElection = defaultConfig().LeaderElection
+	}
+	return nil
+}
+
+// Validate returns every missing or invalid setting, so that they can all be fixed at once. The settings that
+// depend on the auth mode are validated by iam.ValidateCredentials, and the operations named in
+// azure.operationTimeouts by azuread.ValidateOperationTimeouts.
+func (c *ControllerManagerConfig) Validate() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if c.LeaderElection != nil && c.LeaderElection.LeaderElect != nil && *c.LeaderElection.LeaderElect && c.LeaderElection.ResourceName == "" {
+		allErrs = append(allErrs, field.Required(field.NewPath("leaderElection", "resourceName"), "leader election needs a resource name"))
+	}
+	if port := c.Webhook.Port; port != nil && (*port < 1 || *port > 65535) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("webhook", "port"), *port, "must be between 1 and 65535"))
+	}
+
+	azurePath := field.NewPath("azure")
+	if _, ok := microsoftGraphEndpoints[c.Azure.Cloud]; !ok {
+		clouds := make([]string, 0, len(microsoftGraphEndpoints))
+		for cloud := range microsoftGraphEndpoints {
+			clouds = append(clouds, cloud)
+		}
+		sort.Strings(clouds)
+		allErrs = append(allErrs, field.NotSupported(azurePath.Child("cloud"), c.Azure.Cloud, clouds))
+	}
+
+	if c.Azure.TenantID == "" {
+		allErrs = append(allErrs, Missing(azurePath.Child("tenantID")))
+	}
+
+	if c.Azure.SubscriptionID == "" {
+		allErrs = append(allErrs, Missing(azurePath.Child("subscriptionID")))
+	} else if _, err := uuid.Parse(c.Azure.SubscriptionID); err != nil {
+		allErrs = append(allErrs, field.Invalid(azurePath.Child("subscriptionID"), c.Azure.SubscriptionID, "must be a GUID"))
+	}
+
+	if c.Azure.OIDCIssuerURL != "" {
+		if u, err := url.Parse(c.Azure.OIDCIssuerURL); err != nil || !strings.EqualFold(u.Scheme, "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(azurePath.Child("oidcIssuerURL"), c.Azure.OIDCIssuerURL, "must be an https URL"))
+		}
+	}
+
+	allErrs = append(allErrs, notNegative(azurePath.Child("resyncInterval"), c.Azure.ResyncInterval)...)
+	allErrs = append(allErrs, notNegative(azurePath.Child("timeout"), c.Azure.Timeout)...)
+	operations := make([]string, 0, len(c.Azure.OperationTimeouts))
+	for operation := range c.Azure.OperationTimeouts {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		allErrs = append(allErrs, notNegative(azurePath.Child("operationTimeouts").Key(operation), c.Azure.OperationTimeouts[operation])...)
+	}
+
+	allErrs = append(allErrs, c.Admission.validate(field.NewPath("admission"))...)
+	allErrs = append(allErrs, c.Defaults.validate(field.NewPath("defaults"), c.Admission)...)
+	return allErrs
+}
+
+// validate checks the bounds of the clientSecretDuration are not negative and do not cross
+func (a Admission) validate(path *field.Path) field.ErrorList {
+	allErrs := notNegative(path.Child("minClientSecretDuration"), a.MinClientSecretDuration)
+	allErrs = append(allErrs, notNegative(path.Child("maxClientSecretDuration"), a.MaxClientSecretDuration)...)
+	if min, max := a.MinClientSecretDuration.Duration, a.MaxClientSecretDuration.Duration; min > 0 && max > 0 && min > max {
+		allErrs = append(allErrs, field.Invalid(path.Child("maxClientSecretDuration"), max.String(), "must not be shorter than "+path.Child("minClientSecretDuration").String()+" "+min.String()))
+	}
+	return allErrs
+}
+
+// validate checks the default clientSecretDuration is one the validating webhook accepts
+func (d Defaults) validate(path *field.Path, admission Admission) field.ErrorList {
+	if d.ClientSecretDuration == "" {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(d.ClientSecretDuration)
+	switch {
+	case err != nil:
+		return field.ErrorList{field.Invalid(path.Child("clientSecretDuration"), d.ClientSecretDuration, err.Error())}
+	case duration <= 0:
+		return field.ErrorList{field.Invalid(path.Child("clientSecretDuration"), d.ClientSecretDuration, "must be greater than zero")}
+	case admission.MinClientSecretDuration.Duration > 0 && duration < admission.MinClientSecretDuration.Duration:
+		return field.ErrorList{field.Invalid(path.Child("clientSecretDuration"), d.ClientSecretDuration, "must be at least "+admission.MinClientSecretDuration.Duration.String())}
+	case admission.MaxClientSecretDuration.Duration > 0 && duration > admission.MaxClientSecretDuration.Duration:
+		return field.ErrorList{field.Invalid(path.Child("clientSecretDuration"), d.ClientSecretDuration, "must be at most "+admission.MaxClientSecretDuration.Duration.String())}
+	}
+	return nil
+}
+
+// notNegative checks a duration is zero or greater
+func notNegative(path *field.Path, d metav1.Duration) field.ErrorList {
+	if d.Duration < 0 {
+		return field.ErrorList{field.Invalid(path, d.Duration.String(), "must not be negative")}
+	}
+	return nil
+}
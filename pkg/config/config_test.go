@@ -0,0 +1,283 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const header = "apiVersion: config.azidterminator.io/v1alpha1\nkind: ControllerManagerConfig\n"
+
+// writeConfigFile writes a config file holding data and returns its path
+func writeConfigFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "controller_manager_config.yaml")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setEnv sets the environment variable key to value until the test completes
+func setEnv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfigFile(t, header+`
+metrics:
+  bindAddress: 127.0.0.1:8080
+leaderElection:
+  leaderElect: true
+azure:
+  tenantID: file-tenant
+  subscriptionID: file-subscription
+  clientID: file-client
+  timeout: 1m
+  operationTimeouts:
+    DeleteApplication: 2m
+admission:
+  enabled: false
+  minClientSecretDuration: 2h
+defaults:
+  clusterName: file-cluster
+  nodeResourceGroup: file-nodes
+  tags: [file]
+`)
+	setEnv(t, "AZURE_SUBSCRIPTION_ID", "env-subscription")
+	setEnv(t, "AZURE_CLIENT_ID", "env-client")
+	setEnv(t, "AZURE_CLIENT_SECRET", "env-secret")
+	setEnv(t, "AZURE_RESYNC_INTERVAL", "10m")
+	setEnv(t, "ENABLE_WEBHOOKS", "true")
+	setEnv(t, "CLUSTER_NAME", "env-cluster")
+	setEnv(t, "DEFAULT_TAGS", "team=a, env=dev")
+
+	var f Flags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.BindFlags(fs)
+	if err := fs.Parse([]string{"--config=" + path, "--azure-client-id=flag-client", "--leader-elect=false",
+		"--azure-operation-timeouts=CreateRoleAssignment=5m", "--max-client-secret-duration=720h", "--cluster-name=flag-cluster",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := f.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags override the environment, which overrides the file, which overrides the defaults
+	tests := []struct {
+		setting string
+		got     string
+		want    string
+	}{
+		{setting: "metrics.bindAddress", got: c.Metrics.BindAddress, want: "127.0.0.1:8080"},
+		{setting: "health.healthProbeBindAddress", got: c.Health.HealthProbeBindAddress, want: ":8081"},
+		{setting: "leaderElection.resourceName", got: c.LeaderElection.ResourceName, want: "ccc00a1c.k8s.io"},
+		{setting: "azure.cloud", got: c.Azure.Cloud, want: "AzurePublicCloud"},
+		{setting: "azure.tenantID", got: c.Azure.TenantID, want: "file-tenant"},
+		{setting: "azure.subscriptionID", got: c.Azure.SubscriptionID, want: "env-subscription"},
+		{setting: "azure.clientID", got: c.Azure.ClientID, want: "flag-client"},
+		{setting: "azure.clientSecret", got: c.Azure.ClientSecret, want: "env-secret"},
+		{setting: "azure.resyncInterval", got: c.Azure.ResyncInterval.Duration.String(), want: "10m0s"},
+		{setting: "azure.timeout", got: c.Azure.Timeout.Duration.String(), want: "1m0s"},
+		{setting: "admission.minClientSecretDuration", got: c.Admission.MinClientSecretDuration.Duration.String(), want: "2h0m0s"},
+		{setting: "admission.maxClientSecretDuration", got: c.Admission.MaxClientSecretDuration.Duration.String(), want: "720h0m0s"},
+		{setting: "defaults.clusterName", got: c.Defaults.ClusterName, want: "flag-cluster"},
+		{setting: "defaults.nodeResourceGroup", got: c.Defaults.NodeResourceGroup, want: "file-nodes"},
+		{setting: "defaults.tags", got: strings.Join(c.Defaults.Tags, ";"), want: "team=a;env=dev"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("expected %s %q, got %q", tt.setting, tt.want, tt.got)
+		}
+	}
+	if *c.LeaderElection.LeaderElect {
+		t.Error("expected --leader-elect=false to override the file")
+	}
+	if !c.Admission.Enabled {
+		t.Error("expected ENABLE_WEBHOOKS=true to override the file")
+	}
+	if want := map[string]metav1.Duration{"CreateRoleAssignment": {Duration: 5 * time.Minute}}; !reflect.DeepEqual(c.Azure.OperationTimeouts, want) {
+		t.Errorf("expected --azure-operation-timeouts to replace the timeouts of the file, got %v", c.Azure.OperationTimeouts)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	setEnv(t, "AZURE_TIMEOUT", "soon")
+
+	var f Flags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.BindFlags(fs)
+	if err := fs.Parse([]string{"--enable-webhooks=maybe", "--azure-operation-timeouts=CreateRoleAssignment"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := f.Load()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"AZURE_TIMEOUT", "--enable-webhooks", "--azure-operation-timeouts"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "unknown setting", body: header + "azure:\n  region: westeurope\n", wantErr: "unknown field"},
+		{name: "secret", body: header + "azure:\n  clientSecret: secret\n", wantErr: "unknown field"},
+		{name: "wrong kind", body: "apiVersion: controller-runtime.sigs.k8s.io/v1alpha1\nkind: ControllerManagerConfig\n", wantErr: "not a config.azidterminator.io/v1alpha1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Flags{File: writeConfigFile(t, tt.body)}
+			if _, err := f.Load(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := defaultConfig()
+	if allErrs := c.Validate(); len(allErrs) != 2 {
+		t.Errorf("expected the tenant and subscription to be required, got %v", allErrs)
+	}
+
+	c.Azure = Azure{
+		Cloud:          "AzureMoonCloud",
+		SubscriptionID: "my-subscription",
+		OIDCIssuerURL:  "http://issuer.example.com",
+	}
+	got := c.Validate().ToAggregate().Error()
+	for _, want := range []string{
+		`azure.cloud: Unsupported value: "AzureMoonCloud"`,
+		"azure.tenantID: Required value: set it in the config file, with AZURE_TENANT_ID or with --azure-tenant-id",
+		`azure.subscriptionID: Invalid value: "my-subscription": must be a GUID`,
+		"azure.oidcIssuerURL",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+
+	c.Azure = Azure{Cloud: "AzureUSGovernmentCloud", TenantID: "tenant", SubscriptionID: "00000000-0000-0000-0000-000000000000"}
+	if allErrs := c.Validate(); len(allErrs) > 0 {
+		t.Errorf("expected a valid configuration, got %v", allErrs)
+	}
+}
+
+func TestValidateDurations(t *testing.T) {
+	valid := defaultConfig()
+	valid.Azure.TenantID = "tenant"
+	valid.Azure.SubscriptionID = "00000000-0000-0000-0000-000000000000"
+	valid.Defaults.ClientSecretDuration = "720h"
+
+	tests := []struct {
+		name   string
+		modify func(c *ControllerManagerConfig)
+		want   string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *ControllerManagerConfig) {},
+		},
+		{
+			name:   "negative timeout",
+			modify: func(c *ControllerManagerConfig) { c.Azure.Timeout.Duration = -time.Second },
+			want:   `azure.timeout: Invalid value: "-1s": must not be negative`,
+		},
+		{
+			name:   "negative resync interval",
+			modify: func(c *ControllerManagerConfig) { c.Azure.ResyncInterval.Duration = -time.Minute },
+			want:   "azure.resyncInterval",
+		},
+		{
+			name: "negative operation timeout",
+			modify: func(c *ControllerManagerConfig) {
+				c.Azure.OperationTimeouts = map[string]metav1.Duration{"DeleteApplication": {Duration: -time.Minute}}
+			},
+			want: "azure.operationTimeouts[DeleteApplication]",
+		},
+		{
+			name:   "negative min client secret duration",
+			modify: func(c *ControllerManagerConfig) { c.Admission.MinClientSecretDuration.Duration = -time.Hour },
+			want:   "admission.minClientSecretDuration",
+		},
+		{
+			name: "min longer than max",
+			modify: func(c *ControllerManagerConfig) {
+				c.Admission.MinClientSecretDuration.Duration = 48 * time.Hour
+				c.Admission.MaxClientSecretDuration.Duration = 24 * time.Hour
+				c.Defaults.ClientSecretDuration = ""
+			},
+			want: `admission.maxClientSecretDuration: Invalid value: "24h0m0s": must not be shorter than admission.minClientSecretDuration`,
+		},
+		{
+			name: "min without max",
+			modify: func(c *ControllerManagerConfig) {
+				c.Admission.MinClientSecretDuration.Duration = 48 * time.Hour
+				c.Admission.MaxClientSecretDuration.Duration = 0
+			},
+		},
+		{
+			name:   "unparsable default client secret duration",
+			modify: func(c *ControllerManagerConfig) { c.Defaults.ClientSecretDuration = "a month" },
+			want:   "defaults.clientSecretDuration",
+		},
+		{
+			name:   "default client secret duration above max",
+			modify: func(c *ControllerManagerConfig) { c.Defaults.ClientSecretDuration = "87600h" },
+			want:   "must be at most 17520h0m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid.DeepCopyObject().(*ControllerManagerConfig)
+			tt.modify(c)
+			allErrs := c.Validate()
+			if tt.want == "" {
+				if len(allErrs) > 0 {
+					t.Errorf("expected a valid configuration, got %v", allErrs)
+				}
+				return
+			}
+			if len(allErrs) != 1 || !strings.Contains(allErrs.ToAggregate().Error(), tt.want) {
+				t.Errorf("expected a single error containing %q, got %v", tt.want, allErrs)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	version := Version
+	Version = "1.2.3"
+	defer func() { Version = version }()
+
+	if got := UserAgent(); got != "azure-identity-terminator/1.2.3" {
+		t.Errorf("unexpected user agent %q", got)
+	}
+}
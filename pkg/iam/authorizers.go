@@ -1,111 +1,204 @@
 package iam
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/adal"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
-	config "github.com/tonedefdev/azure-identity-terminator/pkg/internal"
-)
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
-var (
-	armAuthorizer   autorest.Authorizer
-	graphAuthorizer autorest.Authorizer
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
 )
 
-// OAuthGrantType specifies which grant type to use.
-type OAuthGrantType int
+// AuthMode specifies how the controller authenticates to Azure AD.
+type AuthMode string
 
 const (
-	// OAuthGrantTypeServicePrincipal for client credentials flow
-	OAuthGrantTypeServicePrincipal OAuthGrantType = iota
-	// OAuthGrantTypeDeviceFlow for device flow
-	OAuthGrantTypeDeviceFlow
+	// AuthModeClientSecret uses the client credentials flow with AZURE_CLIENT_SECRET
+	AuthModeClientSecret AuthMode = "clientSecret"
+	// AuthModeClientCertificate uses the client credentials flow with the PEM or PFX certificate at AZURE_CLIENT_CERTIFICATE_PATH
+	AuthModeClientCertificate AuthMode = "clientCertificate"
+	// AuthModeManagedIdentity requests tokens from the Azure Instance Metadata Service
+	AuthModeManagedIdentity AuthMode = "managedIdentity"
+	// AuthModeWorkloadIdentity exchanges the federated token at AZURE_FEDERATED_TOKEN_FILE
+	AuthModeWorkloadIdentity AuthMode = "workloadIdentity"
+	// AuthModeAzureCLI uses the account logged in to the Azure CLI, for running the controller locally
+	AuthModeAzureCLI AuthMode = "azureCLI"
+	// AuthModeDeviceFlow for device flow
+	AuthModeDeviceFlow AuthMode = "deviceFlow"
 )
 
-func getAuthorizerForResource(grantType OAuthGrantType, resource string) (autorest.Authorizer, error) {
-	var a autorest.Authorizer
-	var err error
+// authModes lists every AuthMode in the order they are documented
+var authModes = []AuthMode{
+	AuthModeClientSecret,
+	AuthModeClientCertificate,
+	AuthModeManagedIdentity,
+	AuthModeWorkloadIdentity,
+	AuthModeAzureCLI,
+	AuthModeDeviceFlow,
+}
 
-	switch grantType {
+// ParseAuthMode returns the AuthMode named by s, which defaults to AuthModeClientSecret when s is empty.
+func ParseAuthMode(s string) (AuthMode, error) {
+	if s == "" {
+		return AuthModeClientSecret, nil
+	}
 
-	case OAuthGrantTypeServicePrincipal:
-		oauthConfig, err := adal.NewOAuthConfig(
-			config.Environment().ActiveDirectoryEndpoint, config.TenantID())
-		if err != nil {
-			return nil, err
+	names := make([]string, 0, len(authModes))
+	for _, mode := range authModes {
+		if strings.EqualFold(s, string(mode)) {
+			return mode, nil
 		}
+		names = append(names, string(mode))
+	}
 
-		token, err := adal.NewServicePrincipalToken(
-			*oauthConfig, config.ClientID(), config.ClientSecret(), resource)
-		if err != nil {
-			return nil, err
-		}
-		a = autorest.NewBearerAuthorizer(token)
+	return "", fmt.Errorf("invalid auth mode %q, must be one of %s", s, strings.Join(names, ", "))
+}
 
-	case OAuthGrantTypeDeviceFlow:
-		deviceconfig := auth.NewDeviceFlowConfig(config.ClientID(), config.TenantID())
-		deviceconfig.Resource = resource
-		a, err = deviceconfig.Authorizer()
-		if err != nil {
-			return nil, err
-		}
+// refreshInterval is how often a started CredentialProvider checks whether its tokens are about to expire. It must
+// stay well below refreshWithin, so that tokens are refreshed before requests find them expiring.
+const refreshInterval = time.Minute
 
-	default:
-		return a, fmt.Errorf("invalid grant type specified")
-	}
+var tokenRefreshFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "azidterminator_azure_token_refresh_failures_total",
+	Help: "Number of times refreshing the token for Microsoft Graph or Azure Resource Manager failed.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(tokenRefreshFailures)
+}
 
-	return a, err
+// CredentialProvider hands out authorizers for Microsoft Graph and Azure Resource Manager that share a single token
+// per resource. It is safe for concurrent use. Once started, it refreshes the tokens before they expire, and it
+// exports the time until each expires as a prometheus.Collector.
+type CredentialProvider struct {
+	credentials credentials
+	log         logr.Logger
+	// graphEndpoint and resourceManagerEndpoint are the resources of the tokens for Microsoft Graph and Azure
+	// Resource Manager
+	graphEndpoint           string
+	resourceManagerEndpoint string
+
+	mu     sync.Mutex
+	tokens map[string]tokenProvider
+
+	tokenExpiry *prometheus.Desc
 }
 
-// GrantType returns what grant type has been configured.
-func grantType() OAuthGrantType {
-	if config.UseDeviceFlow() {
-		return OAuthGrantTypeDeviceFlow
+// NewCredentialProvider returns a CredentialProvider that authenticates to the cloud of c with its credentials
+func NewCredentialProvider(c config.Azure, log logr.Logger) (*CredentialProvider, error) {
+	credentials, err := credentialsFromConfig(c)
+	if err != nil {
+		return nil, err
 	}
-	return OAuthGrantTypeServicePrincipal
+
+	env, err := c.Environment()
+	if err != nil {
+		return nil, err
+	}
+
+	p := newCredentialProvider(credentials, log)
+	p.graphEndpoint = config.MicrosoftGraphEndpoint(env)
+	p.resourceManagerEndpoint = env.ResourceManagerEndpoint
+	return p, nil
 }
 
-// GetGraphAuthorizer gets an OAuthTokenAuthorizer for graphrbac API.
-func GetGraphAuthorizer() (autorest.Authorizer, error) {
-	if graphAuthorizer != nil {
-		return graphAuthorizer, nil
+func newCredentialProvider(c credentials, log logr.Logger) *CredentialProvider {
+	return &CredentialProvider{
+		credentials: c,
+		log:         log,
+		tokens:      map[string]tokenProvider{},
+		tokenExpiry: prometheus.NewDesc(
+			"azidterminator_azure_token_expiry_seconds",
+			"Seconds until the token for Microsoft Graph or Azure Resource Manager expires. Negative once it has expired.",
+			[]string{"resource"}, nil),
 	}
+}
 
-	var a autorest.Authorizer
-	var err error
+// GraphAuthorizer returns an authorizer for Microsoft Graph.
+func (p *CredentialProvider) GraphAuthorizer() (autorest.Authorizer, error) {
+	return p.Authorizer(p.graphEndpoint)
+}
+
+// ResourceManagementAuthorizer returns an authorizer for Azure Resource Manager.
+func (p *CredentialProvider) ResourceManagementAuthorizer() (autorest.Authorizer, error) {
+	return p.Authorizer(p.resourceManagerEndpoint)
+}
 
-	a, err = getAuthorizerForResource(grantType(), config.Environment().GraphEndpoint)
+// Authorizer returns an authorizer for resource. A failure to set up the token is returned rather than cached, so
+// the next call tries again.
+func (p *CredentialProvider) Authorizer(resource string) (autorest.Authorizer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	if err == nil {
-		// cache
-		graphAuthorizer = a
-	} else {
-		graphAuthorizer = nil
+	token, ok := p.tokens[resource]
+	if !ok {
+		var err error
+		token, err = newToken(p.credentials, resource)
+		if err != nil {
+			return nil, fmt.Errorf("setting up %s credentials for %s: %w", p.credentials.Mode, resource, err)
+		}
+		p.tokens[resource] = token
 	}
 
-	return graphAuthorizer, err
+	return autorest.NewBearerAuthorizer(token), nil
+}
+
+// Start refreshes the tokens handed out so far whenever they are about to expire, until ctx is done. It implements
+// manager.Runnable.
+func (p *CredentialProvider) Start(ctx context.Context) error {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.refresh(ctx)
+		}
+	}
 }
 
-// GetResourceManagementAuthorizer gets an OAuthTokenAuthorizer for Azure Resource Manager
-func GetResourceManagementAuthorizer() (autorest.Authorizer, error) {
-	if armAuthorizer != nil {
-		return armAuthorizer, nil
+// refresh refreshes every token that is about to expire. Failures are logged and left for the next refresh, or
+// for the request that finds the token expiring, to retry.
+func (p *CredentialProvider) refresh(ctx context.Context) {
+	for resource, token := range p.snapshot() {
+		if err := token.EnsureFreshWithContext(ctx); err != nil && ctx.Err() == nil {
+			p.log.Error(err, "Failed to refresh token", "resource", resource, "authMode", p.credentials.Mode)
+			tokenRefreshFailures.WithLabelValues(resource).Inc()
+		}
 	}
+}
+
+// snapshot copies the tokens so they can be refreshed without holding the lock
+func (p *CredentialProvider) snapshot() map[string]tokenProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	var a autorest.Authorizer
-	var err error
+	tokens := make(map[string]tokenProvider, len(p.tokens))
+	for resource, token := range p.tokens {
+		tokens[resource] = token
+	}
+	return tokens
+}
 
-	a, err = getAuthorizerForResource(
-		grantType(), config.Environment().ResourceManagerEndpoint)
+// Describe implements prometheus.Collector
+func (p *CredentialProvider) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.tokenExpiry
+}
 
-	if err == nil {
-		// cache
-		armAuthorizer = a
-	} else {
-		// clear cache
-		armAuthorizer = nil
+// Collect implements prometheus.Collector
+func (p *CredentialProvider) Collect(ch chan<- prometheus.Metric) {
+	for resource, token := range p.snapshot() {
+		// Tokens that have never been requested have no expiry yet
+		if t := token.Token(); t.AccessToken != "" {
+			ch <- prometheus.MustNewConstMetric(p.tokenExpiry, prometheus.GaugeValue, time.Until(t.Expires()).Seconds(), resource)
+		}
 	}
-	return armAuthorizer, err
 }
@@ -0,0 +1,124 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestManagedIdentityEndpoint serves managed identity tokens that expire after lifetime, or fails every
+// request while failing is set. It returns the credentials to request tokens from it with and the number of
+// requests it received.
+func newTestManagedIdentityEndpoint(t *testing.T, lifetime time.Duration, failing *int32) (credentials, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if failing != nil && atomic.LoadInt32(failing) != 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token-" + strconv.Itoa(int(n)),
+			"expires_on":   strconv.FormatInt(time.Now().Add(lifetime).Unix(), 10),
+			"token_type":   "Bearer",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return credentials{Mode: AuthModeManagedIdentity, ManagedIdentityEndpoint: server.URL}, &requests
+}
+
+// authorize returns the Authorization header a authorizes a request with
+func authorize(t *testing.T, a autorest.Authorizer) string {
+	req, err := autorest.Prepare(&http.Request{Header: http.Header{}}, a.WithAuthorization())
+	if err != nil {
+		t.Fatalf("authorizing request: %v", err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+func TestCredentialProviderSharesTokens(t *testing.T) {
+	c, requests := newTestManagedIdentityEndpoint(t, time.Hour, nil)
+	p := newCredentialProvider(c, logr.Discard())
+
+	// Concurrent reconciles share one token per resource, requested once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, err := p.Authorizer(testResource)
+			if err != nil {
+				t.Errorf("Authorizer: %v", err)
+				return
+			}
+			if got := authorize(t, a); got != "Bearer token-1" {
+				t.Errorf("unexpected Authorization header %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("expected 1 token request, got %d", got)
+	}
+	if got := testutil.CollectAndCount(p, "azidterminator_azure_token_expiry_seconds"); got != 1 {
+		t.Errorf("expected the expiry of 1 token, got %d", got)
+	}
+}
+
+func TestCredentialProviderRefreshesExpiringTokens(t *testing.T) {
+	failing := int32(0)
+	c, requests := newTestManagedIdentityEndpoint(t, 2*time.Minute, &failing)
+	p := newCredentialProvider(c, logr.Discard())
+
+	a, err := p.Authorizer(testResource)
+	if err != nil {
+		t.Fatalf("Authorizer: %v", err)
+	}
+	authorize(t, a)
+
+	// The token expires within the refresh window, so it is refreshed before a request finds it expiring
+	p.refresh(context.Background())
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("expected the token to be refreshed, got %d token requests", got)
+	}
+	if got := p.snapshot()[testResource].OAuthToken(); got != "token-2" {
+		t.Errorf("unexpected token %q", got)
+	}
+
+	// A failed refresh is recorded and left for the next one to retry
+	atomic.StoreInt32(&failing, 1)
+	failures := tokenRefreshFailures.WithLabelValues(testResource)
+	before := testutil.ToFloat64(failures)
+	p.refresh(context.Background())
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("expected 1 refresh failure recorded, got %v", got)
+	}
+}
+
+func TestCredentialProviderReturnsErrors(t *testing.T) {
+	p := newCredentialProvider(credentials{Mode: AuthModeClientSecret, ClientID: "client-id", ActiveDirectoryEndpoint: "https://login.microsoftonline.com/"}, logr.Discard())
+
+	// A failure is not cached, so every caller sees it until the credentials are fixed
+	for i := 0; i < 2; i++ {
+		if a, err := p.Authorizer(testResource); err == nil || a != nil {
+			t.Errorf("expected an error without a client secret, got %v", a)
+		}
+	}
+	if len(p.snapshot()) != 0 {
+		t.Error("expected no token to be cached")
+	}
+}
@@ -0,0 +1,346 @@
+package iam
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/azure/cli"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+	"golang.org/x/crypto/pkcs12"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// imdsTokenEndpoint is the token endpoint of the Azure Instance Metadata Service
+	imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsAPIVersion    = "2018-02-01"
+	// refreshWithin is how long before it expires a token is refreshed, the same as adal uses
+	refreshWithin = 5 * time.Minute
+)
+
+// credentials holds the settings each AuthMode reads to request tokens
+type credentials struct {
+	Mode         AuthMode
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// CertificatePath is a PEM file holding the certificate and an unencrypted private key, or a PFX file
+	CertificatePath string
+	// CertificatePassword decrypts a PFX file
+	CertificatePassword string
+	// FederatedTokenFile is reread on every refresh, as the kubelet rotates the token
+	FederatedTokenFile string
+	// ActiveDirectoryEndpoint is the authority of the client secret, client certificate and workload identity modes
+	ActiveDirectoryEndpoint string
+	// ManagedIdentityEndpoint is the token endpoint of the managed identity mode
+	ManagedIdentityEndpoint string
+}
+
+// credentialsFromConfig returns the credentials configured for the controller
+func credentialsFromConfig(c config.Azure) (credentials, error) {
+	mode, err := ParseAuthMode(c.AuthMode)
+	if err != nil {
+		return credentials{}, err
+	}
+
+	env, err := c.Environment()
+	if err != nil {
+		return credentials{}, err
+	}
+
+	return credentials{
+		Mode:                    mode,
+		TenantID:                c.TenantID,
+		ClientID:                c.ClientID,
+		ClientSecret:            c.ClientSecret,
+		CertificatePath:         c.ClientCertificatePath,
+		CertificatePassword:     c.ClientCertificatePassword,
+		FederatedTokenFile:      c.FederatedTokenFile,
+		ActiveDirectoryEndpoint: env.ActiveDirectoryEndpoint,
+		ManagedIdentityEndpoint: imdsTokenEndpoint,
+	}, nil
+}
+
+// ValidateCredentials returns every setting that the auth mode of c needs but is missing. The path is that of the
+// Azure configuration.
+func ValidateCredentials(c config.Azure, path *field.Path) field.ErrorList {
+	mode, err := ParseAuthMode(c.AuthMode)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path.Child("authMode"), c.AuthMode, err.Error())}
+	}
+
+	var allErrs field.ErrorList
+	switch mode {
+	case AuthModeManagedIdentity, AuthModeAzureCLI:
+		return nil
+	case AuthModeClientSecret:
+		if c.ClientSecret == "" {
+			allErrs = append(allErrs, config.Missing(path.Child("clientSecret")))
+		}
+	case AuthModeClientCertificate:
+		if c.ClientCertificatePath == "" {
+			allErrs = append(allErrs, config.Missing(path.Child("clientCertificatePath")))
+		}
+	case AuthModeWorkloadIdentity:
+		if c.FederatedTokenFile == "" {
+			allErrs = append(allErrs, config.Missing(path.Child("federatedTokenFile")))
+		}
+	}
+
+	if c.ClientID == "" {
+		allErrs = append(allErrs, config.Missing(path.Child("clientID")))
+	}
+	return allErrs
+}
+
+// tokenProvider is implemented by the token of every AuthMode
+type tokenProvider interface {
+	adal.OAuthTokenProvider
+	adal.RefresherWithContext
+	Token() adal.Token
+}
+
+// newToken returns a token for resource that is refreshed before it expires
+func newToken(c credentials, resource string) (tokenProvider, error) {
+	switch c.Mode {
+	case AuthModeManagedIdentity:
+		return &refreshingToken{resource: resource, refresh: managedIdentityRefresh(c.ManagedIdentityEndpoint, c.ClientID)}, nil
+	case AuthModeAzureCLI:
+		return &refreshingToken{resource: resource, refresh: azureCLIRefresh}, nil
+	}
+
+	if c.ClientID == "" {
+		return nil, fmt.Errorf("AZURE_CLIENT_ID is required in the %s auth mode", c.Mode)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(c.ActiveDirectoryEndpoint, c.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Mode {
+	case AuthModeClientSecret:
+		if c.ClientSecret == "" {
+			return nil, errors.New("AZURE_CLIENT_SECRET is required in the clientSecret auth mode")
+		}
+		return adal.NewServicePrincipalToken(*oauthConfig, c.ClientID, c.ClientSecret, resource)
+
+	case AuthModeClientCertificate:
+		if c.CertificatePath == "" {
+			return nil, errors.New("AZURE_CLIENT_CERTIFICATE_PATH is required in the clientCertificate auth mode")
+		}
+		certificate, key, err := loadCertificate(c.CertificatePath, c.CertificatePassword)
+		if err != nil {
+			return nil, err
+		}
+		return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, c.ClientID, certificate, key, resource)
+
+	case AuthModeWorkloadIdentity:
+		if c.FederatedTokenFile == "" {
+			return nil, errors.New("AZURE_FEDERATED_TOKEN_FILE is required in the workloadIdentity auth mode")
+		}
+		return adal.NewServicePrincipalTokenWithSecret(*oauthConfig, c.ClientID, resource, &federatedTokenSecret{path: c.FederatedTokenFile})
+
+	case AuthModeDeviceFlow:
+		deviceconfig := auth.NewDeviceFlowConfig(c.ClientID, c.TenantID)
+		deviceconfig.AADEndpoint = c.ActiveDirectoryEndpoint
+		deviceconfig.Resource = resource
+		return deviceconfig.ServicePrincipalToken()
+
+	default:
+		return nil, fmt.Errorf("invalid auth mode %q", c.Mode)
+	}
+}
+
+// loadCertificate reads the certificate and RSA private key from a PFX file, or from a PEM file when the
+// file does not have a .pfx or .p12 extension
+func loadCertificate(path string, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading client certificate: %w", err)
+	}
+
+	var certificate *x509.Certificate
+	var key interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pfx", ".p12":
+		key, certificate, err = pkcs12.Decode(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding client certificate %s: %w", path, err)
+		}
+	default:
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			switch block.Type {
+			case "CERTIFICATE":
+				if certificate == nil {
+					certificate, err = x509.ParseCertificate(block.Bytes)
+				}
+			case "PRIVATE KEY":
+				key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			case "RSA PRIVATE KEY":
+				key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("decoding client certificate %s: %w", path, err)
+			}
+		}
+	}
+
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("client certificate %s holds no certificate", path)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("client certificate %s holds no RSA private key", path)
+	}
+
+	return certificate, rsaKey, nil
+}
+
+// federatedTokenSecret authenticates with the service account token projected into the controller's pod
+type federatedTokenSecret struct {
+	path string
+}
+
+// SetAuthenticationValues sets the federated token as the client assertion of the token request
+func (s *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	assertion, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("reading federated token: %w", err)
+	}
+
+	v.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
+
+// refreshingToken is a token requested by a function rather than from the token endpoint of Azure AD
+type refreshingToken struct {
+	resource string
+	refresh  adal.TokenRefresh
+
+	mu    sync.RWMutex
+	token adal.Token
+}
+
+// OAuthToken returns the current access token
+func (t *refreshingToken) OAuthToken() string {
+	return t.Token().AccessToken
+}
+
+// Token returns a copy of the current token
+func (t *refreshingToken) Token() adal.Token {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.token
+}
+
+// EnsureFreshWithContext refreshes the token when it expires within the next five minutes
+func (t *refreshingToken) EnsureFreshWithContext(ctx context.Context) error {
+	if !t.Token().WillExpireIn(refreshWithin) {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Another request may have refreshed the token while this one waited for the lock
+	if !t.token.WillExpireIn(refreshWithin) {
+		return nil
+	}
+	return t.refreshLocked(ctx, t.resource)
+}
+
+// RefreshWithContext refreshes the token
+func (t *refreshingToken) RefreshWithContext(ctx context.Context) error {
+	return t.RefreshExchangeWithContext(ctx, t.resource)
+}
+
+// RefreshExchangeWithContext refreshes the token for another resource
+func (t *refreshingToken) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.refreshLocked(ctx, resource)
+}
+
+func (t *refreshingToken) refreshLocked(ctx context.Context, resource string) error {
+	token, err := t.refresh(ctx, resource)
+	if err != nil {
+		return err
+	}
+
+	t.token = *token
+	return nil
+}
+
+// managedIdentityRefresh requests tokens from the Instance Metadata Service at endpoint. The clientID selects a
+// user-assigned managed identity, and may be empty when the node has a single identity.
+func managedIdentityRefresh(endpoint string, clientID string) adal.TokenRefresh {
+	return func(ctx context.Context, resource string) (*adal.Token, error) {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		query := url.Values{}
+		query.Set("api-version", imdsAPIVersion)
+		query.Set("resource", resource)
+		if clientID != "" {
+			query.Set("client_id", clientID)
+		}
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata", "true")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("requesting managed identity token: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading managed identity token: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("requesting managed identity token failed with status %d: %s", resp.StatusCode, body)
+		}
+
+		token := &adal.Token{}
+		if err := json.Unmarshal(body, token); err != nil {
+			return nil, fmt.Errorf("decoding managed identity token: %w", err)
+		}
+		return token, nil
+	}
+}
+
+// azureCLIRefresh requests tokens from the account logged in to the Azure CLI
+func azureCLIRefresh(ctx context.Context, resource string) (*adal.Token, error) {
+	cliToken, err := cli.GetTokenFromCLI(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cliToken.ToADALToken()
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
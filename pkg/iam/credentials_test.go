@@ -0,0 +1,397 @@
+package iam
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/tonedefdev/azure-identity-terminator/pkg/config"
+)
+
+const testResource = "https://management.azure.com/"
+
+// newTestTokenEndpoint serves tokens for every request after passing it to check, standing in for Azure AD
+// or the Instance Metadata Service. It returns the URL of the endpoint and a pointer to the number of requests.
+func newTestTokenEndpoint(t *testing.T, check func(r *http.Request)) (string, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %v", err)
+		}
+		check(r)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token-" + strconv.Itoa(requests),
+			"expires_in":   "3600",
+			"expires_on":   strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+			"resource":     r.Form.Get("resource"),
+			"token_type":   "Bearer",
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/", &requests
+}
+
+// expectTokenRequest fails the test unless r requests a token for testResource with the client credentials grant
+func expectTokenRequest(t *testing.T, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/tenant-id/oauth2/token" {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("client_id") != "client-id" || r.PostForm.Get("resource") != testResource {
+		t.Errorf("unexpected token request %v", r.PostForm)
+	}
+}
+
+// fetchToken refreshes token and returns its access token
+func fetchToken(t *testing.T, token tokenProvider) string {
+	if err := token.EnsureFreshWithContext(context.Background()); err != nil {
+		t.Fatalf("EnsureFreshWithContext: %v", err)
+	}
+	return token.OAuthToken()
+}
+
+func TestParseAuthMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    AuthMode
+		wantErr bool
+	}{
+		{value: "", want: AuthModeClientSecret},
+		{value: "managedIdentity", want: AuthModeManagedIdentity},
+		{value: "WorkloadIdentity", want: AuthModeWorkloadIdentity},
+		{value: "azurecli", want: AuthModeAzureCLI},
+		{value: "password", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAuthMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAuthMode(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestClientSecretToken(t *testing.T) {
+	endpoint, _ := newTestTokenEndpoint(t, func(r *http.Request) {
+		expectTokenRequest(t, r)
+		if r.PostForm.Get("client_secret") != "secret" {
+			t.Errorf("unexpected client secret %q", r.PostForm.Get("client_secret"))
+		}
+	})
+
+	token, err := newToken(credentials{
+		Mode:                    AuthModeClientSecret,
+		TenantID:                "tenant-id",
+		ClientID:                "client-id",
+		ClientSecret:            "secret",
+		ActiveDirectoryEndpoint: endpoint,
+	}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if got := fetchToken(t, token); got != "token-1" {
+		t.Errorf("unexpected token %q", got)
+	}
+}
+
+func TestClientCertificateToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client-id"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "client.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, _ := newTestTokenEndpoint(t, func(r *http.Request) {
+		expectTokenRequest(t, r)
+		if r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			t.Errorf("unexpected client assertion type %q", r.PostForm.Get("client_assertion_type"))
+		}
+
+		// The assertion must be signed with the key of the certificate
+		parts := strings.Split(r.PostForm.Get("client_assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("client assertion is not a JWT: %q", r.PostForm.Get("client_assertion"))
+		}
+		if err := verifyJWT(certificate, parts); err != nil {
+			t.Errorf("verifying client assertion: %v", err)
+		}
+	})
+
+	token, err := newToken(credentials{
+		Mode:                    AuthModeClientCertificate,
+		TenantID:                "tenant-id",
+		ClientID:                "client-id",
+		CertificatePath:         path,
+		ActiveDirectoryEndpoint: endpoint,
+	}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if got := fetchToken(t, token); got != "token-1" {
+		t.Errorf("unexpected token %q", got)
+	}
+}
+
+func TestLoadCertificateFromPFX(t *testing.T) {
+	certificate, key, err := loadCertificate(filepath.Join("testdata", "client.pfx"), "password")
+	if err != nil {
+		t.Fatalf("loadCertificate: %v", err)
+	}
+	if certificate.Subject.CommonName != "azure-identity-terminator-test" || key.Validate() != nil {
+		t.Errorf("unexpected certificate %s", certificate.Subject)
+	}
+
+	if _, _, err := loadCertificate(filepath.Join("testdata", "client.pfx"), "wrong"); err == nil {
+		t.Error("expected an error decoding the PFX file with the wrong password")
+	}
+}
+
+func TestWorkloadIdentityToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "azure-identity-token")
+	assertion := "first-token"
+	if err := ioutil.WriteFile(path, []byte(assertion+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, requests := newTestTokenEndpoint(t, func(r *http.Request) {
+		expectTokenRequest(t, r)
+		if r.PostForm.Get("client_assertion") != assertion {
+			t.Errorf("unexpected client assertion %q, want %q", r.PostForm.Get("client_assertion"), assertion)
+		}
+	})
+
+	token, err := newToken(credentials{
+		Mode:                    AuthModeWorkloadIdentity,
+		TenantID:                "tenant-id",
+		ClientID:                "client-id",
+		FederatedTokenFile:      path,
+		ActiveDirectoryEndpoint: endpoint,
+	}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	fetchToken(t, token)
+
+	// The kubelet rotates the projected token, so every refresh reads it again
+	assertion = "rotated-token"
+	if err := ioutil.WriteFile(path, []byte(assertion), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.RefreshWithContext(context.Background()); err != nil {
+		t.Fatalf("RefreshWithContext: %v", err)
+	}
+	if *requests != 2 || token.OAuthToken() != "token-2" {
+		t.Errorf("unexpected token %q after %d requests", token.OAuthToken(), *requests)
+	}
+}
+
+func TestManagedIdentityToken(t *testing.T) {
+	endpoint, requests := newTestTokenEndpoint(t, func(r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Metadata") != "true" {
+			t.Errorf("unexpected request %s with Metadata header %q", r.Method, r.Header.Get("Metadata"))
+		}
+		query := r.URL.Query()
+		if query.Get("api-version") != imdsAPIVersion || query.Get("resource") != testResource || query.Get("client_id") != "client-id" {
+			t.Errorf("unexpected query %v", query)
+		}
+	})
+
+	token, err := newToken(credentials{
+		Mode:                    AuthModeManagedIdentity,
+		ClientID:                "client-id",
+		ManagedIdentityEndpoint: endpoint + "metadata/identity/oauth2/token",
+	}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if got := fetchToken(t, token); got != "token-1" {
+		t.Errorf("unexpected token %q", got)
+	}
+
+	// The token is only requested again once it is about to expire
+	fetchToken(t, token)
+	if *requests != 1 {
+		t.Errorf("expected 1 token request, got %d", *requests)
+	}
+	if expires := token.Token().Expires(); time.Until(expires) < 55*time.Minute {
+		t.Errorf("unexpected expiry %s", expires)
+	}
+}
+
+func TestManagedIdentityTokenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_request","error_description":"Identity not found"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	token, err := newToken(credentials{Mode: AuthModeManagedIdentity, ManagedIdentityEndpoint: server.URL}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if err := token.EnsureFreshWithContext(context.Background()); err == nil || !strings.Contains(err.Error(), "Identity not found") {
+		t.Errorf("expected the error from the token endpoint, got %v", err)
+	}
+}
+
+func TestAzureCLIToken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in for the Azure CLI is a shell script")
+	}
+
+	// The stand-in echoes the resource it was asked for, the sixth argument, as the access token
+	dir := t.TempDir()
+	expiresOn := time.Now().Add(time.Hour).Format(time.RFC3339)
+	script := "#!/bin/sh\necho '{\"accessToken\": \"'$6'\", \"expiresOn\": \"" + expiresOn + "\", \"tokenType\": \"Bearer\"}'\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "az"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", path)
+
+	token, err := newToken(credentials{Mode: AuthModeAzureCLI}, testResource)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if got := fetchToken(t, token); got != testResource {
+		t.Errorf("unexpected token %q", got)
+	}
+}
+
+func TestNewTokenRequiresSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials credentials
+		wantErr     string
+	}{
+		{
+			name:        "client secret",
+			credentials: credentials{Mode: AuthModeClientSecret, ClientID: "client-id"},
+			wantErr:     "AZURE_CLIENT_SECRET",
+		},
+		{
+			name:        "client certificate",
+			credentials: credentials{Mode: AuthModeClientCertificate, ClientID: "client-id"},
+			wantErr:     "AZURE_CLIENT_CERTIFICATE_PATH",
+		},
+		{
+			name:        "workload identity",
+			credentials: credentials{Mode: AuthModeWorkloadIdentity, ClientID: "client-id"},
+			wantErr:     "AZURE_FEDERATED_TOKEN_FILE",
+		},
+		{
+			name:        "client ID",
+			credentials: credentials{Mode: AuthModeWorkloadIdentity, FederatedTokenFile: "token"},
+			wantErr:     "AZURE_CLIENT_ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.credentials.ActiveDirectoryEndpoint = "https://login.microsoftonline.com/"
+			_, err := newToken(tt.credentials, testResource)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error naming %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		azure config.Azure
+		want  []string
+	}{
+		{
+			name:  "client secret by default",
+			azure: config.Azure{},
+			want:  []string{"azure.clientSecret: Required value: set it with AZURE_CLIENT_SECRET", "azure.clientID"},
+		},
+		{
+			name:  "client certificate",
+			azure: config.Azure{AuthMode: "clientCertificate", ClientID: "client-id"},
+			want:  []string{"azure.clientCertificatePath"},
+		},
+		{
+			name:  "workload identity",
+			azure: config.Azure{AuthMode: "workloadIdentity", FederatedTokenFile: "token", ClientID: "client-id"},
+		},
+		{
+			name:  "managed identity",
+			azure: config.Azure{AuthMode: "managedIdentity"},
+		},
+		{
+			name:  "invalid mode",
+			azure: config.Azure{AuthMode: "password"},
+			want:  []string{"azure.authMode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allErrs := ValidateCredentials(tt.azure, field.NewPath("azure"))
+			if len(allErrs) != len(tt.want) {
+				t.Fatalf("expected %d errors, got %v", len(tt.want), allErrs)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(allErrs[i].Error(), want) {
+					t.Errorf("expected %q in %q", want, allErrs[i].Error())
+				}
+			}
+		})
+	}
+}
+
+// verifyJWT checks the RS256 signature of a JWT split into its three parts was made with the key of certificate
+func verifyJWT(certificate *x509.Certificate, parts []string) error {
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+}
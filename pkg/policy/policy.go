@@ -0,0 +1,187 @@
+// Package policy evaluates the AzureIdentityPolicies that select the namespace of an AzureIdentityTerminator
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	azuread "github.com/tonedefdev/azure-identity-terminator/pkg/azure"
+)
+
+// Violations returns every way the AzureIdentityTerminator breaks the AzureIdentityPolicies that select its namespace.
+// Scopes that name a resource group are resolved to their full ID in the subscription with subscriptionID.
+func Violations(ctx context.Context, c client.Reader, t *terminatorv1alpha1.AzureIdentityTerminator, subscriptionID string) (field.ErrorList, error) {
+	policies := &terminatorv1alpha1.AzureIdentityPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: t.Namespace}, ns); err != nil {
+		return nil, err
+	}
+
+	var allErrs field.ErrorList
+	for i := range policies.Items {
+		p := &policies.Items[i]
+		selected, err := selects(p, ns)
+		if err != nil {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata", "namespace"), fmt.Sprintf("AzureIdentityPolicy %s has an invalid namespaceSelector: %v", p.Name, err)))
+			continue
+		}
+		if !selected {
+			continue
+		}
+
+		allErrs = append(allErrs, roleAssignmentViolations(p, t, subscriptionID)...)
+		allErrs = append(allErrs, durationViolations(p, t)...)
+
+		countErrs, err := identityCountViolations(ctx, c, p, t)
+		if err != nil {
+			return nil, err
+		}
+		allErrs = append(allErrs, countErrs...)
+	}
+
+	return allErrs, nil
+}
+
+// selects reports whether the policy applies to the namespace. An empty selector selects every namespace.
+func selects(p *terminatorv1alpha1.AzureIdentityPolicy, ns *corev1.Namespace) (bool, error) {
+	if p.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// roleAssignmentViolations checks each role assignment the AzureIdentityTerminator would be given,
+// including the default one, against the allowed roles and scopes of the policy
+func roleAssignmentViolations(p *terminatorv1alpha1.AzureIdentityPolicy, t *terminatorv1alpha1.AzureIdentityTerminator, subscriptionID string) field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec", "roleAssignments")
+
+	for i, ra := range t.Spec.EffectiveRoleAssignments() {
+		// The default role assignment is not written in the spec, so the list as a whole is reported
+		raPath := path
+		if len(t.Spec.RoleAssignments) > 0 {
+			raPath = path.Index(i)
+		}
+
+		if len(p.Spec.AllowedRoles) > 0 && !containsFold(p.Spec.AllowedRoles, ra.Role) {
+			allErrs = append(allErrs, field.Forbidden(raPath.Child("role"), fmt.Sprintf("role %q is not allowed by AzureIdentityPolicy %s", ra.Role, p.Name)))
+		}
+
+		if len(p.Spec.AllowedScopePrefixes) > 0 && !scopeAllowed(p.Spec.AllowedScopePrefixes, subscriptionID, ra.Scope) {
+			allErrs = append(allErrs, field.Forbidden(raPath.Child("scope"), fmt.Sprintf("scope %q is not allowed by AzureIdentityPolicy %s", ra.Scope, p.Name)))
+		}
+	}
+
+	return allErrs
+}
+
+// durationViolations checks the ClientSecret lifetime against the longest the policy allows
+func durationViolations(p *terminatorv1alpha1.AzureIdentityPolicy, t *terminatorv1alpha1.AzureIdentityTerminator) field.ErrorList {
+	path := field.NewPath("spec", "servicePrincipal", "clientSecretDuration")
+	value := t.Spec.ServicePrincipal.ClientSecretDuration
+	if p.Spec.MaxClientSecretDuration == "" || value == "" {
+		return nil
+	}
+
+	max, err := time.ParseDuration(p.Spec.MaxClientSecretDuration)
+	if err != nil {
+		return field.ErrorList{field.Forbidden(path, fmt.Sprintf("AzureIdentityPolicy %s has an invalid maxClientSecretDuration: %v", p.Name, err))}
+	}
+
+	// Unparsable durations are rejected by the validating webhook
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= max {
+		return nil
+	}
+
+	return field.ErrorList{field.Forbidden(path, fmt.Sprintf("must be at most %s under AzureIdentityPolicy %s", max, p.Name))}
+}
+
+// identityCountViolations checks the namespace has room for the AzureIdentityTerminator. The oldest
+// AzureIdentityTerminators are the ones allowed, so creating a new one never breaks an existing one.
+func identityCountViolations(ctx context.Context, c client.Reader, p *terminatorv1alpha1.AzureIdentityPolicy, t *terminatorv1alpha1.AzureIdentityTerminator) (field.ErrorList, error) {
+	if p.Spec.MaxIdentitiesPerNamespace == nil {
+		return nil, nil
+	}
+
+	list := &terminatorv1alpha1.AzureIdentityTerminatorList{}
+	if err := c.List(ctx, list, client.InNamespace(t.Namespace)); err != nil {
+		return nil, err
+	}
+
+	older := 0
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Name == t.Name || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if createdBefore(other, t) {
+			older++
+		}
+	}
+
+	max := *p.Spec.MaxIdentitiesPerNamespace
+	if int32(older) < max {
+		return nil, nil
+	}
+
+	return field.ErrorList{field.Forbidden(field.NewPath("metadata", "namespace"), fmt.Sprintf("namespace %s already has %d AzureIdentityTerminators, the most AzureIdentityPolicy %s allows", t.Namespace, older, p.Name))}, nil
+}
+
+// createdBefore reports whether a was created before b. An object that has not been created yet comes last,
+// and objects created in the same second are ordered by name.
+func createdBefore(a, b *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	if b.CreationTimestamp.IsZero() {
+		return true
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// scopeAllowed reports whether the scope as it is written, or its full ID, is one of the prefixes or lies beneath
+// one. Prefixes match whole path segments, so a resource group team-a does not allow team-admin.
+func scopeAllowed(prefixes []string, subscriptionID string, scope string) bool {
+	candidates := []string{strings.ToLower(scope), strings.ToLower(azuread.ScopeID(subscriptionID, scope))}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(strings.ToLower(prefix), "/")
+		for _, candidate := range candidates {
+			if candidate == prefix || strings.HasPrefix(candidate, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+func newClient(t *testing.T, objs ...runtime.Object) *fake.ClientBuilder {
+	scheme := runtime.NewScheme()
+	if err := terminatorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(append(objs, namespace)...)
+}
+
+func terminator(name string, created time.Time) *terminatorv1alpha1.AzureIdentityTerminator {
+	return &terminatorv1alpha1.AzureIdentityTerminator{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", CreationTimestamp: metav1.NewTime(created)},
+		Spec: terminatorv1alpha1.AzureIdentityTerminatorSpec{
+			NodeResourceGroup: "node-resource-group",
+			ServicePrincipal: terminatorv1alpha1.ServicePrincipal{
+				ClientSecretDuration: "720h",
+			},
+		},
+	}
+}
+
+func azureIdentityPolicy(name string, spec terminatorv1alpha1.AzureIdentityPolicySpec) *terminatorv1alpha1.AzureIdentityPolicy {
+	return &terminatorv1alpha1.AzureIdentityPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   terminatorv1alpha1.AzureIdentityPolicySpec
+		mutate   func(*terminatorv1alpha1.AzureIdentityTerminator)
+		violated string
+	}{
+		{
+			name:   "allowed role and scope",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{AllowedRoles: []string{"reader"}, AllowedScopePrefixes: []string{"node-resource-group"}},
+			mutate: func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+		},
+		{
+			name:     "default role not allowed",
+			policy:   terminatorv1alpha1.AzureIdentityPolicySpec{AllowedRoles: []string{"Key Vault Secrets User"}},
+			mutate:   func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+			violated: "spec.roleAssignments.role",
+		},
+		{
+			name:   "scope not allowed",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{AllowedScopePrefixes: []string{"/subscriptions/sub/resourceGroups/team-a"}},
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{
+					{Role: "Reader", Scope: "/subscriptions/sub/resourceGroups/TEAM-A/providers/Microsoft.KeyVault/vaults/kv"},
+					{Role: "Reader", Scope: "/subscriptions/sub/resourceGroups/team-b"},
+				}
+			},
+			violated: "spec.roleAssignments[1].scope",
+		},
+		{
+			name:   "scope sharing a prefix with an allowed resource group",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{AllowedScopePrefixes: []string{"/subscriptions/sub/resourceGroups/team-a/"}},
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{
+					{Role: "Reader", Scope: "/subscriptions/sub/resourceGroups/team-a"},
+					{Role: "Reader", Scope: "/subscriptions/sub/resourceGroups/team-ab"},
+					{Role: "Reader", Scope: "team-admin"},
+				}
+			},
+			violated: "spec.roleAssignments[1].scope",
+		},
+		{
+			name:   "resource group scope resolved in the subscription",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{AllowedScopePrefixes: []string{"/subscriptions/sub/resourceGroups/team-a"}},
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{{Role: "Reader", Scope: "team-a"}}
+			},
+		},
+		{
+			name:     "duration above maximum",
+			policy:   terminatorv1alpha1.AzureIdentityPolicySpec{MaxClientSecretDuration: "168h"},
+			mutate:   func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+			violated: "must be at most 168h0m0s under AzureIdentityPolicy test",
+		},
+		{
+			name: "namespace not selected",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{
+				NamespaceSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+				MaxClientSecretDuration: "168h",
+			},
+			mutate: func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+		},
+		{
+			name: "invalid namespace selector",
+			policy: terminatorv1alpha1.AzureIdentityPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Near"}}},
+			},
+			mutate:   func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+			violated: "invalid namespaceSelector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := terminator("test", time.Now())
+			tt.mutate(obj)
+			c := newClient(t, azureIdentityPolicy("test", tt.policy)).Build()
+
+			violations, err := Violations(context.Background(), c, obj, "sub")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.violated == "" {
+				if len(violations) > 0 {
+					t.Fatalf("expected no violations, got %v", violations)
+				}
+				return
+			}
+
+			if !strings.Contains(violations.ToAggregate().Error(), tt.violated) {
+				t.Errorf("expected %q in %v", tt.violated, violations)
+			}
+		})
+	}
+}
+
+func TestViolationsMaxIdentitiesPerNamespace(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	oldest := terminator("oldest", now.Add(-time.Hour))
+	newest := terminator("newest", now)
+	c := newClient(t,
+		azureIdentityPolicy("test", terminatorv1alpha1.AzureIdentityPolicySpec{MaxIdentitiesPerNamespace: func(i int32) *int32 { return &i }(1)}),
+		oldest,
+		newest,
+	).Build()
+
+	if violations, err := Violations(context.Background(), c, oldest, "sub"); err != nil || len(violations) > 0 {
+		t.Errorf("expected the oldest AzureIdentityTerminator to be allowed, got %v, %v", violations, err)
+	}
+
+	violations, err := Violations(context.Background(), c, newest, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(violations.ToAggregate().Error(), "already has 1 AzureIdentityTerminators") {
+		t.Errorf("expected the newest AzureIdentityTerminator to exceed the limit, got %v", violations)
+	}
+
+	// A new AzureIdentityTerminator has no creation time yet and counts every existing one
+	created := terminator("created", time.Time{})
+	if violations, err := Violations(context.Background(), c, created, "sub"); err != nil || len(violations) == 0 {
+		t.Errorf("expected a new AzureIdentityTerminator to exceed the limit, got %v, %v", violations, err)
+	}
+}
+
+func TestViolationsWithoutPolicies(t *testing.T) {
+	c := newClient(t).Build()
+	if violations, err := Violations(context.Background(), c, terminator("test", time.Now()), "sub"); err != nil || len(violations) > 0 {
+		t.Errorf("expected no violations without policies, got %v, %v", violations, err)
+	}
+}
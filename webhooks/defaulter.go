@@ -0,0 +1,182 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+// DefaultPath is the path the TerminatorDefaulter is served on
+const DefaultPath = "/mutate-azidterminator-io-v1alpha1-azureidentityterminator"
+
+// +kubebuilder:webhook:path=/mutate-azidterminator-io-v1alpha1-azureidentityterminator,mutating=true,failurePolicy=fail,sideEffects=None,groups=azidterminator.io,resources=azureidentityterminators,verbs=create,versions=v1alpha1,name=mazureidentityterminator.azidterminator.io,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:rbac:groups=azidterminator.io,resources=azureidentityterminatordefaults,verbs=get;list;watch
+
+// Defaults are the operator-wide values filled into AzureIdentityTerminators. The
+// AzureIdentityTerminatorDefaults of a namespace take precedence over them.
+type Defaults struct {
+	// ClusterName prefixes defaulted display names unless a namespace sets its own prefix
+	ClusterName          string
+	ClientSecretDuration string
+	NodeResourceGroup    string
+	Tags                 []string
+}
+
+// TerminatorDefaulter fills the fields left out of a new AzureIdentityTerminator
+type TerminatorDefaulter struct {
+	Client   client.Reader
+	Defaults Defaults
+
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder used to read AzureIdentityTerminators from admission requests
+func (d *TerminatorDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle defaults a created AzureIdentityTerminator
+func (d *TerminatorDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	t := &terminatorv1alpha1.AzureIdentityTerminator{}
+	if err := d.decoder.Decode(req, t); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// The apiserver only generates the name after mutating admission, so nothing can default to it yet
+	if t.Name == "" {
+		if allErrs := nameDefaultsRequired(t); len(allErrs) > 0 {
+			return denied(t, allErrs)
+		}
+	}
+
+	list := &terminatorv1alpha1.AzureIdentityTerminatorDefaultsList{}
+	if err := d.Client.List(ctx, list, client.InNamespace(req.Namespace)); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	d.applyDefaults(t, req.Namespace, namespaceDefaults(list.Items))
+
+	marshaled, err := json.Marshal(t)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// namespaceDefaults merges the AzureIdentityTerminatorDefaults of a namespace.
+// When several set the same field the first by name wins.
+func namespaceDefaults(items []terminatorv1alpha1.AzureIdentityTerminatorDefaults) terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec {
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+
+	var merged terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec
+	for i := len(items) - 1; i >= 0; i-- {
+		spec := items[i].Spec
+		if spec.ClientSecretDuration != "" {
+			merged.ClientSecretDuration = spec.ClientSecretDuration
+		}
+		if spec.DisplayNamePrefix != "" {
+			merged.DisplayNamePrefix = spec.DisplayNamePrefix
+		}
+		if spec.NodeResourceGroup != "" {
+			merged.NodeResourceGroup = spec.NodeResourceGroup
+		}
+		if spec.Tags != nil {
+			merged.Tags = spec.Tags
+		}
+	}
+
+	return merged
+}
+
+// applyDefaults fills every empty field from the namespace defaults, then from the operator defaults
+func (d *TerminatorDefaulter) applyDefaults(t *terminatorv1alpha1.AzureIdentityTerminator, namespace string, ns terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec) {
+	if t.Spec.AppRegistration.DisplayName == "" {
+		prefix := firstNonEmpty(ns.DisplayNamePrefix, d.Defaults.ClusterName)
+		parts := []string{namespace, t.Name}
+		if prefix != "" {
+			parts = append([]string{prefix}, parts...)
+		}
+		t.Spec.AppRegistration.DisplayName = strings.Join(parts, "-")
+	}
+
+	if t.Spec.NodeResourceGroup == "" {
+		t.Spec.NodeResourceGroup = firstNonEmpty(ns.NodeResourceGroup, d.Defaults.NodeResourceGroup)
+	}
+
+	needsClientSecret := t.Spec.Mode != terminatorv1alpha1.ModeWorkloadIdentity && t.Spec.IdentityType != terminatorv1alpha1.IdentityTypeUserAssignedMSI
+	if t.Spec.ServicePrincipal.ClientSecretDuration == "" && needsClientSecret {
+		t.Spec.ServicePrincipal.ClientSecretDuration = firstNonEmpty(ns.ClientSecretDuration, d.Defaults.ClientSecretDuration)
+	}
+
+	if t.Spec.ServicePrincipal.Tags == nil {
+		if ns.Tags != nil {
+			t.Spec.ServicePrincipal.Tags = ns.Tags
+		} else if len(d.Defaults.Tags) > 0 {
+			t.Spec.ServicePrincipal.Tags = d.Defaults.Tags
+		}
+	}
+
+	if t.Spec.Mode != terminatorv1alpha1.ModeWorkloadIdentity {
+		if t.Spec.AzureIdentityName == "" {
+			t.Spec.AzureIdentityName = t.Name
+		}
+		if t.Spec.PodSelector == "" {
+			t.Spec.PodSelector = t.Name
+		}
+	}
+}
+
+// nameDefaultsRequired returns the fields that default to the name of the AzureIdentityTerminator and are left out
+func nameDefaultsRequired(t *terminatorv1alpha1.AzureIdentityTerminator) field.ErrorList {
+	const detail = "generateName is not supported unless displayName, azureIdentityName and podSelector are set"
+	spec := field.NewPath("spec")
+
+	var allErrs field.ErrorList
+	if t.Spec.AppRegistration.DisplayName == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("appRegistration", "displayName"), detail))
+	}
+	if t.Spec.Mode != terminatorv1alpha1.ModeWorkloadIdentity {
+		if t.Spec.AzureIdentityName == "" {
+			allErrs = append(allErrs, field.Required(spec.Child("azureIdentityName"), detail))
+		}
+		if t.Spec.PodSelector == "" {
+			allErrs = append(allErrs, field.Required(spec.Child("podSelector"), detail))
+		}
+	}
+	return allErrs
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
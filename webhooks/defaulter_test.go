@@ -0,0 +1,237 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+func newDefaulter(t *testing.T, objs ...runtime.Object) *TerminatorDefaulter {
+	scheme := runtime.NewScheme()
+	if err := terminatorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &TerminatorDefaulter{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build(),
+		Defaults: Defaults{
+			ClusterName:          "aks",
+			ClientSecretDuration: "720h",
+			NodeResourceGroup:    "operator-node-resource-group",
+			Tags:                 []string{"operator"},
+		},
+	}
+	d.InjectDecoder(decoder)
+	return d
+}
+
+func namespaceDefaultsObject(name string, spec terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec) *terminatorv1alpha1.AzureIdentityTerminatorDefaults {
+	return &terminatorv1alpha1.AzureIdentityTerminatorDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       spec,
+	}
+}
+
+// defaulted applies the patch returned by the defaulter to the AzureIdentityTerminator
+func defaulted(t *testing.T, d *TerminatorDefaulter, obj *terminatorv1alpha1.AzureIdentityTerminator) *terminatorv1alpha1.AzureIdentityTerminator {
+	req := admissionRequest(t, admissionv1.Create, obj, nil)
+	req.Namespace = obj.Namespace
+
+	resp := d.Handle(context.Background(), req)
+	if !resp.Allowed {
+		t.Fatalf("expected the request to be allowed, got %v", resp.Result)
+	}
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(req.Object.Raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// A defaulter only adds or replaces fields, so the patch is applied by walking each path
+	for _, op := range resp.Patches {
+		if op.Operation != "add" && op.Operation != "replace" {
+			t.Fatalf("unexpected %s operation on %s", op.Operation, op.Path)
+		}
+
+		keys := strings.Split(strings.TrimPrefix(op.Path, "/"), "/")
+		parent := doc
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = op.Value
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &terminatorv1alpha1.AzureIdentityTerminator{}
+	if err := json.Unmarshal(raw, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDefaultFromOperator(t *testing.T) {
+	d := newDefaulter(t)
+	obj := validTerminator()
+	obj.Spec = terminatorv1alpha1.AzureIdentityTerminatorSpec{Mode: terminatorv1alpha1.ModePodIdentity}
+
+	got := defaulted(t, d, obj).Spec
+	if got.AppRegistration.DisplayName != "aks-default-test" {
+		t.Errorf("expected displayName aks-default-test, got %q", got.AppRegistration.DisplayName)
+	}
+	if got.NodeResourceGroup != "operator-node-resource-group" {
+		t.Errorf("expected the operator nodeResourceGroup, got %q", got.NodeResourceGroup)
+	}
+	if got.ServicePrincipal.ClientSecretDuration != "720h" {
+		t.Errorf("expected the operator clientSecretDuration, got %q", got.ServicePrincipal.ClientSecretDuration)
+	}
+	if !reflect.DeepEqual(got.ServicePrincipal.Tags, []string{"operator"}) {
+		t.Errorf("expected the operator tags, got %v", got.ServicePrincipal.Tags)
+	}
+	if got.AzureIdentityName != "test" || got.PodSelector != "test" {
+		t.Errorf("expected azureIdentityName and podSelector to default to the name, got %q and %q", got.AzureIdentityName, got.PodSelector)
+	}
+}
+
+func TestDefaultFromNamespace(t *testing.T) {
+	d := newDefaulter(t,
+		namespaceDefaultsObject("b", terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec{
+			ClientSecretDuration: "48h",
+			NodeResourceGroup:    "b-node-resource-group",
+		}),
+		namespaceDefaultsObject("a", terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec{
+			DisplayNamePrefix: "team-a",
+			NodeResourceGroup: "a-node-resource-group",
+			Tags:              []string{"team-a"},
+		}),
+	)
+	obj := validTerminator()
+	obj.Spec = terminatorv1alpha1.AzureIdentityTerminatorSpec{Mode: terminatorv1alpha1.ModePodIdentity}
+
+	got := defaulted(t, d, obj).Spec
+	if got.AppRegistration.DisplayName != "team-a-default-test" {
+		t.Errorf("expected displayName team-a-default-test, got %q", got.AppRegistration.DisplayName)
+	}
+	if got.NodeResourceGroup != "a-node-resource-group" {
+		t.Errorf("expected the first defaults by name to win, got %q", got.NodeResourceGroup)
+	}
+	if got.ServicePrincipal.ClientSecretDuration != "48h" {
+		t.Errorf("expected the namespace clientSecretDuration, got %q", got.ServicePrincipal.ClientSecretDuration)
+	}
+	if !reflect.DeepEqual(got.ServicePrincipal.Tags, []string{"team-a"}) {
+		t.Errorf("expected the namespace tags, got %v", got.ServicePrincipal.Tags)
+	}
+}
+
+func TestDefaultKeepsSpec(t *testing.T) {
+	d := newDefaulter(t, namespaceDefaultsObject("a", terminatorv1alpha1.AzureIdentityTerminatorDefaultsSpec{
+		NodeResourceGroup: "a-node-resource-group",
+	}))
+	obj := validTerminator()
+	obj.Spec.ServicePrincipal.Tags = []string{"own"}
+
+	if got := defaulted(t, d, obj).Spec; !reflect.DeepEqual(got, obj.Spec) {
+		t.Errorf("expected a complete spec to be left unchanged, got %+v", got)
+	}
+}
+
+func TestDefaultWorkloadIdentity(t *testing.T) {
+	d := newDefaulter(t)
+	obj := validTerminator()
+	obj.Spec = terminatorv1alpha1.AzureIdentityTerminatorSpec{
+		Mode:             terminatorv1alpha1.ModeWorkloadIdentity,
+		WorkloadIdentity: &terminatorv1alpha1.WorkloadIdentity{ServiceAccountName: "workload"},
+	}
+
+	got := defaulted(t, d, obj).Spec
+	if got.AzureIdentityName != "" || got.PodSelector != "" || got.ServicePrincipal.ClientSecretDuration != "" {
+		t.Errorf("expected no pod identity fields in workloadIdentity mode, got %+v", got)
+	}
+}
+
+func TestDefaultUserAssignedMSI(t *testing.T) {
+	d := newDefaulter(t)
+	obj := validTerminator()
+	obj.Spec = terminatorv1alpha1.AzureIdentityTerminatorSpec{
+		Mode:         terminatorv1alpha1.ModePodIdentity,
+		IdentityType: terminatorv1alpha1.IdentityTypeUserAssignedMSI,
+	}
+
+	got := defaulted(t, d, obj).Spec
+	if got.ServicePrincipal.ClientSecretDuration != "" {
+		t.Errorf("expected no clientSecretDuration for a user-assigned managed identity, got %q", got.ServicePrincipal.ClientSecretDuration)
+	}
+	if got.AzureIdentityName != "test" || got.PodSelector != "test" {
+		t.Errorf("expected azureIdentityName and podSelector to default to the name, got %q and %q", got.AzureIdentityName, got.PodSelector)
+	}
+}
+
+func TestDefaultGenerateName(t *testing.T) {
+	d := newDefaulter(t)
+	obj := validTerminator()
+	obj.Name = ""
+	obj.GenerateName = "test-"
+	obj.Spec = terminatorv1alpha1.AzureIdentityTerminatorSpec{Mode: terminatorv1alpha1.ModePodIdentity}
+
+	req := admissionRequest(t, admissionv1.Create, obj, nil)
+	req.Namespace = obj.Namespace
+	resp := d.Handle(context.Background(), req)
+	if resp.Allowed {
+		t.Fatal("expected a generated name without displayName, azureIdentityName and podSelector to be denied")
+	}
+	for _, want := range []string{"spec.appRegistration.displayName", "spec.azureIdentityName", "spec.podSelector", "generateName is not supported"} {
+		if !strings.Contains(resp.Result.Message, want) {
+			t.Errorf("expected %q in %q", want, resp.Result.Message)
+		}
+	}
+
+	obj.Spec.AppRegistration.DisplayName = "generated"
+	obj.Spec.AzureIdentityName = "generated"
+	obj.Spec.PodSelector = "generated"
+	got := defaulted(t, d, obj).Spec
+	if got.AppRegistration.DisplayName != "generated" || got.AzureIdentityName != "generated" || got.PodSelector != "generated" {
+		t.Errorf("expected the fields set with a generated name to be kept, got %+v", got)
+	}
+	if got.NodeResourceGroup != "operator-node-resource-group" {
+		t.Errorf("expected the other fields to be defaulted, got %q", got.NodeResourceGroup)
+	}
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks contains the admission webhooks for AzureIdentityTerminators
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+	"github.com/tonedefdev/azure-identity-terminator/pkg/policy"
+)
+
+// ValidatePath is the path the TerminatorValidator is served on
+const ValidatePath = "/validate-azidterminator-io-v1alpha1-azureidentityterminator"
+
+// +kubebuilder:webhook:path=/validate-azidterminator-io-v1alpha1-azureidentityterminator,mutating=false,failurePolicy=fail,sideEffects=None,groups=azidterminator.io,resources=azureidentityterminators,verbs=create;update;delete,versions=v1alpha1,name=vazureidentityterminator.azidterminator.io,admissionReviewVersions={v1,v1beta1}
+
+// TerminatorValidator rejects AzureIdentityTerminator specs that would otherwise fail while provisioning
+type TerminatorValidator struct {
+	// MinClientSecretDuration is the shortest clientSecretDuration allowed. Zero disables the check.
+	MinClientSecretDuration time.Duration
+	// MaxClientSecretDuration is the longest clientSecretDuration allowed. Zero disables the check.
+	MaxClientSecretDuration time.Duration
+	// Client reads the AzureIdentityPolicies the AzureIdentityTerminator must comply with and the other
+	// AzureIdentityTerminators of its namespace whose names it must not reuse. Nil disables both checks.
+	Client client.Reader
+	// SubscriptionID resolves the resource group scopes that AzureIdentityPolicies allow by their full ID
+	SubscriptionID string
+
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder used to read AzureIdentityTerminators from admission requests
+func (v *TerminatorValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle validates a created or updated AzureIdentityTerminator and rejects the deletion of a protected one
+func (v *TerminatorValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		return v.handleDelete(req)
+	}
+
+	t := &terminatorv1alpha1.AzureIdentityTerminator{}
+	if err := v.decoder.Decode(req, t); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var allErrs field.ErrorList
+	if req.Operation == admissionv1.Update {
+		old := &terminatorv1alpha1.AzureIdentityTerminator{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		// Objects admitted before the webhook existed must still be able to drop their finalizer
+		if !t.DeletionTimestamp.IsZero() || reflect.DeepEqual(old.Spec, t.Spec) {
+			return admission.Allowed("")
+		}
+
+		allErrs = append(allErrs, validateImmutableFields(old, t)...)
+	}
+
+	allErrs = append(allErrs, v.validateSpec(t)...)
+	if v.Client != nil {
+		if t.Namespace == "" {
+			t.Namespace = req.Namespace
+		}
+
+		violations, err := policy.Violations(ctx, v.Client, t, v.SubscriptionID)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		allErrs = append(allErrs, violations...)
+
+		duplicates, err := v.validateUniqueNames(ctx, t)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		allErrs = append(allErrs, duplicates...)
+	}
+
+	if len(allErrs) > 0 {
+		return denied(t, allErrs)
+	}
+
+	return admission.Allowed("")
+}
+
+// validateSpec checks the spec of the AzureIdentityTerminator can be provisioned
+func (v *TerminatorValidator) validateSpec(t *terminatorv1alpha1.AzureIdentityTerminator) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+
+	if t.Spec.AppRegistration.DisplayName == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("appRegistration", "displayName"), ""))
+	}
+
+	if t.Spec.NodeResourceGroup == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("nodeResourceGroup"), ""))
+	}
+
+	allErrs = append(allErrs, validateRoleAssignments(t.Spec.RoleAssignments, spec.Child("roleAssignments"))...)
+
+	sp := spec.Child("servicePrincipal")
+	if t.Spec.Mode == terminatorv1alpha1.ModeWorkloadIdentity {
+		allErrs = append(allErrs, validateWorkloadIdentity(t.Spec.WorkloadIdentity, spec.Child("workloadIdentity"))...)
+		if t.Spec.IdentityType == terminatorv1alpha1.IdentityTypeUserAssignedMSI {
+			allErrs = append(allErrs, field.Invalid(spec.Child("identityType"), t.Spec.IdentityType, "only supported in podIdentity mode"))
+		}
+		if t.Spec.ServicePrincipal.ClientSecretDuration != "" {
+			allErrs = append(allErrs, v.validateClientSecretDuration(t.Spec.ServicePrincipal.ClientSecretDuration, sp.Child("clientSecretDuration"))...)
+		}
+	} else {
+		allErrs = append(allErrs, validatePodIdentity(t, spec)...)
+		// A user-assigned managed identity has no ClientSecret
+		if t.Spec.IdentityType != terminatorv1alpha1.IdentityTypeUserAssignedMSI || t.Spec.ServicePrincipal.ClientSecretDuration != "" {
+			allErrs = append(allErrs, v.validateClientSecretDuration(t.Spec.ServicePrincipal.ClientSecretDuration, sp.Child("clientSecretDuration"))...)
+		}
+	}
+
+	// A user-assigned managed identity has no Service Principal of its own to disable
+	if t.Spec.IdentityType == terminatorv1alpha1.IdentityTypeUserAssignedMSI && t.Spec.DeletionPolicy == terminatorv1alpha1.DeletionPolicyDisable {
+		allErrs = append(allErrs, field.NotSupported(spec.Child("deletionPolicy"), t.Spec.DeletionPolicy,
+			[]string{string(terminatorv1alpha1.DeletionPolicyDelete), string(terminatorv1alpha1.DeletionPolicyRetain)}))
+	}
+
+	allErrs = append(allErrs, validateDuration(t.Spec.ServicePrincipal.RotateBefore, sp.Child("rotateBefore"))...)
+	allErrs = append(allErrs, validateDuration(t.Spec.ServicePrincipal.RotationGracePeriod, sp.Child("rotationGracePeriod"))...)
+	allErrs = append(allErrs, validateRotationGracePeriod(&t.Spec.ServicePrincipal, sp.Child("rotationGracePeriod"))...)
+	return allErrs
+}
+
+// validateRotationGracePeriod checks the previous ClientSecret is removed before the next rotation replaces it,
+// which only one previous ClientSecret being tracked at a time relies on
+func validateRotationGracePeriod(sp *terminatorv1alpha1.ServicePrincipal, path *field.Path) field.ErrorList {
+	gracePeriod, err := time.ParseDuration(sp.RotationGracePeriod)
+	if err != nil || gracePeriod < 0 {
+		return nil
+	}
+
+	lifetime, err := time.ParseDuration(sp.ClientSecretDuration)
+	if err != nil || lifetime <= 0 {
+		return nil
+	}
+
+	rotateBefore, _ := sp.RotationWindow()
+	if interval := lifetime - rotateBefore; gracePeriod >= interval {
+		return field.ErrorList{field.Invalid(path, sp.RotationGracePeriod, fmt.Sprintf("must be shorter than the %s between rotations", interval))}
+	}
+
+	return nil
+}
+
+// validatePodIdentity checks the fields used to bind pods through aad-pod-identity
+func validatePodIdentity(t *terminatorv1alpha1.AzureIdentityTerminator, spec *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if t.Spec.AzureIdentityName == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("azureIdentityName"), "required in podIdentity mode"))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(t.Spec.AzureIdentityName) {
+			allErrs = append(allErrs, field.Invalid(spec.Child("azureIdentityName"), t.Spec.AzureIdentityName, msg))
+		}
+	}
+
+	if t.Spec.SecretName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(t.Spec.SecretName) {
+			allErrs = append(allErrs, field.Invalid(spec.Child("secretName"), t.Spec.SecretName, msg))
+		}
+	}
+
+	if t.Spec.PodSelector == "" {
+		allErrs = append(allErrs, field.Required(spec.Child("podSelector"), "required in podIdentity mode"))
+	} else {
+		for _, msg := range validation.IsValidLabelValue(t.Spec.PodSelector) {
+			allErrs = append(allErrs, field.Invalid(spec.Child("podSelector"), t.Spec.PodSelector, msg))
+		}
+	}
+
+	return allErrs
+}
+
+// validateUniqueNames checks no other AzureIdentityTerminator in the namespace creates an AzureIdentity or Secret
+// under the same name, which the two would otherwise overwrite and delete for each other
+func (v *TerminatorValidator) validateUniqueNames(ctx context.Context, t *terminatorv1alpha1.AzureIdentityTerminator) (field.ErrorList, error) {
+	if t.Spec.Mode == terminatorv1alpha1.ModeWorkloadIdentity {
+		return nil, nil
+	}
+
+	list := &terminatorv1alpha1.AzureIdentityTerminatorList{}
+	if err := v.Client.List(ctx, list, client.InNamespace(t.Namespace)); err != nil {
+		return nil, err
+	}
+
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Name == t.Name || other.Spec.Mode == terminatorv1alpha1.ModeWorkloadIdentity {
+			continue
+		}
+
+		if podIdentityName(t.Spec.AzureIdentityName, t) == podIdentityName(other.Spec.AzureIdentityName, other) {
+			allErrs = append(allErrs, field.Invalid(spec.Child("azureIdentityName"), podIdentityName(t.Spec.AzureIdentityName, t), "already used by AzureIdentityTerminator "+other.Name))
+		}
+
+		// A user-assigned managed identity has no Secret
+		if usesSecret(t) && usesSecret(other) && podIdentityName(t.Spec.SecretName, t) == podIdentityName(other.Spec.SecretName, other) {
+			allErrs = append(allErrs, field.Invalid(spec.Child("secretName"), podIdentityName(t.Spec.SecretName, t), "already used by AzureIdentityTerminator "+other.Name))
+		}
+	}
+
+	return allErrs, nil
+}
+
+// podIdentityName returns the name given in the spec, or the name of the AzureIdentityTerminator the controller uses in its place
+func podIdentityName(name string, t *terminatorv1alpha1.AzureIdentityTerminator) string {
+	if name != "" {
+		return name
+	}
+	return t.Name
+}
+
+func usesSecret(t *terminatorv1alpha1.AzureIdentityTerminator) bool {
+	return t.Spec.IdentityType != terminatorv1alpha1.IdentityTypeUserAssignedMSI
+}
+
+// validateRoleAssignments checks each role assignment names a role and a resource group or resource ID, and is not repeated
+func validateRoleAssignments(roleAssignments []terminatorv1alpha1.RoleAssignmentSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[terminatorv1alpha1.RoleAssignmentSpec]bool{}
+	for i, ra := range roleAssignments {
+		if ra.Role == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("role"), ""))
+		}
+
+		if strings.HasPrefix(ra.Scope, "/") {
+			if !strings.HasPrefix(strings.ToLower(ra.Scope), "/subscriptions/") {
+				allErrs = append(allErrs, field.Invalid(path.Index(i).Child("scope"), ra.Scope, "must be a resource group name or an ID starting with /subscriptions/"))
+			}
+		} else if strings.Contains(ra.Scope, "/") {
+			allErrs = append(allErrs, field.Invalid(path.Index(i).Child("scope"), ra.Scope, "must be a resource group name or an ID starting with /subscriptions/"))
+		}
+
+		if seen[ra] {
+			allErrs = append(allErrs, field.Duplicate(path.Index(i), ra.Role+" over "+ra.Scope))
+		}
+		seen[ra] = true
+	}
+
+	return allErrs
+}
+
+// validateWorkloadIdentity checks the ServiceAccount and issuer trusted by the federated identity credential
+func validateWorkloadIdentity(wi *terminatorv1alpha1.WorkloadIdentity, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if wi == nil || wi.ServiceAccountName == "" {
+		return append(allErrs, field.Required(path.Child("serviceAccountName"), "required in workloadIdentity mode"))
+	}
+
+	for _, msg := range validation.IsDNS1123Subdomain(wi.ServiceAccountName) {
+		allErrs = append(allErrs, field.Invalid(path.Child("serviceAccountName"), wi.ServiceAccountName, msg))
+	}
+
+	if wi.Issuer != "" {
+		if u, err := url.Parse(wi.Issuer); err != nil || u.Scheme != "https" || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(path.Child("issuer"), wi.Issuer, "must be an https URL"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateClientSecretDuration checks the ClientSecret lifetime parses and is within the bounds set for the operator
+func (v *TerminatorValidator) validateClientSecretDuration(value string, path *field.Path) field.ErrorList {
+	if value == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, err.Error())}
+	}
+
+	if d <= 0 {
+		return field.ErrorList{field.Invalid(path, value, "must be greater than zero")}
+	}
+
+	if v.MinClientSecretDuration > 0 && d < v.MinClientSecretDuration {
+		return field.ErrorList{field.Invalid(path, value, fmt.Sprintf("must be at least %s", v.MinClientSecretDuration))}
+	}
+
+	if v.MaxClientSecretDuration > 0 && d > v.MaxClientSecretDuration {
+		return field.ErrorList{field.Invalid(path, value, fmt.Sprintf("must be at most %s", v.MaxClientSecretDuration))}
+	}
+
+	return nil
+}
+
+// validateDuration checks an optional duration parses and is not negative
+func validateDuration(value string, path *field.Path) field.ErrorList {
+	if value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, err.Error())}
+	}
+
+	if d < 0 {
+		return field.ErrorList{field.Invalid(path, value, "must not be negative")}
+	}
+
+	return nil
+}
+
+// handleDelete rejects the deletion of an AzureIdentityTerminator protected by the DeletionProtectionAnnotation
+func (v *TerminatorValidator) handleDelete(req admission.Request) admission.Response {
+	old := &terminatorv1alpha1.AzureIdentityTerminator{}
+	if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if !old.DeletionProtected() {
+		return admission.Allowed("")
+	}
+
+	gr := terminatorv1alpha1.GroupVersion.WithResource("azureidentityterminators").GroupResource()
+	status := apierrors.NewForbidden(gr, old.Name, fmt.Errorf("remove the %s annotation first", terminatorv1alpha1.DeletionProtectionAnnotation)).Status()
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result:  &status,
+		},
+	}
+}
+
+// validateImmutableFields rejects changes to the fields that select which kind of identity is created for the
+// AzureIdentityTerminator. Every other field is updated in place by the controller.
+func validateImmutableFields(old, t *terminatorv1alpha1.AzureIdentityTerminator) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+
+	immutable := func(path *field.Path, oldValue, newValue interface{}) {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			allErrs = append(allErrs, field.Forbidden(path, "field is immutable"))
+		}
+	}
+
+	immutable(spec.Child("mode"), old.Spec.Mode, t.Spec.Mode)
+	immutable(spec.Child("identityType"), old.Spec.IdentityType, t.Spec.IdentityType)
+	immutable(spec.Child("workloadIdentity"), old.Spec.WorkloadIdentity, t.Spec.WorkloadIdentity)
+	return allErrs
+}
+
+// denied rejects the AzureIdentityTerminator with the same Invalid status the API server uses for schema errors
+func denied(t *terminatorv1alpha1.AzureIdentityTerminator, allErrs field.ErrorList) admission.Response {
+	gk := terminatorv1alpha1.GroupVersion.WithKind("AzureIdentityTerminator").GroupKind()
+	status := apierrors.NewInvalid(gk, t.Name, allErrs).Status()
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result:  &status,
+		},
+	}
+}
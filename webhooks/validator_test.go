@@ -0,0 +1,372 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	terminatorv1alpha1 "github.com/tonedefdev/azure-identity-terminator/api/v1alpha1"
+)
+
+func newValidator(t *testing.T) *TerminatorValidator {
+	scheme := runtime.NewScheme()
+	if err := terminatorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &TerminatorValidator{
+		MinClientSecretDuration: time.Hour,
+		MaxClientSecretDuration: 30 * 24 * time.Hour,
+	}
+	v.InjectDecoder(decoder)
+	return v
+}
+
+func validTerminator() *terminatorv1alpha1.AzureIdentityTerminator {
+	return &terminatorv1alpha1.AzureIdentityTerminator{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: terminatorv1alpha1.GroupVersion.String(),
+			Kind:       "AzureIdentityTerminator",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: terminatorv1alpha1.AzureIdentityTerminatorSpec{
+			AppRegistration: terminatorv1alpha1.AppRegistration{
+				DisplayName: "test",
+			},
+			AzureIdentityName: "test",
+			Mode:              terminatorv1alpha1.ModePodIdentity,
+			NodeResourceGroup: "node-resource-group",
+			PodSelector:       "test",
+			ServicePrincipal: terminatorv1alpha1.ServicePrincipal{
+				ClientSecretDuration: "720h",
+			},
+		},
+	}
+}
+
+func admissionRequest(t *testing.T, op admissionv1.Operation, obj, old *terminatorv1alpha1.AzureIdentityTerminator) admission.Request {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: op,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+
+	if old != nil {
+		if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return req
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*terminatorv1alpha1.AzureIdentityTerminator)
+		denied string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*terminatorv1alpha1.AzureIdentityTerminator) {},
+		},
+		{
+			name:   "unparsable duration",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.ServicePrincipal.ClientSecretDuration = "30 days" },
+			denied: "spec.servicePrincipal.clientSecretDuration",
+		},
+		{
+			name:   "duration below minimum",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.ServicePrincipal.ClientSecretDuration = "10m" },
+			denied: "must be at least 1h0m0s",
+		},
+		{
+			name:   "duration above maximum",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.ServicePrincipal.ClientSecretDuration = "8760h" },
+			denied: "must be at most 720h0m0s",
+		},
+		{
+			name: "grace period shorter than the rotation interval",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.ServicePrincipal.ClientSecretDuration = "24h"
+				t.Spec.ServicePrincipal.RotationGracePeriod = "6h"
+			},
+		},
+		{
+			name: "grace period outlasting the rotation interval",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.ServicePrincipal.ClientSecretDuration = "24h"
+				t.Spec.ServicePrincipal.RotationGracePeriod = "24h"
+			},
+			denied: "spec.servicePrincipal.rotationGracePeriod",
+		},
+		{
+			name:   "invalid pod selector",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.PodSelector = "not a label" },
+			denied: "spec.podSelector",
+		},
+		{
+			name:   "secret name",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.SecretName = "azure-kv-access" },
+		},
+		{
+			name:   "invalid secret name",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.SecretName = "Not_A_Name" },
+			denied: "spec.secretName",
+		},
+		{
+			name:   "empty node resource group",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.NodeResourceGroup = "" },
+			denied: "spec.nodeResourceGroup",
+		},
+		{
+			name:   "empty display name",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) { t.Spec.AppRegistration.DisplayName = "" },
+			denied: "spec.appRegistration.displayName",
+		},
+		{
+			name: "role assignments",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{
+					{Role: "Reader"},
+					{Role: "Key Vault Secrets User", Scope: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv"},
+				}
+			},
+		},
+		{
+			name: "role assignment with relative resource ID",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{{Role: "Reader", Scope: "rg/providers/Microsoft.KeyVault/vaults/kv"}}
+			},
+			denied: "spec.roleAssignments[0].scope",
+		},
+		{
+			name: "duplicate role assignment",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{{Role: "Reader"}, {Role: "Reader"}}
+			},
+			denied: "spec.roleAssignments[1]: Duplicate value",
+		},
+		{
+			name: "workload identity without service account",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.Mode = terminatorv1alpha1.ModeWorkloadIdentity
+			},
+			denied: "spec.workloadIdentity.serviceAccountName",
+		},
+		{
+			name: "workload identity",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.Mode = terminatorv1alpha1.ModeWorkloadIdentity
+				t.Spec.PodSelector = ""
+				t.Spec.ServicePrincipal.ClientSecretDuration = ""
+				t.Spec.WorkloadIdentity = &terminatorv1alpha1.WorkloadIdentity{ServiceAccountName: "workload"}
+			},
+		},
+		{
+			name: "user-assigned managed identity",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.IdentityType = terminatorv1alpha1.IdentityTypeUserAssignedMSI
+				t.Spec.ServicePrincipal.ClientSecretDuration = ""
+			},
+		},
+		{
+			name: "user-assigned managed identity in workloadIdentity mode",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.Mode = terminatorv1alpha1.ModeWorkloadIdentity
+				t.Spec.IdentityType = terminatorv1alpha1.IdentityTypeUserAssignedMSI
+				t.Spec.WorkloadIdentity = &terminatorv1alpha1.WorkloadIdentity{ServiceAccountName: "workload"}
+			},
+			denied: "spec.identityType",
+		},
+		{
+			name: "disabling a user-assigned managed identity",
+			mutate: func(t *terminatorv1alpha1.AzureIdentityTerminator) {
+				t.Spec.IdentityType = terminatorv1alpha1.IdentityTypeUserAssignedMSI
+				t.Spec.DeletionPolicy = terminatorv1alpha1.DeletionPolicyDisable
+			},
+			denied: "spec.deletionPolicy",
+		},
+	}
+
+	v := newValidator(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := validTerminator()
+			tt.mutate(obj)
+
+			resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, obj, nil))
+			if tt.denied == "" {
+				if !resp.Allowed {
+					t.Fatalf("expected the request to be allowed, got %v", resp.Result)
+				}
+				return
+			}
+
+			if resp.Allowed {
+				t.Fatalf("expected the request to be denied with %q", tt.denied)
+			}
+			if !strings.Contains(resp.Result.Message, tt.denied) {
+				t.Errorf("expected %q in %q", tt.denied, resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	v := newValidator(t)
+	old := validTerminator()
+
+	updated := validTerminator()
+	updated.Spec.ServicePrincipal.ClientSecretDuration = "360h"
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Update, updated, old)); !resp.Allowed {
+		t.Errorf("expected a change to clientSecretDuration to be allowed, got %v", resp.Result)
+	}
+
+	updated = validTerminator()
+	updated.Spec.AzureIdentityName = "renamed"
+	updated.Spec.AppRegistration.DisplayName = "renamed"
+	updated.Spec.NodeResourceGroup = "other-node-resource-group"
+	updated.Spec.PodSelector = "renamed"
+	updated.Spec.ServicePrincipal.Tags = []string{"renamed"}
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Update, updated, old)); !resp.Allowed {
+		t.Errorf("expected changes to fields updated in place to be allowed, got %v", resp.Result)
+	}
+
+	updated = validTerminator()
+	updated.Spec.IdentityType = terminatorv1alpha1.IdentityTypeUserAssignedMSI
+	resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Update, updated, old))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.identityType: Forbidden") {
+		t.Errorf("expected a change to identityType to be denied, got %v", resp.Result)
+	}
+
+	// An object admitted before the webhook existed can still be updated if its spec is unchanged
+	invalid := validTerminator()
+	invalid.Spec.ServicePrincipal.ClientSecretDuration = "forever"
+	labelled := invalid.DeepCopy()
+	labelled.Labels = map[string]string{"team": "a"}
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Update, labelled, invalid)); !resp.Allowed {
+		t.Errorf("expected an update that leaves the spec unchanged to be allowed, got %v", resp.Result)
+	}
+}
+
+func TestValidateDelete(t *testing.T) {
+	v := newValidator(t)
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Delete, nil, validTerminator())); !resp.Allowed {
+		t.Errorf("expected the deletion to be allowed, got %v", resp.Result)
+	}
+
+	protected := validTerminator()
+	protected.Annotations = map[string]string{terminatorv1alpha1.DeletionProtectionAnnotation: "true"}
+	resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Delete, nil, protected))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, terminatorv1alpha1.DeletionProtectionAnnotation) {
+		t.Errorf("expected the deletion of a protected terminator to be denied, got %v", resp.Result)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := terminatorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newValidator(t)
+	v.Client = fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&terminatorv1alpha1.AzureIdentityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "readers"},
+			Spec:       terminatorv1alpha1.AzureIdentityPolicySpec{AllowedRoles: []string{"Reader"}},
+		},
+	).Build()
+
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, validTerminator(), nil)); !resp.Allowed {
+		t.Errorf("expected the default Reader role to be allowed, got %v", resp.Result)
+	}
+
+	obj := validTerminator()
+	obj.Spec.RoleAssignments = []terminatorv1alpha1.RoleAssignmentSpec{{Role: "Owner"}}
+	resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, obj, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "not allowed by AzureIdentityPolicy readers") {
+		t.Errorf("expected the Owner role to be denied by the policy, got %v", resp.Result)
+	}
+}
+
+func TestValidateUniqueNames(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := terminatorv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	existing := validTerminator()
+	existing.Name = "existing"
+	existing.Spec.AzureIdentityName = "shared"
+	existing.Spec.SecretName = "shared-secret"
+
+	v := newValidator(t)
+	v.Client = fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(existing).Build()
+
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, validTerminator(), nil)); !resp.Allowed {
+		t.Errorf("expected distinct names to be allowed, got %v", resp.Result)
+	}
+
+	obj := validTerminator()
+	obj.Spec.AzureIdentityName = "shared"
+	resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, obj, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.azureIdentityName") {
+		t.Errorf("expected a duplicate azureIdentityName to be denied, got %v", resp.Result)
+	}
+
+	obj = validTerminator()
+	obj.Spec.SecretName = "shared-secret"
+	resp = v.Handle(context.Background(), admissionRequest(t, admissionv1.Create, obj, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.secretName") {
+		t.Errorf("expected a duplicate secretName to be denied, got %v", resp.Result)
+	}
+
+	// Updating the AzureIdentityTerminator that holds the names does not conflict with itself
+	updated := existing.DeepCopy()
+	updated.Spec.PodSelector = "renamed"
+	if resp := v.Handle(context.Background(), admissionRequest(t, admissionv1.Update, updated, existing)); !resp.Allowed {
+		t.Errorf("expected an update keeping the names to be allowed, got %v", resp.Result)
+	}
+}